@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"testing"
 
 	"github.com/sashabaranov/go-openai"
@@ -13,18 +14,18 @@ import (
 )
 
 func TestNewAgent(t *testing.T) {
-	mockClient := &openai.Client{}
+	provider := NewOpenAIProvider(&openai.Client{})
 	mockInputManager := &InputManager{}
 	model := "test-model"
 
-	agent := NewAgent(mockClient, mockInputManager, model)
+	agent := NewAgent(provider, mockInputManager, model)
 
 	assert.NotNil(t, agent)
-	assert.Equal(t, mockClient, agent.client)
+	assert.Equal(t, provider, agent.provider)
 	assert.Equal(t, mockInputManager, agent.inputManager)
 	assert.Equal(t, model, agent.model)
-	assert.Len(t, agent.tools, 4) // read_file, list_dir, write_to_file, run_agent
-	assert.Len(t, agent.toolHandlers, 4)
+	assert.Len(t, agent.tools, 14) // file tools, git tools, run_agent and run_agents
+	assert.Len(t, agent.toolHandlers, 14)
 }
 
 func TestAgent_SetupTools(t *testing.T) {
@@ -35,7 +36,7 @@ func TestAgent_SetupTools(t *testing.T) {
 
 	agent.setupTools()
 
-	expectedTools := []string{"read_file", "list_dir", "write_to_file", "run_agent"}
+	expectedTools := []string{"read_file", "list_dir", "write_to_file", "move_file", "copy_file", "delete_file", "make_dir", "git_status", "git_diff", "git_log", "git_blame", "git_show", "run_agent", "run_agents"}
 	assert.Len(t, agent.tools, len(expectedTools))
 	assert.Len(t, agent.toolHandlers, len(expectedTools))
 
@@ -52,7 +53,7 @@ func TestAgent_CreateErrorResponse(t *testing.T) {
 
 	response := agent.createErrorResponse(toolCallID, errorMsg)
 
-	assert.Equal(t, openai.ChatMessageRoleTool, response.Role)
+	assert.Equal(t, RoleTool, response.Role)
 	assert.Equal(t, errorMsg, response.Content)
 	assert.Equal(t, toolCallID, response.ToolCallID)
 }
@@ -60,17 +61,17 @@ func TestAgent_CreateErrorResponse(t *testing.T) {
 func TestAgent_CreateChatCompletion_Success(t *testing.T) {
 	mockClient := mocks.NewMockOpenAIClient()
 	agent := &Agent{
-		client: mockClient,
-		model:  "test-model",
-		tools:  []openai.Tool{},
+		provider: NewOpenAIProvider(mockClient),
+		model:    "test-model",
+		tools:    []ToolDefinition{},
 	}
 
 	expectedResponse := mocks.CreateMockResponse("Hello, world!", nil)
 	mockClient.AddResponse(expectedResponse)
 
-	messages := []openai.ChatCompletionMessage{
+	messages := []Message{
 		{
-			Role:    openai.ChatMessageRoleUser,
+			Role:    RoleUser,
 			Content: "Hello",
 		},
 	}
@@ -79,24 +80,24 @@ func TestAgent_CreateChatCompletion_Success(t *testing.T) {
 
 	require.NoError(t, err)
 	assert.Equal(t, "Hello, world!", response.Content)
-	assert.Equal(t, openai.ChatMessageRoleAssistant, response.Role)
+	assert.Equal(t, RoleAssistant, response.Role)
 	assert.Equal(t, 1, mockClient.CallCount)
 }
 
 func TestAgent_CreateChatCompletion_Error(t *testing.T) {
 	mockClient := mocks.NewMockOpenAIClient()
 	agent := &Agent{
-		client: mockClient,
-		model:  "test-model",
-		tools:  []openai.Tool{},
+		provider: NewOpenAIProvider(mockClient),
+		model:    "test-model",
+		tools:    []ToolDefinition{},
 	}
 
 	expectedError := assert.AnError
 	mockClient.AddError(expectedError)
 
-	messages := []openai.ChatCompletionMessage{
+	messages := []Message{
 		{
-			Role:    openai.ChatMessageRoleUser,
+			Role:    RoleUser,
 			Content: "Hello",
 		},
 	}
@@ -111,18 +112,18 @@ func TestAgent_CreateChatCompletion_Error(t *testing.T) {
 func TestAgent_DriveConversation_NoToolCalls(t *testing.T) {
 	mockClient := mocks.NewMockOpenAIClient()
 	agent := &Agent{
-		client:       mockClient,
+		provider:     NewOpenAIProvider(mockClient),
 		toolHandlers: make(map[string]ToolHandler),
 		model:        "test-model",
-		tools:        []openai.Tool{},
+		tools:        []ToolDefinition{},
 	}
 
 	response := mocks.CreateMockResponse("Simple response without tool calls", nil)
 	mockClient.AddResponse(response)
 
-	initialMessages := []openai.ChatCompletionMessage{
+	initialMessages := []Message{
 		{
-			Role:    openai.ChatMessageRoleUser,
+			Role:    RoleUser,
 			Content: "Hello",
 		},
 	}
@@ -132,7 +133,7 @@ func TestAgent_DriveConversation_NoToolCalls(t *testing.T) {
 		logMessages = append(logMessages, format)
 	}
 
-	finalMessages, err := agent.DriveConversation(context.Background(), initialMessages, logf)
+	finalMessages, err := agent.DriveConversation(context.Background(), initialMessages, logf, nil)
 
 	require.NoError(t, err)
 	assert.Len(t, finalMessages, 2) // Original user message + assistant response
@@ -143,10 +144,10 @@ func TestAgent_DriveConversation_NoToolCalls(t *testing.T) {
 func TestAgent_DriveConversation_WithToolCalls(t *testing.T) {
 	mockClient := mocks.NewMockOpenAIClient()
 	agent := &Agent{
-		client:       mockClient,
+		provider:     NewOpenAIProvider(mockClient),
 		toolHandlers: make(map[string]ToolHandler),
 		model:        "test-model",
-		tools:        []openai.Tool{},
+		tools:        []ToolDefinition{},
 	}
 	agent.setupTools()
 
@@ -160,9 +161,9 @@ func TestAgent_DriveConversation_WithToolCalls(t *testing.T) {
 	mockClient.AddResponse(firstResponse)
 	mockClient.AddResponse(secondResponse)
 
-	initialMessages := []openai.ChatCompletionMessage{
+	initialMessages := []Message{
 		{
-			Role:    openai.ChatMessageRoleUser,
+			Role:    RoleUser,
 			Content: "Read the sample file",
 		},
 	}
@@ -172,7 +173,7 @@ func TestAgent_DriveConversation_WithToolCalls(t *testing.T) {
 		logMessages = append(logMessages, format)
 	}
 
-	finalMessages, err := agent.DriveConversation(context.Background(), initialMessages, logf)
+	finalMessages, err := agent.DriveConversation(context.Background(), initialMessages, logf, nil)
 
 	require.NoError(t, err)
 	// Original user message + first assistant response + tool response + second assistant response
@@ -180,13 +181,36 @@ func TestAgent_DriveConversation_WithToolCalls(t *testing.T) {
 	assert.Equal(t, 2, mockClient.CallCount)
 }
 
+func TestAgent_DriveConversation_AssistantCallback(t *testing.T) {
+	mockClient := mocks.NewMockOpenAIClient()
+	agent := NewAgent(NewOpenAIProvider(mockClient), nil, "test-model")
+	toolCall := mocks.CreateMockToolCall("call-1", "read_file", `{"path": "testdata/sample.txt"}`)
+	mockClient.AddResponse(mocks.CreateMockResponse("Reading it. Assistant: %s", []openai.ToolCall{toolCall}))
+	mockClient.AddResponse(mocks.CreateMockResponse("Done", nil))
+
+	var logLines, replies []string
+	logf := func(format string, args ...any) {
+		logLines = append(logLines, fmt.Sprintf(format, args...))
+	}
+	_, err := agent.DriveConversation(context.Background(), []Message{{Role: RoleUser, Content: "Read"}}, logf, func(content string) {
+		replies = append(replies, content)
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Reading it. Assistant: %s", "Done"}, replies)
+	for _, line := range logLines {
+		assert.NotContains(t, line, "Assistant:")
+	}
+	assert.Contains(t, logLines, "Tool call: read_file")
+}
+
 func TestAgent_DriveConversation_MaxIterations(t *testing.T) {
 	mockClient := mocks.NewMockOpenAIClient()
 	agent := &Agent{
-		client:       mockClient,
+		provider:     NewOpenAIProvider(mockClient),
 		toolHandlers: make(map[string]ToolHandler),
 		model:        "test-model",
-		tools:        []openai.Tool{},
+		tools:        []ToolDefinition{},
 	}
 	agent.setupTools()
 
@@ -199,14 +223,14 @@ func TestAgent_DriveConversation_MaxIterations(t *testing.T) {
 		mockClient.AddResponse(response)
 	}
 
-	initialMessages := []openai.ChatCompletionMessage{
+	initialMessages := []Message{
 		{
-			Role:    openai.ChatMessageRoleUser,
+			Role:    RoleUser,
 			Content: "Test max iterations",
 		},
 	}
 
-	finalMessages, err := agent.DriveConversation(context.Background(), initialMessages, nil)
+	finalMessages, err := agent.DriveConversation(context.Background(), initialMessages, nil, nil)
 
 	require.NoError(t, err)
 	// Should stop at max iterations (10), not process all 11 responses
@@ -218,10 +242,10 @@ func TestAgent_DriveConversation_MaxIterations(t *testing.T) {
 func TestAgent_HandleRunAgent(t *testing.T) {
 	mockClient := mocks.NewMockOpenAIClient()
 	agent := &Agent{
-		client:       mockClient,
+		provider:     NewOpenAIProvider(mockClient),
 		toolHandlers: make(map[string]ToolHandler),
 		model:        "test-model",
-		tools:        []openai.Tool{},
+		tools:        []ToolDefinition{},
 	}
 	agent.setupTools()
 
@@ -230,43 +254,17 @@ func TestAgent_HandleRunAgent(t *testing.T) {
 	mockClient.AddResponse(subAgentResponse)
 
 	args, _ := json.Marshal(RunAgentInput{Task: "Test sub-agent task"})
-	toolCall := openai.ToolCall{
-		ID:   "run-agent-call",
-		Type: "function",
-		Function: openai.FunctionCall{
-			Name:      "run_agent",
-			Arguments: string(args),
-		},
+	toolCall := ToolCall{
+		ID:        "run-agent-call",
+		Name:      "run_agent",
+		Arguments: string(args),
 	}
 
-	response := agent.handleRunAgent(toolCall)
+	response := agent.handleRunAgent(context.Background(), toolCall)
 
-	assert.Equal(t, openai.ChatMessageRoleTool, response.Role)
+	assert.Equal(t, RoleTool, response.Role)
 	assert.Contains(t, response.Content, "Agent task: Test sub-agent task")
 	assert.Contains(t, response.Content, "Sub-agent completed the task")
 	assert.Equal(t, "run-agent-call", response.ToolCallID)
 	assert.Equal(t, 1, mockClient.CallCount)
 }
-
-func TestGetModel(t *testing.T) {
-	// Test CLI argument takes priority
-	cliModel := "cli-model"
-	result := getModel(&cliModel)
-	assert.Equal(t, "cli-model", result)
-
-	// Test empty CLI argument falls back to env var
-	emptyModel := ""
-	t.Setenv("LLM_MODEL", "env-model")
-	result = getModel(&emptyModel)
-	assert.Equal(t, "env-model", result)
-
-	// Test nil CLI argument falls back to env var
-	t.Setenv("LLM_MODEL", "env-model-2")
-	result = getModel(nil)
-	assert.Equal(t, "env-model-2", result)
-
-	// Test falls back to default when no env var
-	t.Setenv("LLM_MODEL", "")
-	result = getModel(nil)
-	assert.Equal(t, DEFAULT_MODEL, result)
-}
\ No newline at end of file
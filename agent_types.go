@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// projectConfigDirName is the directory in the project root holding project-level agent configuration
+	projectConfigDirName = ".agent"
+	// agentTypesDir is where agent type definitions live within a configuration directory
+	agentTypesDir = "agents"
+)
+
+// AgentType is a sub-agent persona selecting a system prompt, the tools it may use and optionally its model
+type AgentType struct {
+	Name         string   `yaml:"name"`
+	Description  string   `yaml:"description"`
+	Tools        []string `yaml:"tools"` // empty allows every tool
+	Model        string   `yaml:"model"` // empty uses the sub-agent model
+	SystemPrompt string   `yaml:"-"`
+	Source       string   `yaml:"-"` // file the type was loaded from, or "built-in"
+}
+
+// builtinAgentTypes returns the agent types available without any configuration
+func builtinAgentTypes() map[string]AgentType {
+	types := []AgentType{
+		{
+			Name:         "explorer",
+			Description:  "Read-only investigation of the codebase; cannot modify files.",
+			Tools:        []string{"read_file", "list_dir", "git_status", "git_diff", "git_log", "git_blame", "git_show"},
+			SystemPrompt: "You are a read-only code explorer. Investigate the codebase to answer the task and finish with a concise, factual report of what you found, citing file paths. You cannot modify files.",
+		},
+		{
+			Name:         "test-writer",
+			Description:  "Writes and updates tests for existing code.",
+			Tools:        []string{"read_file", "list_dir", "write_to_file", "make_dir", "git_diff"},
+			SystemPrompt: "You write tests. Read the code under test and the existing tests, follow their conventions, and add or update tests that cover the behaviour described in the task. Do not change non-test code.",
+		},
+		{
+			Name:         "reviewer",
+			Description:  "Reviews changes for bugs, style problems and missing tests; cannot modify files.",
+			Tools:        []string{"read_file", "list_dir", "git_status", "git_diff", "git_log", "git_blame", "git_show"},
+			SystemPrompt: "You are a code reviewer. Examine the changes described in the task and report concrete bugs, risky behaviour, style inconsistencies and missing tests, most important first. You cannot modify files.",
+		},
+	}
+
+	result := make(map[string]AgentType, len(types))
+	for _, t := range types {
+		t.Source = "built-in"
+		result[t.Name] = t
+	}
+	return result
+}
+
+// loadAgentTypes returns the built-in agent types overlaid with definitions from the user's and then
+// the project's configuration directory, so project definitions take precedence. Empty directories are skipped.
+func loadAgentTypes(projectDir, userDir string) (map[string]AgentType, error) {
+	types := builtinAgentTypes()
+	for _, root := range []string{userDir, projectDir} {
+		if root == "" {
+			continue
+		}
+		if err := loadAgentTypesFrom(filepath.Join(root, agentTypesDir), types); err != nil {
+			return nil, err
+		}
+	}
+	return types, nil
+}
+
+// loadAgentTypesFrom reads every markdown agent type definition in dir into types
+func loadAgentTypesFrom(dir string, types map[string]AgentType) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".md" {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		agentType, err := parseAgentType(data, strings.TrimSuffix(entry.Name(), ".md"))
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		agentType.Source = path
+		types[agentType.Name] = agentType
+	}
+	return nil
+}
+
+// parseAgentType parses a markdown agent type definition: YAML front matter between --- lines
+// followed by the system prompt. The name defaults to the file name.
+func parseAgentType(data []byte, defaultName string) (AgentType, error) {
+	agentType := AgentType{Name: defaultName}
+	content, err := parseFrontMatter(data, &agentType)
+	if err != nil {
+		return AgentType{}, err
+	}
+
+	agentType.SystemPrompt = content
+	if agentType.Name == "" {
+		agentType.Name = defaultName
+	}
+	return agentType, nil
+}
+
+// parseFrontMatter decodes optional YAML front matter between --- lines into v and returns the
+// trimmed markdown that follows it
+func parseFrontMatter(data []byte, v any) (string, error) {
+	content := bytes.ReplaceAll(bytes.TrimPrefix(data, []byte("\ufeff")), []byte("\r\n"), []byte("\n"))
+	rest, ok := bytes.CutPrefix(content, []byte("---\n"))
+	if !ok {
+		return strings.TrimSpace(string(content)), nil
+	}
+	// The front matter ends at the first line that is exactly ---
+	for offset := 0; offset < len(rest); {
+		line, _, _ := bytes.Cut(rest[offset:], []byte("\n"))
+		next := min(offset+len(line)+1, len(rest))
+		if string(bytes.TrimRight(line, " \t")) == "---" {
+			if err := yaml.Unmarshal(rest[:offset], v); err != nil {
+				return "", fmt.Errorf("invalid front matter: %w", err)
+			}
+			return strings.TrimSpace(string(rest[next:])), nil
+		}
+		offset = next
+	}
+	return "", fmt.Errorf("unterminated front matter")
+}
+
+// projectConfigDir returns the directory holding project-level configuration for the project at root
+func projectConfigDir(root string) string {
+	return filepath.Join(root, projectConfigDirName)
+}
+
+// userConfigDir returns the directory holding the user's agent configuration
+func userConfigDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "agent")
+}
+
+// agentTypeNames returns the names of the given agent types in sorted order
+func agentTypeNames(types map[string]AgentType) []string {
+	return sortedKeys(types)
+}
+
+// availableAgentTypes returns the agent types run_agent can select, defaulting to the built-ins
+func (a *Agent) availableAgentTypes() map[string]AgentType {
+	if a.agentTypes == nil {
+		return builtinAgentTypes()
+	}
+	return a.agentTypes
+}
+
+// restrictTools limits the agent to the named tools. An empty list leaves every tool available.
+func (a *Agent) restrictTools(names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	allowed := make(map[string]bool, len(names))
+	for _, name := range names {
+		if _, ok := a.toolHandlers[name]; !ok {
+			return fmt.Errorf("unknown tool %q", name)
+		}
+		allowed[name] = true
+	}
+
+	var tools []ToolDefinition
+	for _, tool := range a.tools {
+		if allowed[tool.Name] {
+			tools = append(tools, tool)
+		}
+	}
+	a.tools = tools
+	for name := range a.toolHandlers {
+		if !allowed[name] {
+			delete(a.toolHandlers, name)
+		}
+	}
+	return nil
+}
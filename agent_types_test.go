@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"agent/mocks"
+)
+
+func writeAgentType(t *testing.T, configDir, name, content string) {
+	t.Helper()
+	dir := filepath.Join(configDir, agentTypesDir)
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".md"), []byte(content), 0644))
+}
+
+func TestParseAgentType(t *testing.T) {
+	data := []byte("---\ndescription: Finds dead code\ntools: [read_file, list_dir]\nmodel: cheap-model\n---\nYou hunt for dead code.\n\nReport unused functions.\n")
+
+	agentType, err := parseAgentType(data, "janitor")
+
+	require.NoError(t, err)
+	assert.Equal(t, "janitor", agentType.Name)
+	assert.Equal(t, "Finds dead code", agentType.Description)
+	assert.Equal(t, []string{"read_file", "list_dir"}, agentType.Tools)
+	assert.Equal(t, "cheap-model", agentType.Model)
+	assert.Equal(t, "You hunt for dead code.\n\nReport unused functions.", agentType.SystemPrompt)
+}
+
+func TestParseAgentType_CRLF(t *testing.T) {
+	data := []byte("---\r\ndescription: Read-only\r\ntools: [read_file]\r\n---\r\nOnly look.\r\n")
+
+	agentType, err := parseAgentType(data, "viewer")
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"read_file"}, agentType.Tools)
+	assert.Equal(t, "Only look.", agentType.SystemPrompt)
+}
+
+func TestParseAgentType_DelimiterIsAWholeLine(t *testing.T) {
+	data := []byte("---\ndescription: Dashes\n---x: not the end\n---\nPrompt")
+
+	agentType, err := parseAgentType(data, "dashes")
+	require.NoError(t, err)
+	assert.Equal(t, "Dashes", agentType.Description)
+	assert.Equal(t, "Prompt", agentType.SystemPrompt)
+
+	agentType, err = parseAgentType([]byte("---\ntools: [read_file]\n---"), "empty")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"read_file"}, agentType.Tools)
+	assert.Empty(t, agentType.SystemPrompt)
+
+	_, err = parseAgentType([]byte("---\ntools: [read_file]\n----\nPrompt"), "unterminated")
+	assert.ErrorContains(t, err, "unterminated front matter")
+}
+
+func TestParseAgentType_NoFrontMatter(t *testing.T) {
+	agentType, err := parseAgentType([]byte("Just a prompt."), "plain")
+
+	require.NoError(t, err)
+	assert.Equal(t, "plain", agentType.Name)
+	assert.Equal(t, "Just a prompt.", agentType.SystemPrompt)
+	assert.Empty(t, agentType.Tools)
+}
+
+func TestParseAgentType_Invalid(t *testing.T) {
+	_, err := parseAgentType([]byte("---\ntools: [read_file\n"), "broken")
+	assert.ErrorContains(t, err, "unterminated front matter")
+
+	_, err = parseAgentType([]byte("---\ntools: [read_file\n---\nprompt"), "broken")
+	assert.ErrorContains(t, err, "invalid front matter")
+}
+
+func TestLoadAgentTypes_Precedence(t *testing.T) {
+	userDir := t.TempDir()
+	projectDir := t.TempDir()
+	writeAgentType(t, userDir, "reviewer", "---\ndescription: User reviewer\n---\nUser prompt")
+	writeAgentType(t, userDir, "docs", "---\ndescription: User docs writer\n---\nDocs prompt")
+	writeAgentType(t, projectDir, "docs", "---\ndescription: Project docs writer\n---\nProject docs prompt")
+
+	types, err := loadAgentTypes(projectDir, userDir)
+
+	require.NoError(t, err)
+	assert.Equal(t, "User reviewer", types["reviewer"].Description)
+	assert.Equal(t, "Project docs writer", types["docs"].Description)
+	assert.Equal(t, filepath.Join(projectDir, agentTypesDir, "docs.md"), types["docs"].Source)
+	assert.Equal(t, "built-in", types["explorer"].Source)
+}
+
+func TestLoadAgentTypes_MissingDirectories(t *testing.T) {
+	types, err := loadAgentTypes(filepath.Join(t.TempDir(), "missing"), "")
+
+	require.NoError(t, err)
+	assert.Equal(t, agentTypeNames(builtinAgentTypes()), agentTypeNames(types))
+}
+
+func TestRestrictTools(t *testing.T) {
+	agent := setupTestAgent()
+	agent.setupTools()
+
+	require.NoError(t, agent.restrictTools([]string{"read_file", "list_dir"}))
+
+	assert.Len(t, agent.tools, 2)
+	assert.Len(t, agent.toolHandlers, 2)
+	assert.Contains(t, agent.toolHandlers, "read_file")
+	assert.NotContains(t, agent.toolHandlers, "write_to_file")
+
+	assert.ErrorContains(t, agent.restrictTools([]string{"launch_rockets"}), `unknown tool "launch_rockets"`)
+}
+
+func TestHandleRunAgent_ReadOnlyExplorer(t *testing.T) {
+	mockClient := mocks.NewMockOpenAIClient()
+	agent := NewAgent(NewOpenAIProvider(mockClient), nil, "test-model")
+
+	path := filepath.Join(t.TempDir(), "forbidden.txt")
+	writeCall := mocks.CreateMockToolCall("sub-call", "write_to_file", `{"path": "`+path+`", "content": "x"}`)
+	mockClient.AddResponse(mocks.CreateMockResponse("", []openai.ToolCall{writeCall}))
+	mockClient.AddResponse(mocks.CreateMockResponse("could not write", nil))
+
+	response := agent.handleRunAgent(context.Background(), newTestToolCall("run-1", "run_agent", RunAgentInput{Task: "explore", AgentType: "explorer"}))
+
+	assert.Contains(t, response.Content, "Agent type: explorer")
+	assert.Contains(t, response.Content, "could not write")
+	assert.NotContains(t, response.Content, "Files modified")
+	assert.NoFileExists(t, path)
+
+	require.Len(t, mockClient.Requests, 2)
+	toolResult := mockClient.Requests[1].Messages[len(mockClient.Requests[1].Messages)-1]
+	assert.Equal(t, "Unknown tool: write_to_file", toolResult.Content)
+	request := mockClient.Requests[0]
+	assert.Equal(t, RoleSystem, request.Messages[0].Role)
+	assert.Equal(t, builtinAgentTypes()["explorer"].SystemPrompt, request.Messages[0].Content)
+	assert.Len(t, request.Tools, len(builtinAgentTypes()["explorer"].Tools))
+}
+
+func TestHandleRunAgent_AgentTypeModelOverride(t *testing.T) {
+	mockClient := mocks.NewMockOpenAIClient()
+	agent := NewAgent(NewOpenAIProvider(mockClient), nil, "test-model")
+	agent.agentTypes = map[string]AgentType{
+		"cheap": {Name: "cheap", Model: "cheap-model", SystemPrompt: "Be brief."},
+	}
+	mockClient.AddResponse(mocks.CreateMockResponse("done", nil))
+
+	agent.handleRunAgent(context.Background(), newTestToolCall("run-2", "run_agent", RunAgentInput{Task: "summarize", AgentType: "cheap"}))
+
+	require.Len(t, mockClient.Requests, 1)
+	assert.Equal(t, "cheap-model", mockClient.Requests[0].Model)
+	assert.Len(t, mockClient.Requests[0].Tools, len(agent.tools))
+}
+
+func TestHandleRunAgent_UnknownAgentType(t *testing.T) {
+	mockClient := mocks.NewMockOpenAIClient()
+	agent := NewAgent(NewOpenAIProvider(mockClient), nil, "test-model")
+
+	response := agent.handleRunAgent(context.Background(), newTestToolCall("run-3", "run_agent", RunAgentInput{Task: "x", AgentType: "wizard"}))
+
+	assert.Contains(t, response.Content, `Error in agent execution: unknown agent type "wizard"`)
+	assert.Contains(t, response.Content, "explorer, reviewer, test-writer")
+	assert.Equal(t, 0, mockClient.CallCount)
+}
+
+func TestCreateRunAgentTool_ListsAgentTypes(t *testing.T) {
+	agent := setupTestAgent()
+	agent.agentTypes = map[string]AgentType{"docs": {Name: "docs", Description: "Writes documentation"}}
+
+	tool := agent.createRunAgentTool()
+
+	assert.Contains(t, tool.Description, "- docs: Writes documentation")
+	properties := tool.Parameters["properties"].(map[string]any)
+	assert.Equal(t, []string{"docs"}, properties["agent_type"].(map[string]any)["enum"])
+}
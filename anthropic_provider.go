@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultAnthropicBaseURL   = "https://api.anthropic.com"
+	defaultAnthropicModel     = "claude-sonnet-4-0"
+	defaultAnthropicMaxTokens = 8192
+	anthropicAPIVersion       = "2023-06-01"
+)
+
+// AnthropicConfig configures the native Anthropic Messages API backend
+type AnthropicConfig struct {
+	APIKey         string
+	BaseURL        string // defaults to the public API
+	MaxTokens      int    // output token limit per response; zero uses a default
+	ThinkingBudget int    // tokens available for extended thinking; zero disables it
+	Stream         bool   // receive responses as server-sent events
+	HTTPClient     *http.Client
+}
+
+// AnthropicProvider talks to the Anthropic Messages API directly, keeping tool use, thinking
+// blocks and prompt caching that are lost behind an OpenAI-compatible proxy
+type AnthropicProvider struct {
+	config AnthropicConfig
+}
+
+// NewAnthropicProvider creates a provider for the Anthropic Messages API
+func NewAnthropicProvider(config AnthropicConfig) *AnthropicProvider {
+	if config.BaseURL == "" {
+		config.BaseURL = defaultAnthropicBaseURL
+	}
+	if config.MaxTokens <= 0 {
+		config.MaxTokens = defaultAnthropicMaxTokens
+	}
+	if config.ThinkingBudget > 0 && config.MaxTokens <= config.ThinkingBudget {
+		config.MaxTokens = config.ThinkingBudget + defaultAnthropicMaxTokens
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+	return &AnthropicProvider{config: config}
+}
+
+// Wire format of the Messages API
+
+type anthropicRequest struct {
+	Model     string             `json:"model"`
+	MaxTokens int                `json:"max_tokens"`
+	System    []anthropicBlock   `json:"system,omitempty"`
+	Messages  []anthropicMessage `json:"messages"`
+	Tools     []anthropicTool    `json:"tools,omitempty"`
+	Thinking  *anthropicThinking `json:"thinking,omitempty"`
+	Stream    bool               `json:"stream,omitempty"`
+}
+
+type anthropicThinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+type anthropicCacheControl struct {
+	Type string `json:"type"`
+}
+
+// anthropicBlock is any content block; only the fields of its type are set
+type anthropicBlock struct {
+	Type         string                 `json:"type"`
+	Text         string                 `json:"text,omitempty"`
+	Thinking     string                 `json:"thinking,omitempty"`
+	Signature    string                 `json:"signature,omitempty"`
+	Data         string                 `json:"data,omitempty"`
+	ID           string                 `json:"id,omitempty"`
+	Name         string                 `json:"name,omitempty"`
+	Input        json.RawMessage        `json:"input,omitempty"`
+	ToolUseID    string                 `json:"tool_use_id,omitempty"`
+	Content      string                 `json:"content,omitempty"`
+	IsError      bool                   `json:"is_error,omitempty"`
+	CacheControl *anthropicCacheControl `json:"cache_control,omitempty"`
+}
+
+type anthropicTool struct {
+	Name         string                 `json:"name"`
+	Description  string                 `json:"description"`
+	InputSchema  map[string]any         `json:"input_schema"`
+	CacheControl *anthropicCacheControl `json:"cache_control,omitempty"`
+}
+
+type anthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+}
+
+type anthropicResponse struct {
+	Content    []anthropicBlock `json:"content"`
+	StopReason string           `json:"stop_reason"`
+	Usage      anthropicUsage   `json:"usage"`
+}
+
+type anthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// Chat sends the conversation to the Messages API
+func (p *AnthropicProvider) Chat(ctx context.Context, request ChatRequest) (ChatResponse, error) {
+	body, err := json.Marshal(p.buildRequest(request))
+	if err != nil {
+		return ChatResponse{}, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(p.config.BaseURL, "/")+"/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	httpReq.Header.Set("content-type", "application/json")
+	httpReq.Header.Set("x-api-key", p.config.APIKey)
+	httpReq.Header.Set("anthropic-version", anthropicAPIVersion)
+
+	httpResp, err := p.config.HTTPClient.Do(httpReq)
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return ChatResponse{}, readAnthropicError(httpResp)
+	}
+
+	var resp anthropicResponse
+	if p.config.Stream {
+		resp, err = readAnthropicStream(httpResp.Body)
+	} else {
+		err = json.NewDecoder(httpResp.Body).Decode(&resp)
+	}
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	return fromAnthropicResponse(resp), nil
+}
+
+// buildRequest converts a ChatRequest to the Messages API format. Cache breakpoints are placed on
+// the system prompt, the tool list and the latest message so each turn reuses the previous prefix.
+func (p *AnthropicProvider) buildRequest(request ChatRequest) anthropicRequest {
+	req := anthropicRequest{
+		Model:     strings.TrimPrefix(request.Model, "anthropic/"),
+		MaxTokens: p.config.MaxTokens,
+		Stream:    p.config.Stream,
+	}
+	if p.config.ThinkingBudget > 0 {
+		req.Thinking = &anthropicThinking{Type: "enabled", BudgetTokens: p.config.ThinkingBudget}
+	}
+
+	for _, msg := range request.Messages {
+		if msg.Role == RoleSystem {
+			if msg.Content == "" {
+				continue
+			}
+			req.System = append(req.System, anthropicBlock{Type: "text", Text: msg.Content})
+			continue
+		}
+		role, blocks := toAnthropicBlocks(msg)
+		if len(blocks) == 0 {
+			continue
+		}
+		// The API requires alternating roles, so consecutive tool results and user text are merged
+		if n := len(req.Messages); n > 0 && req.Messages[n-1].Role == role {
+			req.Messages[n-1].Content = append(req.Messages[n-1].Content, blocks...)
+		} else {
+			req.Messages = append(req.Messages, anthropicMessage{Role: role, Content: blocks})
+		}
+	}
+
+	for _, tool := range request.Tools {
+		req.Tools = append(req.Tools, anthropicTool{Name: tool.Name, Description: tool.Description, InputSchema: tool.Parameters})
+	}
+
+	ephemeral := &anthropicCacheControl{Type: "ephemeral"}
+	if n := len(req.System); n > 0 {
+		req.System[n-1].CacheControl = ephemeral
+	}
+	if n := len(req.Tools); n > 0 {
+		req.Tools[n-1].CacheControl = ephemeral
+	}
+	if n := len(req.Messages); n > 0 {
+		last := req.Messages[n-1].Content
+		for i := len(last) - 1; i >= 0; i-- {
+			// Thinking blocks cannot carry a cache breakpoint
+			if last[i].Type != "thinking" && last[i].Type != "redacted_thinking" {
+				last[i].CacheControl = ephemeral
+				break
+			}
+		}
+	}
+	return req
+}
+
+// toAnthropicBlocks converts a message to content blocks and the role they are sent under
+func toAnthropicBlocks(msg Message) (string, []anthropicBlock) {
+	switch msg.Role {
+	case RoleTool:
+		return RoleUser, []anthropicBlock{{Type: "tool_result", ToolUseID: msg.ToolCallID, Content: msg.Content, IsError: msg.IsError}}
+	case RoleAssistant:
+		var blocks []anthropicBlock
+		for _, thinking := range msg.Thinking {
+			// Reasoning from other providers is unsigned and would be rejected
+			switch {
+			case thinking.Data != "":
+				blocks = append(blocks, anthropicBlock{Type: "redacted_thinking", Data: thinking.Data})
+			case thinking.Signature != "":
+				blocks = append(blocks, anthropicBlock{Type: "thinking", Thinking: thinking.Text, Signature: thinking.Signature})
+			}
+		}
+		if msg.Content != "" {
+			blocks = append(blocks, anthropicBlock{Type: "text", Text: msg.Content})
+		}
+		for _, toolCall := range msg.ToolCalls {
+			input := json.RawMessage(toolCall.Arguments)
+			if !json.Valid(input) {
+				input = json.RawMessage("{}")
+			}
+			blocks = append(blocks, anthropicBlock{Type: "tool_use", ID: toolCall.ID, Name: toolCall.Name, Input: input})
+		}
+		return RoleAssistant, blocks
+	default:
+		if msg.Content == "" {
+			return RoleUser, nil
+		}
+		return RoleUser, []anthropicBlock{{Type: "text", Text: msg.Content}}
+	}
+}
+
+func fromAnthropicResponse(resp anthropicResponse) ChatResponse {
+	msg := Message{Role: RoleAssistant}
+	var text []string
+	for _, block := range resp.Content {
+		switch block.Type {
+		case "text":
+			text = append(text, block.Text)
+		case "thinking":
+			msg.Thinking = append(msg.Thinking, ThinkingBlock{Text: block.Thinking, Signature: block.Signature})
+		case "redacted_thinking":
+			msg.Thinking = append(msg.Thinking, ThinkingBlock{Data: block.Data})
+		case "tool_use":
+			arguments := string(block.Input)
+			if arguments == "" {
+				arguments = "{}"
+			}
+			msg.ToolCalls = append(msg.ToolCalls, ToolCall{ID: block.ID, Name: block.Name, Arguments: arguments})
+		}
+	}
+	msg.Content = strings.Join(text, "")
+
+	return ChatResponse{
+		Message: msg,
+		Usage: Usage{
+			PromptTokens:        resp.Usage.InputTokens + resp.Usage.CacheReadInputTokens + resp.Usage.CacheCreationInputTokens,
+			CompletionTokens:    resp.Usage.OutputTokens,
+			CacheReadTokens:     resp.Usage.CacheReadInputTokens,
+			CacheCreationTokens: resp.Usage.CacheCreationInputTokens,
+		},
+		StopReason: resp.StopReason,
+	}
+}
+
+// readAnthropicStream assembles a response from the Messages API's server-sent events
+func readAnthropicStream(r io.Reader) (anthropicResponse, error) {
+	var resp anthropicResponse
+	partialInputs := make(map[int]*strings.Builder)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+
+		var event struct {
+			Type         string             `json:"type"`
+			Index        int                `json:"index"`
+			Message      *anthropicResponse `json:"message"`
+			ContentBlock *anthropicBlock    `json:"content_block"`
+			Delta        struct {
+				Type        string `json:"type"`
+				Text        string `json:"text"`
+				Thinking    string `json:"thinking"`
+				Signature   string `json:"signature"`
+				PartialJSON string `json:"partial_json"`
+				StopReason  string `json:"stop_reason"`
+			} `json:"delta"`
+			Usage *anthropicUsage `json:"usage"`
+			Error *anthropicError `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+			return resp, fmt.Errorf("decoding stream event: %w", err)
+		}
+
+		switch event.Type {
+		case "message_start":
+			if event.Message != nil {
+				resp.Usage = event.Message.Usage
+			}
+		case "content_block_start":
+			if event.ContentBlock == nil {
+				continue
+			}
+			for len(resp.Content) <= event.Index {
+				resp.Content = append(resp.Content, anthropicBlock{})
+			}
+			resp.Content[event.Index] = *event.ContentBlock
+			if event.ContentBlock.Type == "tool_use" {
+				partialInputs[event.Index] = &strings.Builder{}
+			}
+		case "content_block_delta":
+			if event.Index >= len(resp.Content) {
+				return resp, fmt.Errorf("stream delta for unknown content block %d", event.Index)
+			}
+			block := &resp.Content[event.Index]
+			switch event.Delta.Type {
+			case "text_delta":
+				block.Text += event.Delta.Text
+			case "thinking_delta":
+				block.Thinking += event.Delta.Thinking
+			case "signature_delta":
+				block.Signature += event.Delta.Signature
+			case "input_json_delta":
+				if input, ok := partialInputs[event.Index]; ok {
+					input.WriteString(event.Delta.PartialJSON)
+				}
+			}
+		case "message_delta":
+			if event.Delta.StopReason != "" {
+				resp.StopReason = event.Delta.StopReason
+			}
+			if event.Usage != nil {
+				resp.Usage.OutputTokens = event.Usage.OutputTokens
+			}
+		case "error":
+			if event.Error != nil {
+				return resp, fmt.Errorf("anthropic stream error: %s: %s", event.Error.Type, event.Error.Message)
+			}
+			return resp, fmt.Errorf("anthropic stream error")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return resp, err
+	}
+
+	for index, input := range partialInputs {
+		if input.Len() > 0 {
+			resp.Content[index].Input = json.RawMessage(input.String())
+		}
+	}
+	return resp, nil
+}
+
+// readAnthropicError describes an unsuccessful API response
+func readAnthropicError(httpResp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(httpResp.Body, 64*1024))
+	var payload struct {
+		Error anthropicError `json:"error"`
+	}
+	if err := json.Unmarshal(body, &payload); err == nil && payload.Error.Message != "" {
+		return fmt.Errorf("anthropic API error (%s): %s: %s", httpResp.Status, payload.Error.Type, payload.Error.Message)
+	}
+	return fmt.Errorf("anthropic API error (%s): %s", httpResp.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// anthropicStandIn is a local stand-in for the Messages API that records requests and replays canned responses
+type anthropicStandIn struct {
+	mu        sync.Mutex
+	requests  []map[string]any
+	headers   []http.Header
+	responses []func(w http.ResponseWriter)
+}
+
+func newAnthropicStandIn(t *testing.T, responses ...func(w http.ResponseWriter)) (*anthropicStandIn, *httptest.Server) {
+	standIn := &anthropicStandIn{responses: responses}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/messages", r.URL.Path)
+		body, _ := io.ReadAll(r.Body)
+		var request map[string]any
+		require.NoError(t, json.Unmarshal(body, &request))
+
+		standIn.mu.Lock()
+		standIn.requests = append(standIn.requests, request)
+		standIn.headers = append(standIn.headers, r.Header.Clone())
+		respond := standIn.responses[0]
+		standIn.responses = standIn.responses[1:]
+		standIn.mu.Unlock()
+
+		respond(w)
+	}))
+	t.Cleanup(server.Close)
+	return standIn, server
+}
+
+func jsonResponse(body string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.Header().Set("content-type", "application/json")
+		io.WriteString(w, body)
+	}
+}
+
+func sseResponse(events ...string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.Header().Set("content-type", "text/event-stream")
+		for _, event := range events {
+			var payload struct {
+				Type string `json:"type"`
+			}
+			json.Unmarshal([]byte(event), &payload)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", payload.Type, event)
+		}
+	}
+}
+
+func TestAnthropicProvider_Chat(t *testing.T) {
+	standIn, server := newAnthropicStandIn(t, jsonResponse(`{
+		"content": [
+			{"type": "thinking", "thinking": "The file is small.", "signature": "sig-1"},
+			{"type": "text", "text": "Reading it now."},
+			{"type": "tool_use", "id": "toolu_1", "name": "read_file", "input": {"path": "main.go"}}
+		],
+		"stop_reason": "tool_use",
+		"usage": {"input_tokens": 10, "output_tokens": 20, "cache_read_input_tokens": 100, "cache_creation_input_tokens": 5}
+	}`))
+	provider := NewAnthropicProvider(AnthropicConfig{APIKey: "test-key", BaseURL: server.URL, ThinkingBudget: 2048})
+
+	resp, err := provider.Chat(context.Background(), ChatRequest{
+		Model: "anthropic/claude-sonnet-4-0",
+		Messages: []Message{
+			{Role: RoleSystem, Content: "Be brief."},
+			{Role: RoleUser, Content: "Show main.go"},
+		},
+		Tools: []ToolDefinition{{Name: "read_file", Description: "Read a file", Parameters: map[string]any{"type": "object"}}},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, Message{
+		Role:      RoleAssistant,
+		Content:   "Reading it now.",
+		Thinking:  []ThinkingBlock{{Text: "The file is small.", Signature: "sig-1"}},
+		ToolCalls: []ToolCall{{ID: "toolu_1", Name: "read_file", Arguments: `{"path": "main.go"}`}},
+	}, resp.Message)
+	assert.Equal(t, Usage{PromptTokens: 115, CompletionTokens: 20, CacheReadTokens: 100, CacheCreationTokens: 5}, resp.Usage)
+	assert.Equal(t, "tool_use", resp.StopReason)
+
+	require.Len(t, standIn.requests, 1)
+	assert.Equal(t, "test-key", standIn.headers[0].Get("x-api-key"))
+	assert.Equal(t, anthropicAPIVersion, standIn.headers[0].Get("anthropic-version"))
+
+	request := standIn.requests[0]
+	assert.Equal(t, "claude-sonnet-4-0", request["model"])
+	assert.Equal(t, float64(defaultAnthropicMaxTokens), request["max_tokens"])
+	assert.Equal(t, map[string]any{"type": "enabled", "budget_tokens": float64(2048)}, request["thinking"])
+	assert.Equal(t, []any{map[string]any{"type": "text", "text": "Be brief.", "cache_control": map[string]any{"type": "ephemeral"}}}, request["system"])
+	assert.Equal(t, []any{map[string]any{
+		"name": "read_file", "description": "Read a file", "input_schema": map[string]any{"type": "object"},
+		"cache_control": map[string]any{"type": "ephemeral"},
+	}}, request["tools"])
+}
+
+func TestAnthropicProvider_BuildRequest(t *testing.T) {
+	provider := NewAnthropicProvider(AnthropicConfig{})
+
+	req := provider.buildRequest(ChatRequest{
+		Model: "claude-sonnet-4-0",
+		Messages: []Message{
+			{Role: RoleUser, Content: "Compare a.go and b.go"},
+			{Role: RoleAssistant, Thinking: []ThinkingBlock{{Text: "unsigned"}, {Text: "signed", Signature: "sig"}, {Data: "secret"}}, ToolCalls: []ToolCall{
+				{ID: "t1", Name: "read_file", Arguments: `{"path": "a.go"}`},
+				{ID: "t2", Name: "read_file", Arguments: `not json`},
+			}},
+			{Role: RoleTool, ToolCallID: "t1", Content: "package a"},
+			{Role: RoleTool, ToolCallID: "t2", Content: "Error reading file", IsError: true},
+			{Role: RoleUser, Content: "Also check c.go"},
+		},
+	})
+
+	require.Len(t, req.Messages, 3)
+	assert.Equal(t, RoleUser, req.Messages[0].Role)
+
+	assistant := req.Messages[1]
+	assert.Equal(t, RoleAssistant, assistant.Role)
+	require.Len(t, assistant.Content, 4)
+	assert.Equal(t, anthropicBlock{Type: "thinking", Thinking: "signed", Signature: "sig"}, assistant.Content[0])
+	assert.Equal(t, anthropicBlock{Type: "redacted_thinking", Data: "secret"}, assistant.Content[1])
+	assert.Equal(t, json.RawMessage(`{"path": "a.go"}`), assistant.Content[2].Input)
+	assert.Equal(t, json.RawMessage(`{}`), assistant.Content[3].Input)
+
+	// Tool results and the following user text share one user message
+	results := req.Messages[2]
+	assert.Equal(t, RoleUser, results.Role)
+	require.Len(t, results.Content, 3)
+	assert.Equal(t, anthropicBlock{Type: "tool_result", ToolUseID: "t1", Content: "package a"}, results.Content[0])
+	assert.True(t, results.Content[1].IsError)
+	assert.Nil(t, results.Content[1].CacheControl)
+	assert.Equal(t, &anthropicCacheControl{Type: "ephemeral"}, results.Content[2].CacheControl)
+
+	assert.Nil(t, req.Thinking)
+	assert.Equal(t, defaultAnthropicMaxTokens, req.MaxTokens)
+}
+
+func TestAnthropicProvider_Stream(t *testing.T) {
+	standIn, server := newAnthropicStandIn(t, sseResponse(
+		`{"type": "message_start", "message": {"content": [], "usage": {"input_tokens": 12, "output_tokens": 1}}}`,
+		`{"type": "content_block_start", "index": 0, "content_block": {"type": "thinking", "thinking": ""}}`,
+		`{"type": "content_block_delta", "index": 0, "delta": {"type": "thinking_delta", "thinking": "Need the "}}`,
+		`{"type": "content_block_delta", "index": 0, "delta": {"type": "thinking_delta", "thinking": "file."}}`,
+		`{"type": "content_block_delta", "index": 0, "delta": {"type": "signature_delta", "signature": "sig-2"}}`,
+		`{"type": "content_block_stop", "index": 0}`,
+		`{"type": "ping"}`,
+		`{"type": "content_block_start", "index": 1, "content_block": {"type": "text", "text": ""}}`,
+		`{"type": "content_block_delta", "index": 1, "delta": {"type": "text_delta", "text": "Let me "}}`,
+		`{"type": "content_block_delta", "index": 1, "delta": {"type": "text_delta", "text": "look."}}`,
+		`{"type": "content_block_stop", "index": 1}`,
+		`{"type": "content_block_start", "index": 2, "content_block": {"type": "tool_use", "id": "toolu_2", "name": "read_file", "input": {}}}`,
+		`{"type": "content_block_delta", "index": 2, "delta": {"type": "input_json_delta", "partial_json": "{\"path\": "}}`,
+		`{"type": "content_block_delta", "index": 2, "delta": {"type": "input_json_delta", "partial_json": "\"go.mod\"}"}}`,
+		`{"type": "content_block_stop", "index": 2}`,
+		`{"type": "message_delta", "delta": {"stop_reason": "tool_use"}, "usage": {"output_tokens": 30}}`,
+		`{"type": "message_stop"}`,
+	))
+	provider := NewAnthropicProvider(AnthropicConfig{BaseURL: server.URL, Stream: true})
+
+	resp, err := provider.Chat(context.Background(), ChatRequest{Model: "claude-sonnet-4-0", Messages: []Message{{Role: RoleUser, Content: "hi"}}})
+	require.NoError(t, err)
+
+	assert.Equal(t, true, standIn.requests[0]["stream"])
+	assert.Equal(t, "Let me look.", resp.Message.Content)
+	assert.Equal(t, []ThinkingBlock{{Text: "Need the file.", Signature: "sig-2"}}, resp.Message.Thinking)
+	assert.Equal(t, []ToolCall{{ID: "toolu_2", Name: "read_file", Arguments: `{"path": "go.mod"}`}}, resp.Message.ToolCalls)
+	assert.Equal(t, Usage{PromptTokens: 12, CompletionTokens: 30}, resp.Usage)
+	assert.Equal(t, "tool_use", resp.StopReason)
+}
+
+func TestAnthropicProvider_StreamError(t *testing.T) {
+	_, server := newAnthropicStandIn(t, sseResponse(
+		`{"type": "message_start", "message": {"content": [], "usage": {"input_tokens": 1}}}`,
+		`{"type": "error", "error": {"type": "overloaded_error", "message": "Overloaded"}}`,
+	))
+	provider := NewAnthropicProvider(AnthropicConfig{BaseURL: server.URL, Stream: true})
+
+	_, err := provider.Chat(context.Background(), ChatRequest{Messages: []Message{{Role: RoleUser, Content: "hi"}}})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "overloaded_error: Overloaded")
+}
+
+func TestAnthropicProvider_APIError(t *testing.T) {
+	_, server := newAnthropicStandIn(t, func(w http.ResponseWriter) {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"type": "error", "error": {"type": "invalid_request_error", "message": "max_tokens is too large"}}`)
+	})
+	provider := NewAnthropicProvider(AnthropicConfig{BaseURL: server.URL})
+
+	_, err := provider.Chat(context.Background(), ChatRequest{Messages: []Message{{Role: RoleUser, Content: "hi"}}})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "400 Bad Request")
+	assert.Contains(t, err.Error(), "invalid_request_error: max_tokens is too large")
+}
+
+func TestAnthropicProvider_DrivesAgentConversation(t *testing.T) {
+	standIn, server := newAnthropicStandIn(t,
+		jsonResponse(`{"content": [
+			{"type": "thinking", "thinking": "Read the sample.", "signature": "sig-3"},
+			{"type": "tool_use", "id": "toolu_3", "name": "read_file", "input": {"path": "testdata/sample.txt"}}
+		], "stop_reason": "tool_use", "usage": {"input_tokens": 50, "output_tokens": 10}}`),
+		jsonResponse(`{"content": [{"type": "text", "text": "The sample has three lines."}], "stop_reason": "end_turn", "usage": {"input_tokens": 80, "output_tokens": 8}}`),
+	)
+	agent := NewAgent(NewAnthropicProvider(AnthropicConfig{BaseURL: server.URL, ThinkingBudget: 1024}), nil, "claude-sonnet-4-0")
+
+	messages, err := agent.DriveConversation(context.Background(), []Message{{Role: RoleUser, Content: "Summarize the sample"}}, nil, nil)
+	require.NoError(t, err)
+
+	require.Len(t, messages, 4)
+	assert.Equal(t, "The sample has three lines.", messages[3].Content)
+	assert.Equal(t, agentUsage{Iterations: 2, PromptTokens: 130, CompletionTokens: 18}, agent.totalUsage())
+
+	// The second request replays the signed thinking block and answers the tool call with a tool_result
+	require.Len(t, standIn.requests, 2)
+	sent := standIn.requests[1]["messages"].([]any)
+	require.Len(t, sent, 3)
+	assistant := sent[1].(map[string]any)["content"].([]any)
+	assert.Equal(t, map[string]any{"type": "thinking", "thinking": "Read the sample.", "signature": "sig-3"}, assistant[0])
+	result := sent[2].(map[string]any)["content"].([]any)[0].(map[string]any)
+	assert.Equal(t, "tool_result", result["type"])
+	assert.Equal(t, "toolu_3", result["tool_use_id"])
+	assert.True(t, strings.HasPrefix(result["content"].(string), "This is a sample file"), result["content"])
+}
+
+func TestNewAnthropicProvider_MaxTokensExceedThinkingBudget(t *testing.T) {
+	provider := NewAnthropicProvider(AnthropicConfig{MaxTokens: 4000, ThinkingBudget: 16000})
+
+	assert.Equal(t, 16000+defaultAnthropicMaxTokens, provider.config.MaxTokens)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// autoCommitTrailer identifies the session that produced an agent commit
+	autoCommitTrailer = "Agent-Session"
+	// autoCommitMaxDiffBytes caps the diff sent to the model when generating a commit message
+	autoCommitMaxDiffBytes = 16 * 1024
+)
+
+const commitMessagePrompt = `You write git commit messages. Given the user's request and the staged diff, reply with only the commit message: a summary line in the imperative mood of at most 72 characters, optionally followed by a blank line and a short body. Do not wrap the message in quotes or code fences.`
+
+// changeTracker records the paths modified by mutating tools during a turn
+type changeTracker struct {
+	mu    sync.Mutex
+	paths map[string]struct{}
+}
+
+func newChangeTracker() *changeTracker {
+	return &changeTracker{paths: make(map[string]struct{})}
+}
+
+// record notes that the given paths were modified
+func (c *changeTracker) record(paths ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, path := range paths {
+		c.paths[filepath.Clean(path)] = struct{}{}
+	}
+}
+
+// drain returns the recorded paths in sorted order and resets the tracker
+func (c *changeTracker) drain() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	paths := make([]string, 0, len(c.paths))
+	for path := range c.paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	c.paths = make(map[string]struct{})
+	return paths
+}
+
+// recordChange notes paths modified by a tool so they can be auto-committed at the end of the turn
+func (a *Agent) recordChange(paths ...string) {
+	if a.changes != nil {
+		a.changes.record(paths...)
+	}
+}
+
+// newSessionID returns a random identifier for tagging a session's commits
+func newSessionID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// commitTurn stages and commits the files modified during the last turn with a model-generated message.
+// It returns the short hash of the new commit, or an empty string if there was nothing to commit.
+func (a *Agent) commitTurn(ctx context.Context, messages []Message) (string, error) {
+	if a.changes == nil {
+		return "", nil
+	}
+
+	paths := a.committablePaths(ctx, a.changes.drain())
+	if len(paths) == 0 {
+		return "", nil
+	}
+
+	addArgs := append([]string{"add", "-A", "--"}, paths...)
+	if _, err := a.runGit(ctx, addArgs...); err != nil {
+		return "", fmt.Errorf("staging changes: %w", err)
+	}
+
+	// Nothing to commit if the agent rewrote files with identical content
+	diffArgs := append([]string{"diff", "--cached", "--name-only", "--"}, paths...)
+	staged, err := a.runGit(ctx, diffArgs...)
+	if err != nil {
+		return "", fmt.Errorf("checking staged changes: %w", err)
+	}
+	if strings.TrimSpace(staged) == "" {
+		return "", nil
+	}
+
+	message := a.generateCommitMessage(ctx, messages, paths)
+	message = fmt.Sprintf("%s\n\n%s: %s\n", strings.TrimSpace(message), autoCommitTrailer, a.sessionID)
+
+	// Passing paths commits exactly those files, leaving anything else the user staged untouched
+	commitArgs := append([]string{"commit", "-q", "-m", message, "--"}, paths...)
+	if _, err := a.runGit(ctx, commitArgs...); err != nil {
+		return "", fmt.Errorf("committing changes: %w", err)
+	}
+
+	hash, err := a.runGit(ctx, "rev-parse", "--short", "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("reading commit hash: %w", err)
+	}
+	return strings.TrimSpace(hash), nil
+}
+
+// committablePaths filters out paths git cannot stage, such as files created and deleted within the same turn
+func (a *Agent) committablePaths(ctx context.Context, paths []string) []string {
+	var result []string
+	for _, path := range paths {
+		if _, err := os.Lstat(a.resolvePath(path)); err == nil {
+			result = append(result, path)
+			continue
+		}
+		if tracked, err := a.runGit(ctx, "ls-files", "--", path); err == nil && strings.TrimSpace(tracked) != "" {
+			result = append(result, path)
+		}
+	}
+	return result
+}
+
+// generateCommitMessage asks the model to summarize the turn's staged changes, falling back to a
+// generic message if the request fails
+func (a *Agent) generateCommitMessage(ctx context.Context, messages []Message, paths []string) string {
+	fallback := "Apply agent edits to " + strings.Join(paths, ", ")
+
+	diffArgs := append([]string{"diff", "--cached", "--no-color", "--"}, paths...)
+	diff, err := a.runGit(ctx, diffArgs...)
+	if err != nil {
+		return fallback
+	}
+	if len(diff) > autoCommitMaxDiffBytes {
+		diff = diff[:autoCommitMaxDiffBytes] + "\n... diff truncated\n"
+	}
+
+	var request string
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == RoleUser {
+			request = messages[i].Content
+			break
+		}
+	}
+
+	provider, model := a.routedModel(routeSummary)
+	resp, err := provider.Chat(ctx, ChatRequest{
+		Model: model,
+		Messages: []Message{
+			{Role: RoleSystem, Content: commitMessagePrompt},
+			{Role: RoleUser, Content: fmt.Sprintf("User request:\n%s\n\nStaged diff:\n%s", request, diff)},
+		},
+	})
+	if err != nil {
+		return fallback
+	}
+
+	message := strings.TrimSpace(resp.Message.Content)
+	message = strings.Trim(message, "`\"")
+	if strings.TrimSpace(message) == "" {
+		return fallback
+	}
+	return message
+}
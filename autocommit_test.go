@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"agent/mocks"
+)
+
+func setupAutoCommitAgent(t *testing.T) (*Agent, *mocks.MockOpenAIClient, string) {
+	dir := initTestRepo(t)
+	mockClient := mocks.NewMockOpenAIClient()
+	agent := NewAgent(NewOpenAIProvider(mockClient), nil, "test-model")
+	agent.workDir = dir
+	agent.autoCommit = true
+	return agent, mockClient, dir
+}
+
+func TestChangeTracker_DrainSortsAndResets(t *testing.T) {
+	tracker := newChangeTracker()
+	tracker.record("b.go", "./a.go", "b.go")
+
+	assert.Equal(t, []string{"a.go", "b.go"}, tracker.drain())
+	assert.Empty(t, tracker.drain())
+}
+
+func TestCommitTurn_CommitsModifiedFilesWithGeneratedMessage(t *testing.T) {
+	agent, mockClient, dir := setupAutoCommitAgent(t)
+
+	// A file the user staged themselves must not be swept into the agent's commit
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "user.go"), []byte("package main\n"), 0644))
+	gitInDir(t, dir, "add", "user.go")
+
+	path := filepath.Join(dir, "feature.go")
+	response := agent.handleWriteFile(context.Background(), newTestToolCall("write-1", "write_to_file", WriteFileInput{Path: path, Content: "package main\n\nfunc feature() {}\n"}))
+	require.Equal(t, "File written successfully.", response.Content)
+
+	mockClient.AddResponse(mocks.CreateMockResponse("Add feature function", nil))
+	messages := []Message{{Role: RoleUser, Content: "add a feature function"}}
+
+	hash, err := agent.commitTurn(context.Background(), messages)
+	require.NoError(t, err)
+	assert.NotEmpty(t, hash)
+
+	body := gitInDir(t, dir, "log", "-1", "--format=%B")
+	assert.Contains(t, body, "Add feature function")
+	assert.Contains(t, body, "Agent-Session: "+agent.sessionID)
+
+	files := gitInDir(t, dir, "show", "--name-only", "--format=", "HEAD")
+	assert.Equal(t, "feature.go", strings.TrimSpace(files))
+
+	staged := gitInDir(t, dir, "diff", "--cached", "--name-only")
+	assert.Equal(t, "user.go", strings.TrimSpace(staged))
+}
+
+func TestCommitTurn_NothingModified(t *testing.T) {
+	agent, mockClient, _ := setupAutoCommitAgent(t)
+
+	hash, err := agent.commitTurn(context.Background(), nil)
+
+	require.NoError(t, err)
+	assert.Empty(t, hash)
+	assert.Equal(t, 0, mockClient.CallCount)
+}
+
+func TestCommitTurn_UnchangedContentIsSkipped(t *testing.T) {
+	agent, mockClient, dir := setupAutoCommitAgent(t)
+
+	original, err := os.ReadFile(filepath.Join(dir, "main.go"))
+	require.NoError(t, err)
+	agent.handleWriteFile(context.Background(), newTestToolCall("write-2", "write_to_file", WriteFileInput{Path: filepath.Join(dir, "main.go"), Content: string(original)}))
+
+	hash, err := agent.commitTurn(context.Background(), nil)
+
+	require.NoError(t, err)
+	assert.Empty(t, hash)
+	assert.Equal(t, 0, mockClient.CallCount)
+}
+
+func TestCommitTurn_DeletionAndFallbackMessage(t *testing.T) {
+	agent, mockClient, dir := setupAutoCommitAgent(t)
+
+	response := agent.handleDeleteFile(context.Background(), newTestToolCall("delete-1", "delete_file", DeleteFileInput{Path: filepath.Join(dir, "main.go")}))
+	require.Equal(t, "File deleted successfully.", response.Content)
+
+	// A file created and removed within the turn has nothing for git to stage
+	scratch := filepath.Join(dir, "scratch.txt")
+	agent.handleWriteFile(context.Background(), newTestToolCall("write-3", "write_to_file", WriteFileInput{Path: scratch, Content: "tmp"}))
+	agent.handleDeleteFile(context.Background(), newTestToolCall("delete-2", "delete_file", DeleteFileInput{Path: scratch}))
+
+	mockClient.AddError(assert.AnError)
+
+	hash, err := agent.commitTurn(context.Background(), nil)
+	require.NoError(t, err)
+	assert.NotEmpty(t, hash)
+
+	subject := gitInDir(t, dir, "log", "-1", "--format=%s")
+	assert.Contains(t, subject, "Apply agent edits to")
+	assert.Contains(t, subject, "main.go")
+	assert.Empty(t, gitInDir(t, dir, "status", "--porcelain"))
+	assert.NoFileExists(t, filepath.Join(dir, "main.go"))
+}
+
+func TestHandleRunAgent_SharesChangeTracker(t *testing.T) {
+	mockClient := mocks.NewMockOpenAIClient()
+	agent := NewAgent(NewOpenAIProvider(mockClient), nil, "test-model")
+	agent.workDir = t.TempDir()
+
+	path := filepath.Join(agent.workDir, "sub.txt")
+	writeCall := mocks.CreateMockToolCall("sub-call", "write_to_file", `{"path": "`+path+`", "content": "x"}`)
+	mockClient.AddResponse(mocks.CreateMockResponse("", []openai.ToolCall{writeCall}))
+	mockClient.AddResponse(mocks.CreateMockResponse("done", nil))
+
+	agent.handleRunAgent(context.Background(), newTestToolCall("run-1", "run_agent", RunAgentInput{Task: "write a file"}))
+
+	assert.Equal(t, []string{path}, agent.changes.drain())
+}
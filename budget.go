@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// defaultMaxAgentDepth is how deeply run_agent may nest when no limit is configured
+const defaultMaxAgentDepth = 3
+
+// errBudgetExhausted is returned when a turn has used up its shared token or iteration budget
+var errBudgetExhausted = errors.New("agent budget exhausted")
+
+// agentUsage accumulates the model usage of an agent
+type agentUsage struct {
+	Iterations       int
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// TotalTokens returns the prompt and completion tokens combined
+func (u agentUsage) TotalTokens() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+func (u *agentUsage) add(other agentUsage) {
+	u.Iterations += other.Iterations
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+}
+
+func (u agentUsage) String() string {
+	return fmt.Sprintf("%s, %s", pluralize(u.Iterations, "iteration", "iterations"), pluralize(u.TotalTokens(), "token", "tokens"))
+}
+
+// agentBudget is a token and iteration allowance shared by an agent and every sub-agent it spawns.
+// A nil budget is unlimited.
+type agentBudget struct {
+	mu            sync.Mutex
+	maxTokens     int // zero means unlimited
+	maxIterations int // zero means unlimited
+	used          agentUsage
+}
+
+func newAgentBudget(maxTokens, maxIterations int) *agentBudget {
+	return &agentBudget{maxTokens: maxTokens, maxIterations: maxIterations}
+}
+
+// reserve claims one model call from the budget, failing if the budget is already spent
+func (b *agentBudget) reserve() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.maxIterations > 0 && b.used.Iterations >= b.maxIterations {
+		return fmt.Errorf("%w: %d of %d iterations used", errBudgetExhausted, b.used.Iterations, b.maxIterations)
+	}
+	if b.maxTokens > 0 && b.used.TotalTokens() >= b.maxTokens {
+		return fmt.Errorf("%w: %d of %d tokens used", errBudgetExhausted, b.used.TotalTokens(), b.maxTokens)
+	}
+	b.used.Iterations++
+	return nil
+}
+
+// charge records the tokens consumed by a model call
+func (b *agentBudget) charge(promptTokens, completionTokens int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used.PromptTokens += promptTokens
+	b.used.CompletionTokens += completionTokens
+}
+
+// reset clears the usage so the budget can be reused for the next turn
+func (b *agentBudget) reset() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used = agentUsage{}
+}
+
+// usage returns what has been consumed so far
+func (b *agentBudget) usage() agentUsage {
+	if b == nil {
+		return agentUsage{}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// addUsage adds to the agent's own usage accounting
+func (a *Agent) addUsage(usage agentUsage) {
+	a.usageMu.Lock()
+	defer a.usageMu.Unlock()
+	a.usage.add(usage)
+}
+
+// totalUsage returns the model usage of the agent and all of its sub-agents
+func (a *Agent) totalUsage() agentUsage {
+	a.usageMu.Lock()
+	defer a.usageMu.Unlock()
+	return a.usage
+}
+
+// maxAgentDepth returns the configured sub-agent nesting limit
+func (a *Agent) maxAgentDepth() int {
+	if a.maxDepth <= 0 {
+		return defaultMaxAgentDepth
+	}
+	return a.maxDepth
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"agent/mocks"
+)
+
+func mockResponseWithUsage(content string, toolCalls []openai.ToolCall, prompt, completion int) openai.ChatCompletionResponse {
+	response := mocks.CreateMockResponse(content, toolCalls)
+	response.Usage = openai.Usage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion}
+	return response
+}
+
+func TestAgentBudget_IterationLimit(t *testing.T) {
+	budget := newAgentBudget(0, 2)
+
+	require.NoError(t, budget.reserve())
+	require.NoError(t, budget.reserve())
+	err := budget.reserve()
+	assert.ErrorIs(t, err, errBudgetExhausted)
+
+	budget.reset()
+	assert.NoError(t, budget.reserve())
+}
+
+func TestAgentBudget_TokenLimit(t *testing.T) {
+	budget := newAgentBudget(100, 0)
+
+	require.NoError(t, budget.reserve())
+	budget.charge(80, 30)
+	assert.ErrorIs(t, budget.reserve(), errBudgetExhausted)
+	assert.Equal(t, 110, budget.usage().TotalTokens())
+}
+
+func TestAgentBudget_NilIsUnlimited(t *testing.T) {
+	var budget *agentBudget
+
+	assert.NoError(t, budget.reserve())
+	budget.charge(10, 10)
+	budget.reset()
+	assert.Equal(t, agentUsage{}, budget.usage())
+}
+
+func TestDriveConversation_CancelledContext(t *testing.T) {
+	mockClient := mocks.NewMockOpenAIClient()
+	agent := NewAgent(NewOpenAIProvider(mockClient), nil, "test-model")
+	mockClient.AddResponse(mocks.CreateMockResponse("never sent", nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := agent.DriveConversation(ctx, []Message{{Role: RoleUser, Content: "hi"}}, nil, nil)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 0, mockClient.CallCount)
+}
+
+func TestHandleRunAgent_PropagatesCancellation(t *testing.T) {
+	mockClient := mocks.NewMockOpenAIClient()
+	agent := NewAgent(NewOpenAIProvider(mockClient), nil, "test-model")
+	mockClient.AddResponse(mocks.CreateMockResponse("never sent", nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	response := agent.handleRunAgent(ctx, newTestToolCall("run-1", "run_agent", RunAgentInput{Task: "anything"}))
+
+	assert.Contains(t, response.Content, "context canceled")
+	assert.Equal(t, 0, mockClient.CallCount)
+}
+
+func TestHandleRunAgent_DepthLimit(t *testing.T) {
+	mockClient := mocks.NewMockOpenAIClient()
+	agent := NewAgent(NewOpenAIProvider(mockClient), nil, "test-model")
+	agent.maxDepth = 2
+	agent.depth = 2
+
+	response := agent.handleRunAgent(context.Background(), newTestToolCall("run-2", "run_agent", RunAgentInput{Task: "nested"}))
+
+	assert.Contains(t, response.Content, "maximum sub-agent depth of 2 reached")
+	assert.Equal(t, 0, mockClient.CallCount)
+}
+
+func TestHandleRunAgent_NestedSubAgentsStopAtDepth(t *testing.T) {
+	mockClient := mocks.NewMockOpenAIClient()
+	agent := NewAgent(NewOpenAIProvider(mockClient), nil, "test-model")
+	agent.maxDepth = 1
+
+	// The sub-agent tries to spawn its own sub-agent, which is refused
+	nested := mocks.CreateMockToolCall("nested", "run_agent", `{"task": "go deeper"}`)
+	mockClient.AddResponse(mocks.CreateMockResponse("", []openai.ToolCall{nested}))
+	mockClient.AddResponse(mocks.CreateMockResponse("gave up nesting", nil))
+
+	response := agent.handleRunAgent(context.Background(), newTestToolCall("run-3", "run_agent", RunAgentInput{Task: "outer"}))
+
+	assert.Contains(t, response.Content, "gave up nesting")
+	require.Len(t, mockClient.Requests, 2)
+	nestedResult := mockClient.Requests[1].Messages[len(mockClient.Requests[1].Messages)-1]
+	assert.Contains(t, nestedResult.Content, "maximum sub-agent depth of 1 reached")
+	assert.Equal(t, 2, mockClient.CallCount)
+}
+
+func TestHandleRunAgent_ReportsUsageToParent(t *testing.T) {
+	mockClient := mocks.NewMockOpenAIClient()
+	agent := NewAgent(NewOpenAIProvider(mockClient), nil, "test-model")
+	mockClient.AddResponse(mockResponseWithUsage("sub-agent done", nil, 20, 10))
+
+	response := agent.handleRunAgent(context.Background(), newTestToolCall("run-4", "run_agent", RunAgentInput{Task: "count"}))
+
+	assert.Contains(t, response.Content, "- Usage: 1 iteration, 30 tokens")
+	assert.Equal(t, agentUsage{Iterations: 1, PromptTokens: 20, CompletionTokens: 10}, agent.totalUsage())
+}
+
+func TestDriveConversation_SharedBudgetStopsAgentTree(t *testing.T) {
+	mockClient := mocks.NewMockOpenAIClient()
+	agent := NewAgent(NewOpenAIProvider(mockClient), nil, "test-model")
+	agent.budget = newAgentBudget(0, 3)
+
+	// Parent spawns a sub-agent that keeps calling tools; the tree runs out after three model calls
+	runCall := mocks.CreateMockToolCall("call-1", "run_agent", `{"task": "loop"}`)
+	readCall := mocks.CreateMockToolCall("sub-1", "read_file", `{"path": "testdata/sample.txt"}`)
+	mockClient.AddResponse(mocks.CreateMockResponse("", []openai.ToolCall{runCall}))
+	for i := 0; i < 5; i++ {
+		mockClient.AddResponse(mocks.CreateMockResponse("", []openai.ToolCall{readCall}))
+	}
+
+	messages, err := agent.DriveConversation(context.Background(), []Message{{Role: RoleUser, Content: "go"}}, nil, nil)
+
+	assert.True(t, errors.Is(err, errBudgetExhausted))
+	assert.Equal(t, 3, mockClient.CallCount)
+	assert.Contains(t, messages[len(messages)-1].Content, "agent budget exhausted")
+}
+
+func TestInputManager_CancelOnInterrupt(t *testing.T) {
+	im := &InputManager{shouldClear: make(chan bool, 1)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stop := im.CancelOnInterrupt(cancel)
+	im.shouldClear <- true
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context was not cancelled")
+	}
+	stop()
+}
+
+func TestInputManager_CancelOnInterrupt_StopWithoutInterrupt(t *testing.T) {
+	im := &InputManager{shouldClear: make(chan bool, 1)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stop := im.CancelOnInterrupt(cancel)
+	stop()
+
+	assert.NoError(t, ctx.Err())
+	// The interrupt is left for the next GetInput call once the turn is over
+	im.shouldClear <- true
+	assert.Len(t, im.shouldClear, 1)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// cassetteVersion is increased when the cassette format changes incompatibly
+const cassetteVersion = 1
+
+// cassette is a recording of chat completion exchanges, replayed in tests in place of a model
+type cassette struct {
+	Version      int                   `json:"version"`
+	Interactions []cassetteInteraction `json:"interactions"`
+}
+
+// cassetteInteraction is one recorded request with its response or error
+type cassetteInteraction struct {
+	RequestHash string                         `json:"request_hash"`
+	Request     openai.ChatCompletionRequest   `json:"request"`
+	Response    *openai.ChatCompletionResponse `json:"response,omitempty"`
+	Error       string                         `json:"error,omitempty"`
+	APIError    *openai.APIError               `json:"api_error,omitempty"` // set when Error came from the API
+	Status      int                            `json:"status,omitempty"`    // HTTP status of APIError
+}
+
+// err rebuilds the recorded error, as an *openai.APIError when the API returned it, so fallback
+// and error handling see the same error as in the recorded session
+func (i cassetteInteraction) err() error {
+	if i.APIError == nil {
+		return errors.New(i.Error)
+	}
+	apiErr := *i.APIError
+	apiErr.HTTPStatusCode = i.Status
+	apiErr.HTTPStatus = fmt.Sprintf("%d %s", i.Status, http.StatusText(i.Status))
+	return &apiErr
+}
+
+// requestHash identifies a request by its JSON encoding
+func requestHash(request openai.ChatCompletionRequest) string {
+	data, _ := json.Marshal(request)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// loadCassette reads a cassette file
+func loadCassette(path string) (*cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("cassette %s: %w", path, err)
+	}
+	if c.Version != cassetteVersion {
+		return nil, fmt.Errorf("cassette %s: unsupported version %d, expected %d", path, c.Version, cassetteVersion)
+	}
+	return &c, nil
+}
+
+// cassetteRecorder saves the exchanges of every client it wraps to one cassette file, rewriting
+// it after each exchange so an interrupted session keeps what it recorded
+type cassetteRecorder struct {
+	path     string
+	mu       sync.Mutex
+	cassette cassette
+}
+
+func newCassetteRecorder(path string) *cassetteRecorder {
+	return &cassetteRecorder{path: path, cassette: cassette{Version: cassetteVersion}}
+}
+
+// wrap returns a client that records its exchanges with client
+func (r *cassetteRecorder) wrap(client OpenAIClient) OpenAIClient {
+	return &recordingClient{client: client, recorder: r}
+}
+
+func (r *cassetteRecorder) record(interaction cassetteInteraction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	data, err := json.MarshalIndent(r.cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Cassettes hold whole conversations, including the files the agent read
+	return os.WriteFile(r.path, append(data, '\n'), 0600)
+}
+
+// recordingClient is an OpenAIClient that records the exchanges of the client it decorates
+type recordingClient struct {
+	client   OpenAIClient
+	recorder *cassetteRecorder
+}
+
+func (c *recordingClient) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	resp, err := c.client.CreateChatCompletion(ctx, request)
+	// A cancelled request says nothing about the model, so it is not worth replaying
+	if ctx.Err() != nil {
+		return resp, err
+	}
+	interaction := cassetteInteraction{RequestHash: requestHash(request), Request: request}
+	if err != nil {
+		interaction.Error = err.Error()
+		var apiErr *openai.APIError
+		if errors.As(err, &apiErr) {
+			interaction.APIError, interaction.Status = apiErr, apiErr.HTTPStatusCode
+		}
+	} else {
+		interaction.Response = &resp
+	}
+	if recordErr := c.recorder.record(interaction); recordErr != nil {
+		slog.Warn("recording to cassette failed", "path", c.recorder.path, "error", recordErr)
+	}
+	return resp, err
+}
+
+// How a replay client finds the recording for a request
+type cassetteMatch int
+
+const (
+	// matchSequence expects the requests in the recorded order
+	matchSequence cassetteMatch = iota
+	// matchHash serves the first unused recording of an identical request, for sessions whose
+	// requests are not ordered, like parallel sub-agents
+	matchHash
+)
+
+// replayClient is an OpenAIClient that answers from a cassette. A request that differs from the
+// recording fails with a diff of the two.
+type replayClient struct {
+	cassette *cassette
+	match    cassetteMatch
+	mu       sync.Mutex
+	used     []bool
+	requests int
+}
+
+func newReplayClient(c *cassette, match cassetteMatch) *replayClient {
+	return &replayClient{cassette: c, match: match, used: make([]bool, len(c.Interactions))}
+}
+
+func (c *replayClient) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests++
+
+	next := -1 // the first unused recording
+	for i, used := range c.used {
+		if !used {
+			next = i
+			break
+		}
+	}
+	if next < 0 {
+		return openai.ChatCompletionResponse{}, fmt.Errorf("cassette exhausted: request %d was not recorded", c.requests)
+	}
+
+	found := -1
+	hash := requestHash(request)
+	switch c.match {
+	case matchSequence:
+		if c.cassette.Interactions[next].RequestHash == hash {
+			found = next
+		}
+	case matchHash:
+		for i := next; i < len(c.cassette.Interactions); i++ {
+			if !c.used[i] && c.cassette.Interactions[i].RequestHash == hash {
+				found = i
+				break
+			}
+		}
+	}
+	if found < 0 {
+		return openai.ChatCompletionResponse{}, fmt.Errorf("request %d does not match the cassette; diff from recording %d:\n%s",
+			c.requests, next+1, requestDiff(c.cassette.Interactions[next].Request, request))
+	}
+
+	c.used[found] = true
+	interaction := c.cassette.Interactions[found]
+	if interaction.Error != "" {
+		return openai.ChatCompletionResponse{}, interaction.err()
+	}
+	return *interaction.Response, nil
+}
+
+// unused returns how many recordings have not been replayed
+func (c *replayClient) unused() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, used := range c.used {
+		if !used {
+			n++
+		}
+	}
+	return n
+}
+
+// requestDiff shows how a request differs from a recorded one, as a diff of their indented JSON
+func requestDiff(recorded, actual openai.ChatCompletionRequest) string {
+	before, _ := json.MarshalIndent(recorded, "", "  ")
+	after, _ := json.MarshalIndent(actual, "", "  ")
+	return unifiedDiff("request.json", string(before)+"\n", string(after)+"\n", true)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"agent/mocks"
+)
+
+// runCassetteSession asks an agent working in dir to look around, as a session worth recording
+func runCassetteSession(client OpenAIClient, dir, prompt string) ([]Message, error) {
+	agent := NewAgent(NewOpenAIProvider(client), nil, "test-model")
+	agent.workDir = dir
+	return agent.DriveConversation(context.Background(), []Message{{Role: RoleUser, Content: prompt}}, nil, nil)
+}
+
+func recordTestCassette(t *testing.T, dir string) string {
+	mockClient := mocks.NewMockOpenAIClient()
+	mockClient.AddResponse(mocks.CreateMockResponse("", []openai.ToolCall{mocks.CreateMockToolCall("call-1", "list_dir", `{"path":"."}`)}))
+	mockClient.AddResponse(mocks.CreateMockResponse("There is one file.", nil))
+	path := filepath.Join(t.TempDir(), "session.json")
+
+	_, err := runCassetteSession(newCassetteRecorder(path).wrap(mockClient), dir, "What is here?")
+	require.NoError(t, err)
+	return path
+}
+
+func TestCassette_RecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("hi"), 0644))
+	path := recordTestCassette(t, dir)
+
+	recorded, err := loadCassette(path)
+	require.NoError(t, err)
+	require.Len(t, recorded.Interactions, 2)
+	assert.Equal(t, requestHash(recorded.Interactions[0].Request), recorded.Interactions[0].RequestHash)
+	assert.Equal(t, "What is here?", recorded.Interactions[0].Request.Messages[0].Content)
+
+	for _, match := range []cassetteMatch{matchSequence, matchHash} {
+		replay := newReplayClient(recorded, match)
+		messages, err := runCassetteSession(replay, dir, "What is here?")
+
+		require.NoError(t, err)
+		assert.Equal(t, "There is one file.", messages[len(messages)-1].Content)
+		assert.Equal(t, 0, replay.unused())
+	}
+}
+
+func TestCassette_ReplayReportsDivergence(t *testing.T) {
+	dir := t.TempDir()
+	recorded, err := loadCassette(recordTestCassette(t, dir))
+	require.NoError(t, err)
+
+	_, err = runCassetteSession(newReplayClient(recorded, matchSequence), dir, "What is there?")
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "request 1 does not match the cassette; diff from recording 1:\n--- a/request.json\n+++ b/request.json\n")
+	assert.Contains(t, err.Error(), "\n-      \"content\": \"What is here?\"\n+      \"content\": \"What is there?\"\n")
+}
+
+func TestCassette_HashMatching(t *testing.T) {
+	first := openai.ChatCompletionRequest{Model: "a"}
+	second := openai.ChatCompletionRequest{Model: "b"}
+	recorded := &cassette{Version: cassetteVersion, Interactions: []cassetteInteraction{
+		{RequestHash: requestHash(first), Request: first, Response: &openai.ChatCompletionResponse{ID: "first"}},
+		{RequestHash: requestHash(second), Request: second, Error: "rate limited"},
+	}}
+
+	// Requests may arrive out of order when matching by hash
+	replay := newReplayClient(recorded, matchHash)
+	_, err := replay.CreateChatCompletion(context.Background(), second)
+	assert.EqualError(t, err, "rate limited")
+	resp, err := replay.CreateChatCompletion(context.Background(), first)
+	require.NoError(t, err)
+	assert.Equal(t, "first", resp.ID)
+	_, err = replay.CreateChatCompletion(context.Background(), first)
+	assert.EqualError(t, err, "cassette exhausted: request 3 was not recorded")
+
+	_, err = newReplayClient(recorded, matchSequence).CreateChatCompletion(context.Background(), second)
+	assert.ErrorContains(t, err, "request 1 does not match the cassette")
+}
+
+func TestCassette_ReplaysAPIErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rate-limited.json")
+	mockClient := mocks.NewMockOpenAIClient()
+	recordedErr := &openai.APIError{Message: "slow down", Type: "rate_limit_error", HTTPStatus: "429 Too Many Requests", HTTPStatusCode: 429}
+	mockClient.AddError(fmt.Errorf("wrapped: %w", recordedErr))
+	_, err := newCassetteRecorder(path).wrap(mockClient).CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{Model: "a"})
+	require.Error(t, err)
+
+	recorded, err := loadCassette(path)
+	require.NoError(t, err)
+	_, err = newReplayClient(recorded, matchSequence).CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{Model: "a"})
+
+	var apiErr *openai.APIError
+	require.True(t, errors.As(err, &apiErr), "%T: %v", err, err)
+	assert.Equal(t, 429, apiErr.HTTPStatusCode)
+	assert.Equal(t, "rate_limit_error", apiErr.Type)
+	assert.Equal(t, recordedErr.Error(), apiErr.Error())
+}
+
+func TestBuildProvider_RefusesToRecordAnthropic(t *testing.T) {
+	_, err := buildProvider(ProviderConfig{Type: providerAnthropic, APIKey: "key", recorder: newCassetteRecorder(filepath.Join(t.TempDir(), "c.json"))})
+	assert.EqualError(t, err, "cassettes can only record OpenAI-compatible providers")
+}
+
+func TestCassette_RecordsErrorsButNotCancellations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "errors.json")
+	mockClient := mocks.NewMockOpenAIClient()
+	mockClient.AddError(errors.New("server exploded"))
+	client := newCassetteRecorder(path).wrap(mockClient)
+
+	_, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{Model: "a"})
+	assert.EqualError(t, err, "server exploded")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{Model: "b"})
+
+	recorded, err := loadCassette(path)
+	require.NoError(t, err)
+	require.Len(t, recorded.Interactions, 1)
+	assert.Equal(t, "server exploded", recorded.Interactions[0].Error)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// commandsDir is where user-defined slash commands live within a configuration directory
+const commandsDir = "commands"
+
+// slashCommand is a REPL command typed as /name followed by arguments
+type slashCommand struct {
+	name        string
+	usage       string // argument synopsis shown by /help
+	description string
+	source      string // file a user-defined command was loaded from; empty for built-ins
+	// run performs the command. A non-empty prompt is sent to the model as the user's message.
+	run func(ctx context.Context, session *replSession, args string) (prompt string, err error)
+}
+
+// replSession is the interactive conversation commands act on
+type replSession struct {
+	agent    *Agent
+	messages []Message
+	out      io.Writer
+	exit     bool // set to end the session
+}
+
+// commandRegistry holds the slash commands available in the REPL
+type commandRegistry struct {
+	commands map[string]*slashCommand
+	aliases  map[string]string
+}
+
+// newCommandRegistry returns a registry holding the built-in commands
+func newCommandRegistry() *commandRegistry {
+	r := &commandRegistry{commands: make(map[string]*slashCommand), aliases: make(map[string]string)}
+	r.register(&slashCommand{name: "help", usage: "[command]", description: "List commands or describe one", run: r.runHelp})
+	r.register(&slashCommand{name: "clear", description: "Start a new conversation", run: runClear})
+	r.register(&slashCommand{name: "model", usage: "[name]", description: "Show the models or switch model", run: runModel})
+	r.register(&slashCommand{name: "tools", description: "List the tools the model can use", run: runTools})
+	r.register(&slashCommand{name: "history", usage: "[count]", description: "Show the latest messages of the conversation", run: runHistory})
+	r.register(&slashCommand{name: "save", usage: "[path]", description: "Save the conversation as JSON", run: runSave})
+	r.register(&slashCommand{name: "exit", description: "Quit the agent", run: runExit})
+	r.aliases["quit"] = "exit"
+	return r
+}
+
+// register adds a command, replacing any command of the same name
+func (r *commandRegistry) register(command *slashCommand) {
+	r.commands[command.name] = command
+}
+
+// lookup finds a command by name or alias
+func (r *commandRegistry) lookup(name string) (*slashCommand, bool) {
+	if target, ok := r.aliases[name]; ok {
+		name = target
+	}
+	command, ok := r.commands[name]
+	return command, ok
+}
+
+// names returns the command names in sorted order
+func (r *commandRegistry) names() []string {
+	return sortedKeys(r.commands)
+}
+
+// isCommand reports whether a line of input is a slash command
+func isCommand(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "/")
+}
+
+// dispatch runs the command on line and returns the prompt, if any, to send to the model
+func (r *commandRegistry) dispatch(ctx context.Context, session *replSession, line string) (string, error) {
+	name, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+	name = strings.TrimPrefix(name, "/")
+	command, ok := r.lookup(name)
+	if !ok {
+		return "", fmt.Errorf("unknown command /%s, type /help for the list of commands", name)
+	}
+	return command.run(ctx, session, strings.TrimSpace(args))
+}
+
+// complete returns the completions of a partially typed command line: command names while the
+// name is being typed, otherwise nothing
+func (r *commandRegistry) complete(line string) []string {
+	if !strings.HasPrefix(line, "/") || strings.ContainsAny(line, " \t") {
+		return nil
+	}
+	var matches []string
+	for _, name := range append(r.names(), sortedKeys(r.aliases)...) {
+		if strings.HasPrefix("/"+name, line) {
+			matches = append(matches, "/"+name)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// splitArgs splits command arguments on whitespace, keeping quoted strings together
+func splitArgs(args string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	for _, c := range args {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(c)
+		case c == '"' || c == '\'':
+			quote, inWord = c, true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", args)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+func (r *commandRegistry) runHelp(ctx context.Context, session *replSession, args string) (string, error) {
+	if args != "" {
+		command, ok := r.lookup(strings.TrimPrefix(args, "/"))
+		if !ok {
+			return "", fmt.Errorf("unknown command /%s", strings.TrimPrefix(args, "/"))
+		}
+		fmt.Fprintf(session.out, "%s\n%s\n", strings.TrimSpace("/"+command.name+" "+command.usage), command.description)
+		if command.source != "" {
+			fmt.Fprintf(session.out, "Defined in %s\n", command.source)
+		}
+		return "", nil
+	}
+
+	fmt.Fprintln(session.out, "Commands:")
+	for _, name := range r.names() {
+		command := r.commands[name]
+		synopsis := strings.TrimSpace("/" + name + " " + command.usage)
+		fmt.Fprintf(session.out, "  %-22s %s\n", synopsis, command.description)
+	}
+	return "", nil
+}
+
+func runClear(ctx context.Context, session *replSession, args string) (string, error) {
+	session.messages = nil
+	fmt.Fprintln(session.out, "Conversation cleared.")
+	return "", nil
+}
+
+func runModel(ctx context.Context, session *replSession, args string) (string, error) {
+	fmt.Fprint(session.out, session.agent.modelCommand(args))
+	return "", nil
+}
+
+func runTools(ctx context.Context, session *replSession, args string) (string, error) {
+	for _, tool := range session.agent.tools {
+		description, _, _ := strings.Cut(tool.Description, "\n")
+		fmt.Fprintf(session.out, "  %-14s %s\n", tool.Name, description)
+	}
+	return "", nil
+}
+
+// historyPreviewLength is how many characters of each message /history shows
+const historyPreviewLength = 200
+
+func runHistory(ctx context.Context, session *replSession, args string) (string, error) {
+	messages := session.messages
+	if args != "" {
+		count, err := strconv.Atoi(args)
+		if err != nil || count <= 0 {
+			return "", fmt.Errorf("usage: /history [count]")
+		}
+		if count < len(messages) {
+			messages = messages[len(messages)-count:]
+		}
+	}
+	if len(messages) == 0 {
+		fmt.Fprintln(session.out, "The conversation is empty.")
+		return "", nil
+	}
+
+	for _, msg := range messages {
+		content := strings.Join(strings.Fields(msg.Content), " ")
+		if runes := []rune(content); len(runes) > historyPreviewLength {
+			content = string(runes[:historyPreviewLength]) + "..."
+		}
+		for _, toolCall := range msg.ToolCalls {
+			content = strings.TrimSpace(content + fmt.Sprintf(" [%s %s]", toolCall.Name, toolCall.Arguments))
+		}
+		fmt.Fprintf(session.out, "%s: %s\n", msg.Role, content)
+	}
+	return "", nil
+}
+
+func runSave(ctx context.Context, session *replSession, args string) (string, error) {
+	words, err := splitArgs(args)
+	if err != nil {
+		return "", err
+	}
+	if len(words) > 1 {
+		return "", fmt.Errorf("usage: /save [path]")
+	}
+
+	var path string
+	if len(words) == 0 {
+		store := session.agent.transcripts
+		if store == nil {
+			store = newTranscriptStore(sessionTranscriptDir(session.agent.sessionID))
+		}
+		path, err = store.save("conversation", session.messages)
+	} else {
+		path = words[0]
+		var data []byte
+		data, err = json.MarshalIndent(session.messages, "", "  ")
+		if err == nil {
+			err = os.WriteFile(path, data, 0644)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("saving conversation: %w", err)
+	}
+	fmt.Fprintf(session.out, "Saved %d messages to %s\n", len(session.messages), path)
+	return "", nil
+}
+
+func runExit(ctx context.Context, session *replSession, args string) (string, error) {
+	session.exit = true
+	return "", nil
+}
+
+// customCommand is the front matter of a user-defined command
+type customCommand struct {
+	Description string `yaml:"description"`
+	Usage       string `yaml:"usage"`
+}
+
+// loadCustomCommands adds the markdown prompt templates in the commands directory of the user's and
+// then the project's configuration directory, so project commands take precedence. Running one sends
+// its template to the model with $ARGUMENTS replaced by the command's arguments.
+func (r *commandRegistry) loadCustomCommands(projectDir, userDir string) error {
+	for _, root := range []string{userDir, projectDir} {
+		if root == "" {
+			continue
+		}
+		dir := filepath.Join(root, commandsDir)
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || filepath.Ext(entry.Name()) != ".md" {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			var meta customCommand
+			template, err := parseFrontMatter(data, &meta)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if meta.Description == "" {
+				meta.Description = "Custom command"
+			}
+			r.register(&slashCommand{
+				name:        strings.TrimSuffix(entry.Name(), ".md"),
+				usage:       meta.Usage,
+				description: meta.Description,
+				source:      path,
+				run: func(ctx context.Context, session *replSession, args string) (string, error) {
+					return strings.ReplaceAll(template, "$ARGUMENTS", args), nil
+				},
+			})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSession() (*replSession, *bytes.Buffer) {
+	var out bytes.Buffer
+	agent := NewAgent(&scriptedProvider{}, nil, "test-model")
+	return &replSession{agent: agent, out: &out}, &out
+}
+
+func TestCommandRegistry_Help(t *testing.T) {
+	registry := newCommandRegistry()
+	session, out := newTestSession()
+
+	prompt, err := registry.dispatch(context.Background(), session, "/help")
+	require.NoError(t, err)
+
+	assert.Empty(t, prompt)
+	for _, name := range []string{"/clear", "/exit", "/help [command]", "/history [count]", "/model [name]", "/save [path]", "/tools"} {
+		assert.Contains(t, out.String(), name)
+	}
+
+	out.Reset()
+	_, err = registry.dispatch(context.Background(), session, "/help quit")
+	require.NoError(t, err)
+	assert.Equal(t, "/exit\nQuit the agent\n", out.String())
+}
+
+func TestCommandRegistry_Unknown(t *testing.T) {
+	session, _ := newTestSession()
+
+	_, err := newCommandRegistry().dispatch(context.Background(), session, "/frobnicate now")
+
+	assert.EqualError(t, err, "unknown command /frobnicate, type /help for the list of commands")
+}
+
+func TestCommandRegistry_ClearAndExit(t *testing.T) {
+	registry := newCommandRegistry()
+	session, out := newTestSession()
+	session.messages = []Message{{Role: RoleUser, Content: "hello"}}
+
+	_, err := registry.dispatch(context.Background(), session, "/clear")
+	require.NoError(t, err)
+	assert.Empty(t, session.messages)
+	assert.Equal(t, "Conversation cleared.\n", out.String())
+
+	_, err = registry.dispatch(context.Background(), session, "  /quit ")
+	require.NoError(t, err)
+	assert.True(t, session.exit)
+}
+
+func TestCommandRegistry_History(t *testing.T) {
+	registry := newCommandRegistry()
+	session, out := newTestSession()
+	session.messages = []Message{
+		{Role: RoleUser, Content: "Read a.go"},
+		{Role: RoleAssistant, Content: "Reading.", ToolCalls: []ToolCall{{ID: "c1", Name: "read_file", Arguments: `{"path":"a.go"}`}}},
+		{Role: RoleTool, ToolCallID: "c1", Content: "package a\n\nfunc A() {}"},
+	}
+
+	_, err := registry.dispatch(context.Background(), session, "/history 2")
+	require.NoError(t, err)
+
+	assert.Equal(t, "assistant: Reading. [read_file {\"path\":\"a.go\"}]\ntool: package a func A() {}\n", out.String())
+
+	_, err = registry.dispatch(context.Background(), session, "/history many")
+	assert.EqualError(t, err, "usage: /history [count]")
+}
+
+func TestCommandRegistry_HistoryTruncatesOnCharacters(t *testing.T) {
+	registry := newCommandRegistry()
+	session, out := newTestSession()
+	session.messages = []Message{{Role: RoleUser, Content: strings.Repeat("a", historyPreviewLength-1) + "ééé"}}
+
+	_, err := registry.dispatch(context.Background(), session, "/history")
+	require.NoError(t, err)
+
+	assert.True(t, utf8.ValidString(out.String()))
+	assert.Equal(t, "user: "+strings.Repeat("a", historyPreviewLength-1)+"é...\n", out.String())
+}
+
+func TestCommandRegistry_Save(t *testing.T) {
+	registry := newCommandRegistry()
+	session, out := newTestSession()
+	session.messages = []Message{{Role: RoleUser, Content: "hello"}}
+	path := filepath.Join(t.TempDir(), "my chat.json")
+
+	_, err := registry.dispatch(context.Background(), session, `/save "`+path+`"`)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Saved 1 messages to "+path+"\n", out.String())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var saved []Message
+	require.NoError(t, json.Unmarshal(data, &saved))
+	assert.Equal(t, session.messages, saved)
+}
+
+func TestCommandRegistry_Tools(t *testing.T) {
+	session, out := newTestSession()
+	require.NoError(t, session.agent.restrictTools([]string{"read_file", "git_status"}))
+
+	_, err := newCommandRegistry().dispatch(context.Background(), session, "/tools")
+	require.NoError(t, err)
+
+	assert.Contains(t, out.String(), "read_file")
+	assert.Contains(t, out.String(), "git_status")
+	assert.NotContains(t, out.String(), "write_to_file")
+}
+
+func TestCommandRegistry_Complete(t *testing.T) {
+	registry := newCommandRegistry()
+
+	assert.Equal(t, []string{"/help", "/history"}, registry.complete("/h"))
+	assert.Equal(t, []string{"/quit"}, registry.complete("/q"))
+	assert.Nil(t, registry.complete("/help "))
+	assert.Nil(t, registry.complete("hello"))
+}
+
+func TestCommandRegistry_CustomCommands(t *testing.T) {
+	userDir := t.TempDir()
+	projectDir := t.TempDir()
+	for dir, files := range map[string]map[string]string{
+		userDir:    {"review.md": "Review everything.", "explain.md": "Explain $ARGUMENTS simply."},
+		projectDir: {"review.md": "---\ndescription: Review a file\nusage: <path>\n---\nReview $ARGUMENTS for bugs. Focus on $ARGUMENTS only."},
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, commandsDir), 0755))
+		for name, content := range files {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, commandsDir, name), []byte(content), 0644))
+		}
+	}
+	registry := newCommandRegistry()
+	require.NoError(t, registry.loadCustomCommands(projectDir, userDir))
+	session, out := newTestSession()
+
+	prompt, err := registry.dispatch(context.Background(), session, "/review main.go")
+	require.NoError(t, err)
+	assert.Equal(t, "Review main.go for bugs. Focus on main.go only.", prompt)
+
+	prompt, err = registry.dispatch(context.Background(), session, "/explain")
+	require.NoError(t, err)
+	assert.Equal(t, "Explain  simply.", prompt)
+
+	_, err = registry.dispatch(context.Background(), session, "/help review")
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "/review <path>\nReview a file\nDefined in "+filepath.Join(projectDir, commandsDir, "review.md"))
+}
+
+func TestSplitArgs(t *testing.T) {
+	words, err := splitArgs(`one "two three" 'four' "" five`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"one", "two three", "four", "", "five"}, words)
+
+	_, err = splitArgs(`"open`)
+	assert.EqualError(t, err, `unterminated quote in "\"open"`)
+}
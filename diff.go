@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// diffContext is the number of unchanged lines shown around each change
+	diffContext = 3
+	// maxDiffCells bounds the line comparison table; larger files are diffed as a full replacement
+	maxDiffCells = 1 << 22
+)
+
+// unifiedDiff returns a unified diff that turns before into after, or "" when they are equal. A
+// missing file is diffed from /dev/null.
+func unifiedDiff(path, before, after string, existed bool) string {
+	if existed && before == after {
+		return ""
+	}
+	ops := diffLines(splitLines(before), splitLines(after))
+
+	var out strings.Builder
+	if existed {
+		fmt.Fprintf(&out, "--- a/%s\n", path)
+	} else {
+		out.WriteString("--- /dev/null\n")
+	}
+	fmt.Fprintf(&out, "+++ b/%s\n", path)
+
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		// Extend the hunk while the next change is close enough to share context
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j
+			} else if j-end > 2*diffContext {
+				break
+			}
+		}
+		start := max(0, i-diffContext)
+		stop := min(len(ops), end+diffContext+1)
+		writeHunk(&out, ops, start, stop)
+		i = stop
+	}
+	return out.String()
+}
+
+// diffOp is one line of an edit script: ' ' kept, '-' removed or '+' added
+type diffOp struct {
+	kind     byte
+	line     string
+	old, new int // line numbers before and after the change, counting from 1
+}
+
+// writeHunk writes the ops in [start, stop) as a unified diff hunk
+func writeHunk(out *strings.Builder, ops []diffOp, start, stop int) {
+	oldStart, newStart := ops[start].old, ops[start].new
+	var oldCount, newCount int
+	for _, op := range ops[start:stop] {
+		if op.kind != '+' {
+			oldCount++
+		}
+		if op.kind != '-' {
+			newCount++
+		}
+	}
+	// An empty side is numbered after the line it follows
+	if oldCount == 0 {
+		oldStart--
+	}
+	if newCount == 0 {
+		newStart--
+	}
+	fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+	for _, op := range ops[start:stop] {
+		out.WriteByte(op.kind)
+		out.WriteString(strings.TrimSuffix(op.line, "\n"))
+		out.WriteByte('\n')
+		if !strings.HasSuffix(op.line, "\n") {
+			out.WriteString("\\ No newline at end of file\n")
+		}
+	}
+}
+
+// splitLines splits text into lines that keep their line endings
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns the edit script turning a into b, keeping their longest common subsequence
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	// Trim the common prefix and suffix, which is most of a typical edit
+	prefix := 0
+	for prefix < n && prefix < m && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < n-prefix && suffix < m-prefix && a[n-1-suffix] == b[m-1-suffix] {
+		suffix++
+	}
+	midA, midB := a[prefix:n-suffix], b[prefix:m-suffix]
+
+	var ops []diffOp
+	oldLine, newLine := 1, 1
+	keep := func(line string) {
+		ops = append(ops, diffOp{kind: ' ', line: line, old: oldLine, new: newLine})
+		oldLine++
+		newLine++
+	}
+	remove := func(line string) {
+		ops = append(ops, diffOp{kind: '-', line: line, old: oldLine, new: newLine})
+		oldLine++
+	}
+	add := func(line string) {
+		ops = append(ops, diffOp{kind: '+', line: line, old: oldLine, new: newLine})
+		newLine++
+	}
+
+	for _, line := range a[:prefix] {
+		keep(line)
+	}
+	if (len(midA)+1)*(len(midB)+1) > maxDiffCells {
+		for _, line := range midA {
+			remove(line)
+		}
+		for _, line := range midB {
+			add(line)
+		}
+	} else {
+		// lcs[i][j] is the length of the longest common subsequence of midA[i:] and midB[j:]
+		lcs := make([][]int, len(midA)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(midB)+1)
+		}
+		for i := len(midA) - 1; i >= 0; i-- {
+			for j := len(midB) - 1; j >= 0; j-- {
+				if midA[i] == midB[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(midA) || j < len(midB) {
+			switch {
+			case i < len(midA) && j < len(midB) && midA[i] == midB[j]:
+				keep(midA[i])
+				i++
+				j++
+			case j == len(midB) || (i < len(midA) && lcs[i+1][j] >= lcs[i][j+1]):
+				remove(midA[i])
+				i++
+			default:
+				add(midB[j])
+				j++
+			}
+		}
+	}
+	for _, line := range a[n-suffix:] {
+		keep(line)
+	}
+	return ops
+}
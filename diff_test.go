@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func numberedLines(from, to int) string {
+	var b strings.Builder
+	for i := from; i <= to; i++ {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	return b.String()
+}
+
+func TestUnifiedDiff_Hunks(t *testing.T) {
+	before := numberedLines(1, 20)
+	after := strings.Replace(before, "line 2\n", "line two\n", 1)
+	after = strings.Replace(after, "line 18\n", "", 1) + "line 21\n"
+
+	assert.Equal(t, "--- a/f.txt\n+++ b/f.txt\n"+
+		"@@ -1,5 +1,5 @@\n line 1\n-line 2\n+line two\n line 3\n line 4\n line 5\n"+
+		"@@ -15,6 +15,6 @@\n line 15\n line 16\n line 17\n-line 18\n line 19\n line 20\n+line 21\n",
+		unifiedDiff("f.txt", before, after, true))
+}
+
+func TestUnifiedDiff_NearbyChangesShareAHunk(t *testing.T) {
+	before := numberedLines(1, 12)
+	after := strings.Replace(strings.Replace(before, "line 3\n", "", 1), "line 9\n", "line nine\n", 1)
+
+	assert.Equal(t, "--- a/f.txt\n+++ b/f.txt\n"+
+		"@@ -1,12 +1,11 @@\n line 1\n line 2\n-line 3\n line 4\n line 5\n line 6\n line 7\n line 8\n-line 9\n+line nine\n line 10\n line 11\n line 12\n",
+		unifiedDiff("f.txt", before, after, true))
+}
+
+func TestUnifiedDiff_NewAndUnchangedFiles(t *testing.T) {
+	assert.Equal(t, "--- /dev/null\n+++ b/new.txt\n@@ -0,0 +1,2 @@\n+a\n+b\n", unifiedDiff("new.txt", "", "a\nb\n", false))
+	assert.Equal(t, "--- a/empty.txt\n+++ b/empty.txt\n@@ -1,1 +0,0 @@\n-a\n", unifiedDiff("empty.txt", "a\n", "", true))
+	assert.Empty(t, unifiedDiff("same.txt", "a\n", "a\n", true))
+	assert.Equal(t, "--- /dev/null\n+++ b/empty.txt\n", unifiedDiff("empty.txt", "", "", false), "creating an empty file is still a change")
+}
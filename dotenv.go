@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dotEnvFileName is the file environment variables are loaded from
+const dotEnvFileName = ".env"
+
+// dotEnvVar is one assignment from a .env file
+type dotEnvVar struct {
+	name  string
+	value string
+}
+
+// loadDotEnv sets the variables from .env files in the workspace and then the user config directory.
+// Variables that are already set are never overridden, so the environment beats the workspace file,
+// which beats the user file.
+func loadDotEnv(workspaceDir, userDir string) error {
+	for _, dir := range []string{workspaceDir, userDir} {
+		if dir == "" {
+			continue
+		}
+		path := filepath.Join(dir, dotEnvFileName)
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		vars, err := parseDotEnv(string(data), os.LookupEnv)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for _, v := range vars {
+			if _, ok := os.LookupEnv(v.name); ok {
+				continue
+			}
+			if err := os.Setenv(v.name, v.value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseDotEnv parses NAME=value lines. Lines may start with "export"; # starts a comment outside quotes.
+// Unquoted and double-quoted values expand $NAME and ${NAME} from earlier lines, then lookup; double-quoted
+// values may span lines and decode backslash escapes such as \n and \$. Single-quoted values are literal.
+func parseDotEnv(data string, lookup func(string) (string, bool)) ([]dotEnvVar, error) {
+	var vars []dotEnvVar
+	defined := make(map[string]string)
+	expand := func(name string) string {
+		if value, ok := defined[name]; ok {
+			return value
+		}
+		value, _ := lookup(name)
+		return value
+	}
+
+	rest := strings.ReplaceAll(data, "\r\n", "\n")
+	for lineNumber := 1; rest != ""; lineNumber++ {
+		line, remainder, _ := strings.Cut(rest, "\n")
+		rest = remainder
+
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if after, ok := strings.CutPrefix(line, "export"); ok && (strings.HasPrefix(after, " ") || strings.HasPrefix(after, "\t")) {
+			line = strings.TrimSpace(after)
+		}
+
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !isEnvName(name) {
+			return nil, fmt.Errorf("line %d: expected NAME=value", lineNumber)
+		}
+		value = strings.TrimLeft(value, " \t")
+
+		switch {
+		case strings.HasPrefix(value, "'"):
+			end := strings.Index(value[1:], "'")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated single-quoted value", lineNumber)
+			}
+			value = value[1 : end+1]
+		case strings.HasPrefix(value, `"`):
+			// Double-quoted values may continue over the following lines
+			start := lineNumber
+			for closingQuote(value[1:]) < 0 {
+				if rest == "" {
+					return nil, fmt.Errorf("line %d: unterminated double-quoted value", start)
+				}
+				next, remainder, _ := strings.Cut(rest, "\n")
+				value += "\n" + next
+				rest = remainder
+				lineNumber++
+			}
+			value = expandDotEnv(value[1:closingQuote(value[1:])+1], expand, true)
+		default:
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = value[:i]
+			}
+			if i := strings.Index(value, "\t#"); i >= 0 {
+				value = value[:i]
+			}
+			value = expandDotEnv(strings.TrimSpace(value), expand, false)
+		}
+
+		defined[name] = value
+		vars = append(vars, dotEnvVar{name: name, value: value})
+	}
+	return vars, nil
+}
+
+// closingQuote returns the index of the first unescaped double quote in s, or -1
+func closingQuote(s string) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// expandDotEnv replaces $NAME and ${NAME} references. With escapes, backslash sequences are decoded
+// too and \$ produces a literal dollar sign.
+func expandDotEnv(s string, expand func(string) string, escapes bool) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escapes && s[i] == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				out.WriteByte('\n')
+			case 't':
+				out.WriteByte('\t')
+			case 'r':
+				out.WriteByte('\r')
+			default:
+				out.WriteByte(s[i])
+			}
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				out.WriteString(s[i:])
+				return out.String()
+			}
+			out.WriteString(expand(s[i+2 : i+end]))
+			i += end
+		case s[i] == '$':
+			j := i + 1
+			for j < len(s) && isEnvNameByte(s[j], j == i+1) {
+				j++
+			}
+			if j == i+1 {
+				out.WriteByte('$')
+				continue
+			}
+			out.WriteString(expand(s[i+1 : j]))
+			i = j - 1
+		default:
+			out.WriteByte(s[i])
+		}
+	}
+	return out.String()
+}
+
+func isEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isEnvNameByte(name[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func isEnvNameByte(c byte, first bool) bool {
+	return c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || !first && c >= '0' && c <= '9'
+}
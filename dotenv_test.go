@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func lookupFrom(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func TestParseDotEnv(t *testing.T) {
+	data := `# Endpoint for the model server
+LLM_ENDPOINT=https://openrouter.ai/api/v1
+export LLM_KEY = sk-123   # trailing comment
+EMPTY=
+HASH=abc#def
+SINGLE='literal $HOME \n # not a comment'
+DOUBLE="line one\nline \"two\"\ttabbed # kept"
+MULTI="first
+second"
+PRICE="costs \$5"
+URL=${LLM_ENDPOINT}/chat
+HOME_DIR="$HOME/agent"
+MISSING=x${NOT_SET}y
+exporter=value
+`
+
+	vars, err := parseDotEnv(data, lookupFrom(map[string]string{"HOME": "/home/dev"}))
+	require.NoError(t, err)
+
+	assert.Equal(t, []dotEnvVar{
+		{"LLM_ENDPOINT", "https://openrouter.ai/api/v1"},
+		{"LLM_KEY", "sk-123"},
+		{"EMPTY", ""},
+		{"HASH", "abc#def"},
+		{"SINGLE", `literal $HOME \n # not a comment`},
+		{"DOUBLE", "line one\nline \"two\"\ttabbed # kept"},
+		{"MULTI", "first\nsecond"},
+		{"PRICE", "costs $5"},
+		{"URL", "https://openrouter.ai/api/v1/chat"},
+		{"HOME_DIR", "/home/dev/agent"},
+		{"MISSING", "xy"},
+		{"exporter", "value"},
+	}, vars)
+}
+
+func TestParseDotEnv_Errors(t *testing.T) {
+	tests := map[string]string{
+		"line 2: expected NAME=value":              "A=1\nnot an assignment\n",
+		"line 1: expected NAME=value":              "1ABC=x",
+		"line 1: unterminated single-quoted value": "A='open",
+		"line 2: unterminated double-quoted value": "A=1\nB=\"open\nstill open\n",
+		"line 4: expected NAME=value":              "A=\"one\ntwo\"\nB=2\nbroken",
+	}
+	for want, data := range tests {
+		_, err := parseDotEnv(data, lookupFrom(nil))
+
+		assert.EqualError(t, err, want, data)
+	}
+}
+
+func TestLoadDotEnv_NeverOverrides(t *testing.T) {
+	workspace := t.TempDir()
+	userDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(workspace, dotEnvFileName), []byte("DOTENV_TEST_SET=workspace\nDOTENV_TEST_WORKSPACE=workspace\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(userDir, dotEnvFileName), []byte("DOTENV_TEST_WORKSPACE=user\nDOTENV_TEST_USER=user\n"), 0644))
+	t.Setenv("DOTENV_TEST_SET", "shell")
+	for _, name := range []string{"DOTENV_TEST_WORKSPACE", "DOTENV_TEST_USER"} {
+		t.Setenv(name, "") // restored after the test
+		os.Unsetenv(name)
+	}
+
+	require.NoError(t, loadDotEnv(workspace, userDir))
+
+	assert.Equal(t, "shell", os.Getenv("DOTENV_TEST_SET"))
+	assert.Equal(t, "workspace", os.Getenv("DOTENV_TEST_WORKSPACE"))
+	assert.Equal(t, "user", os.Getenv("DOTENV_TEST_USER"))
+}
+
+func TestLoadDotEnv_MissingFiles(t *testing.T) {
+	assert.NoError(t, loadDotEnv(t.TempDir(), ""))
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// e2eMainEnv makes the test binary run main instead of the tests, so end-to-end tests exercise flag
+// parsing and environment handling in a real process
+const e2eMainEnv = "AGENT_E2E_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(e2eMainEnv) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// e2eRun is the outcome of running the agent
+type e2eRun struct {
+	stdout string
+	stderr string
+	err    error
+}
+
+// agentCommand prepares the agent to run in dir. Its environment holds only env, PATH and an empty
+// config directory, so the developer's own settings do not leak in.
+func agentCommand(t *testing.T, ctx context.Context, dir string, env []string, args ...string) *exec.Cmd {
+	config := t.TempDir()
+	cmd := exec.CommandContext(ctx, os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append([]string{e2eMainEnv + "=1", "PATH=" + os.Getenv("PATH"), "HOME=" + config, "XDG_CONFIG_HOME=" + config}, env...)
+	return cmd
+}
+
+// runAgent runs the agent in dir until it exits, typing stdin into the chat
+func runAgent(t *testing.T, dir string, env []string, stdin string, args ...string) e2eRun {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cmd := agentCommand(t, ctx, dir, env, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return e2eRun{stdout: stdout.String(), stderr: stderr.String(), err: err}
+}
+
+func TestFakeOpenAI_Replies(t *testing.T) {
+	fake := newFakeOpenAI(t,
+		fakeReply{Content: "Hello", Usage: openai.Usage{PromptTokens: 10, CompletionTokens: 2}},
+		fakeReply{Status: 429, Error: "slow down", RetryAfter: "7"},
+	)
+	config := openai.DefaultConfig("key")
+	config.BaseURL = fake.endpoint()
+	provider := NewOpenAIProvider(openai.NewClientWithConfig(config))
+
+	resp, err := provider.Chat(context.Background(), ChatRequest{Model: "m", Messages: []Message{{Role: RoleUser, Content: "hi"}}})
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", resp.Message.Content)
+	assert.Equal(t, Usage{PromptTokens: 10, CompletionTokens: 2}, resp.Usage)
+
+	_, err = provider.Chat(context.Background(), ChatRequest{Model: "m"})
+	var apiErr *openai.APIError
+	require.True(t, errors.As(err, &apiErr), "%v", err)
+	assert.Equal(t, 429, apiErr.HTTPStatusCode)
+	assert.Equal(t, "slow down", apiErr.Message)
+
+	_, err = provider.Chat(context.Background(), ChatRequest{Model: "m"})
+	assert.ErrorContains(t, err, "no scripted reply left")
+	assert.Len(t, fake.received(), 3)
+	assert.Equal(t, "Bearer key", fake.received()[0].Authorization)
+}
+
+func TestFakeOpenAI_Streaming(t *testing.T) {
+	fake := newFakeOpenAI(t, fakeReply{Content: "Let me look.", ToolCalls: []ToolCall{{ID: "call-1", Name: "list_dir", Arguments: `{"path":"."}`}}})
+	config := openai.DefaultConfig("key")
+	config.BaseURL = fake.endpoint()
+
+	stream, err := openai.NewClientWithConfig(config).CreateChatCompletionStream(context.Background(), openai.ChatCompletionRequest{Model: "m", Stream: true})
+	require.NoError(t, err)
+	defer stream.Close()
+	var content, arguments strings.Builder
+	var chunks int
+	var finish openai.FinishReason
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		chunks++
+		delta := chunk.Choices[0].Delta
+		content.WriteString(delta.Content)
+		for _, call := range delta.ToolCalls {
+			arguments.WriteString(call.Function.Arguments)
+		}
+		if chunk.Choices[0].FinishReason != "" {
+			finish = chunk.Choices[0].FinishReason
+		}
+	}
+
+	assert.Equal(t, "Let me look.", content.String())
+	assert.Equal(t, `{"path":"."}`, arguments.String())
+	assert.Equal(t, openai.FinishReasonToolCalls, finish)
+	assert.Greater(t, chunks, 3, "the reply arrives in pieces")
+}
+
+func TestE2E_ChatTurn(t *testing.T) {
+	fake := newFakeOpenAI(t,
+		fakeReply{ToolCalls: []ToolCall{{ID: "call-1", Name: "list_dir", Arguments: `{"path":"."}`}}},
+		fakeReply{Content: "There is notes.txt."},
+	)
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("hi"), 0644))
+
+	run := runAgent(t, dir, []string{"LLM_ENDPOINT=" + fake.endpoint(), "LLM_KEY=test-key"}, "What is here?\n", "--model", "e2e-model")
+
+	require.NoError(t, run.err, run.stderr)
+	assert.Contains(t, run.stdout, "Chat with e2e-model")
+	assert.Contains(t, run.stdout, "Tool call: list_dir")
+	assert.Contains(t, run.stdout, "Assistant: There is notes.txt.")
+
+	requests := fake.received()
+	require.Len(t, requests, 2)
+	assert.Equal(t, "Bearer test-key", requests[0].Authorization)
+	assert.Equal(t, "e2e-model", requests[0].Body.Model)
+	assert.NotEmpty(t, requests[0].Body.Tools)
+	sent := requests[1].Body.Messages
+	assert.Equal(t, openai.ChatMessageRoleTool, sent[len(sent)-1].Role)
+	assert.Equal(t, "notes.txt\n", sent[len(sent)-1].Content)
+}
+
+func TestE2E_SettingsPrecedence(t *testing.T) {
+	fake := newFakeOpenAI(t, fakeReply{Content: "ok"}, fakeReply{Content: "ok"}, fakeReply{Content: "ok"})
+	dir := t.TempDir()
+	dotEnv := fmt.Sprintf("LLM_ENDPOINT=%s\nLLM_MODEL=dotenv-model\n", fake.endpoint())
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".env"), []byte(dotEnv), 0644))
+
+	runs := []struct {
+		env   []string
+		args  []string
+		model string
+	}{
+		{model: "dotenv-model"},
+		{env: []string{"LLM_MODEL=env-model"}, model: "env-model"},
+		{env: []string{"LLM_MODEL=env-model"}, args: []string{"--model", "flag-model"}, model: "flag-model"},
+	}
+	for i, r := range runs {
+		run := runAgent(t, dir, r.env, "hi\n", r.args...)
+		require.NoError(t, run.err, run.stderr)
+		assert.Equal(t, r.model, fake.received()[i].Body.Model)
+	}
+
+	run := runAgent(t, dir, []string{"LLM_MODEL=env-model"}, "", "--tool-mode", "prompted", "config", "show")
+	require.NoError(t, run.err, run.stderr)
+	assert.Contains(t, run.stdout, `model = "env-model"  # environment variable LLM_MODEL`)
+	assert.Contains(t, run.stdout, `tool_mode = "prompted"  # flag --tool-mode`)
+}
+
+func TestE2E_Errors(t *testing.T) {
+	dir := t.TempDir()
+
+	run := runAgent(t, dir, nil, "")
+	require.Error(t, run.err)
+	assert.Contains(t, run.stderr, "an endpoint is required")
+
+	run = runAgent(t, dir, []string{"LLM_PROVIDER=anthropic", "ANTHROPIC_API_KEY=key"}, "", "--record-cassette", "session.json")
+	require.Error(t, run.err)
+	assert.Contains(t, run.stderr, "--record-cassette can only record OpenAI-compatible providers, not anthropic")
+
+	run = runAgent(t, dir, nil, "", "frobnicate")
+	require.Error(t, run.err)
+	assert.Contains(t, run.stderr, `unknown command "frobnicate"`)
+
+	fake := newFakeOpenAI(t, fakeReply{Status: 429, Error: "slow down", RetryAfter: "30"})
+	run = runAgent(t, dir, []string{"LLM_ENDPOINT=" + fake.endpoint()}, "hi\n")
+	require.NoError(t, run.err, run.stderr)
+	assert.Contains(t, run.stdout, "Error: error creating chat completion: error, status code: 429")
+	assert.Contains(t, run.stdout, "slow down")
+}
+
+func TestE2E_FallbackOnRateLimit(t *testing.T) {
+	fake := newFakeOpenAI(t, fakeReply{Status: 429, RetryAfter: "1"}, fakeReply{Content: "From the backup."})
+	dir := t.TempDir()
+	config := fmt.Sprintf(`providers:
+  fake:
+    type: openai
+    endpoint: %s
+    api_key: config-key
+models:
+  primary:
+    provider: fake
+    model: big-model
+    fallback: [backup]
+  backup:
+    provider: fake
+    model: small-model
+routing:
+  main: primary
+`, fake.endpoint())
+	require.NoError(t, os.MkdirAll(projectConfigDir(dir), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(projectConfigDir(dir), configFileName), []byte(config), 0644))
+
+	run := runAgent(t, dir, nil, "hi\n")
+
+	require.NoError(t, run.err, run.stderr)
+	assert.Contains(t, run.stderr, `level=WARN msg="model failed, falling back" model=primary fallback=backup`)
+	assert.NotContains(t, run.stdout, "falling back", "the fallback is a diagnostic, not part of the conversation")
+	assert.Contains(t, run.stdout, "Assistant: From the backup.")
+	requests := fake.received()
+	require.Len(t, requests, 2)
+	assert.Equal(t, "big-model", requests[0].Body.Model)
+	assert.Equal(t, "small-model", requests[1].Body.Model)
+	assert.Equal(t, "Bearer config-key", requests[1].Authorization)
+}
+
+func TestE2E_ExplicitModelOverridesRouting(t *testing.T) {
+	fake := newFakeOpenAI(t, fakeReply{Content: "routed"}, fakeReply{Content: "explicit"})
+	dir := t.TempDir()
+	config := fmt.Sprintf(`providers:
+  fake:
+    type: openai
+    endpoint: %s
+models:
+  primary:
+    provider: fake
+    model: big-model
+routing:
+  main: primary
+`, fake.endpoint())
+	require.NoError(t, os.MkdirAll(projectConfigDir(dir), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(projectConfigDir(dir), configFileName), []byte(config), 0644))
+	env := []string{"LLM_ENDPOINT=" + fake.endpoint()}
+
+	run := runAgent(t, dir, env, "hi\n")
+	require.NoError(t, run.err, run.stderr)
+	run = runAgent(t, dir, env, "hi\n", "--model", DEFAULT_MODEL)
+	require.NoError(t, run.err, run.stderr)
+
+	requests := fake.received()
+	require.Len(t, requests, 2)
+	assert.Equal(t, "big-model", requests[0].Body.Model)
+	assert.Equal(t, DEFAULT_MODEL, requests[1].Body.Model, "a model given explicitly is used even when it is the default")
+}
+
+func TestE2E_DebugLog(t *testing.T) {
+	fake := newFakeOpenAI(t,
+		fakeReply{ToolCalls: []ToolCall{{ID: "call-1", Name: "read_file", Arguments: `{"path":".env"}`}}},
+		fakeReply{Content: "Found it."},
+	)
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".env"), []byte("DEPLOY_TOKEN=deploy-token-value\n"), 0644))
+
+	run := runAgent(t, dir, []string{"LLM_ENDPOINT=" + fake.endpoint(), "LLM_KEY=llm-key-value"}, "What is in .env?\n", "--debug")
+
+	require.NoError(t, run.err, run.stderr)
+	logs, err := filepath.Glob(filepath.Join(projectConfigDir(dir), "logs", "agent-*.log"))
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	assert.Contains(t, run.stderr, `level=INFO msg="writing the debug log" file=`+logs[0])
+	assert.Equal(t, 1, strings.Count(run.stderr, "\n"), "everything else goes to the file: %s", run.stderr)
+	assert.NotContains(t, run.stdout, "debug log")
+	ignore, err := os.ReadFile(filepath.Join(projectConfigDir(dir), "logs", ".gitignore"))
+	require.NoError(t, err)
+	assert.Equal(t, "*\n", string(ignore), "git ignores the debug logs")
+	data, err := os.ReadFile(logs[0])
+	require.NoError(t, err)
+	log := string(data)
+	assert.Contains(t, log, `"msg":"llm request"`)
+	assert.Contains(t, log, `"msg":"llm response"`)
+	assert.Contains(t, log, `"msg":"tool call","session":`)
+	assert.Contains(t, log, `"Authorization":"[REDACTED]"`)
+	assert.NotContains(t, log, "llm-key-value")
+	assert.NotContains(t, log, "deploy-token-value", "secrets read from .env are redacted too")
+
+	run = runAgent(t, dir, []string{"LLM_ENDPOINT=" + fake.endpoint()}, "", "--log-level", "verbose")
+	require.Error(t, run.err)
+	assert.Contains(t, run.stderr, `log level "verbose": expected debug, info, warn or error`)
+}
+
+func TestE2E_StdioRPCCancelsSlowResponse(t *testing.T) {
+	fake := newFakeOpenAI(t, fakeReply{Content: "too late", Delay: time.Minute})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cmd := agentCommand(t, ctx, t.TempDir(), []string{"LLM_ENDPOINT=" + fake.endpoint()}, "--stdio-rpc")
+	stdin, err := cmd.StdinPipe()
+	require.NoError(t, err)
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	require.NoError(t, cmd.Start())
+
+	responses := bufio.NewScanner(stdout)
+	// response reads up to the response to request id, skipping event notifications
+	response := func(id int) rpcResponse {
+		t.Helper()
+		for responses.Scan() {
+			var resp rpcResponse
+			require.NoError(t, json.Unmarshal(responses.Bytes(), &resp), responses.Text())
+			if string(resp.ID) == fmt.Sprint(id) {
+				return resp
+			}
+		}
+		t.Fatalf("no response to request %d; stderr:\n%s", id, stderr.String())
+		return rpcResponse{}
+	}
+
+	fmt.Fprintln(stdin, `{"jsonrpc": "2.0", "id": 1, "method": "initialize"}`)
+	require.Nil(t, response(1).Error)
+	fmt.Fprintln(stdin, `{"jsonrpc": "2.0", "id": 2, "method": "prompt", "params": {"text": "hi"}}`)
+	require.Eventually(t, func() bool { return len(fake.received()) == 1 }, 10*time.Second, 10*time.Millisecond)
+	fmt.Fprintln(stdin, `{"jsonrpc": "2.0", "id": 3, "method": "cancel"}`)
+
+	resp := response(2)
+	require.NotNil(t, resp.Error)
+	assert.Equal(t, rpcCancelled, resp.Error.Code)
+	require.NoError(t, stdin.Close())
+	require.NoError(t, cmd.Wait())
+	assert.NotContains(t, stderr.String(), "too late")
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// defaultEvalTimeout bounds a task's turn when neither the task nor --timeout sets a limit
+	defaultEvalTimeout = 10 * time.Minute
+	// evalVerifyTimeout bounds a task's verification command
+	evalVerifyTimeout = 5 * time.Minute
+	// evalOutputTail is how much of a failed verification command's output the report keeps
+	evalOutputTail = 2 << 10
+)
+
+// evalTask is a coding task of an evaluation suite, read from a YAML file:
+//
+//	prompt: Add a Sum function to math.go
+//	fixture: fixtures/math   # copied into the task's workspace
+//	verify: go test ./...    # must exit 0 in the workspace
+//	files:
+//	  math.go: {contains: ["func Sum("]}
+type evalTask struct {
+	Name    string                     `yaml:"name"` // defaults to the file name
+	Prompt  string                     `yaml:"prompt"`
+	Fixture string                     `yaml:"fixture"` // relative to the task file; empty starts from an empty workspace
+	Verify  string                     `yaml:"verify"`  // shell command run in the workspace after the turn
+	Files   map[string]fileExpectation `yaml:"files"`   // expected state of workspace files
+	Timeout time.Duration              `yaml:"timeout"`
+
+	path string // the task file
+}
+
+// fileExpectation is the expected state of a file after a task
+type fileExpectation struct {
+	Equals   *string  `yaml:"equals"`
+	Contains []string `yaml:"contains"`
+	Absent   bool     `yaml:"absent"`
+}
+
+// evalResult is the outcome of one task with one model
+type evalResult struct {
+	Task             string   `json:"task"`
+	Model            string   `json:"model"`
+	Passed           bool     `json:"passed"`
+	Failures         []string `json:"failures,omitempty"` // why the task failed
+	Iterations       int      `json:"iterations"`
+	ToolCalls        int      `json:"tool_calls"`
+	PromptTokens     int      `json:"prompt_tokens"`
+	CompletionTokens int      `json:"completion_tokens"`
+	WallTime         float64  `json:"wall_time_seconds"`
+	Workspace        string   `json:"workspace,omitempty"` // kept with --keep
+}
+
+// evalReport is the outcome of a suite, comparable across runs and models
+type evalReport struct {
+	Suite     string       `json:"suite"`
+	StartedAt time.Time    `json:"started_at"`
+	Models    []string     `json:"models"`
+	Results   []evalResult `json:"results"`
+}
+
+// loadEvalSuite reads the tasks of a suite: the YAML files in dir, in name order
+func loadEvalSuite(dir string) ([]evalTask, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var tasks []evalTask
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		task, err := loadEvalTask(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		tasks = append(tasks, task)
+	}
+	if len(tasks) == 0 {
+		return nil, fmt.Errorf("no task files in %s", dir)
+	}
+	return tasks, nil
+}
+
+func loadEvalTask(path string) (evalTask, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return evalTask{}, err
+	}
+	var task evalTask
+	if err := yaml.Unmarshal(data, &task); err != nil {
+		return evalTask{}, err
+	}
+	task.path = path
+	if task.Name == "" {
+		task.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if strings.ContainsAny(task.Name, `/\`) {
+		return evalTask{}, fmt.Errorf("name %q must not contain a path separator", task.Name)
+	}
+	if strings.TrimSpace(task.Prompt) == "" {
+		return evalTask{}, fmt.Errorf("a prompt is required")
+	}
+	if task.Verify == "" && len(task.Files) == 0 {
+		return evalTask{}, fmt.Errorf("a verify command or expected files are required")
+	}
+	if task.Fixture != "" {
+		info, err := os.Stat(task.fixtureDir())
+		if err != nil {
+			return evalTask{}, fmt.Errorf("fixture: %w", err)
+		}
+		if !info.IsDir() {
+			return evalTask{}, fmt.Errorf("fixture %s is not a directory", task.Fixture)
+		}
+	}
+	return task, nil
+}
+
+func (t evalTask) fixtureDir() string {
+	return filepath.Join(filepath.Dir(t.path), t.Fixture)
+}
+
+// runEvalTask runs a task with a model in a fresh copy of its fixture and checks the result
+func (a *Agent) runEvalTask(ctx context.Context, task evalTask, model string, timeout time.Duration, keep bool) evalResult {
+	result := evalResult{Task: task.Name, Model: model}
+	fail := func(format string, args ...any) evalResult {
+		result.Failures = append(result.Failures, fmt.Sprintf(format, args...))
+		return result
+	}
+
+	workDir, err := os.MkdirTemp("", "agent-eval-"+task.Name+"-")
+	if err != nil {
+		return fail("creating workspace: %v", err)
+	}
+	if keep {
+		result.Workspace = workDir
+	} else {
+		defer os.RemoveAll(workDir)
+	}
+	if task.Fixture != "" {
+		if err := copyTree(task.fixtureDir(), workDir); err != nil {
+			return fail("copying fixture: %v", err)
+		}
+	}
+
+	agent, err := a.sessionAgent(workDir)
+	if err == nil && model != "" {
+		err = agent.useModel(model)
+	}
+	if err != nil {
+		return fail("%v", err)
+	}
+	result.Model = agent.model
+
+	if task.Timeout > 0 {
+		timeout = task.Timeout
+	}
+	turnCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	agent.budget.reset()
+	messages, err := agent.DriveConversation(turnCtx, []Message{{Role: RoleUser, Content: task.Prompt}}, nil, nil)
+	result.WallTime = time.Since(start).Seconds()
+
+	usage := agent.totalUsage()
+	result.Iterations = usage.Iterations
+	result.PromptTokens = usage.PromptTokens
+	result.CompletionTokens = usage.CompletionTokens
+	for _, msg := range messages {
+		result.ToolCalls += len(msg.ToolCalls)
+	}
+	if err != nil {
+		result.Failures = append(result.Failures, fmt.Sprintf("turn stopped: %v", err))
+	}
+
+	result.Failures = append(result.Failures, checkFiles(workDir, task.Files)...)
+	if task.Verify != "" {
+		if failure := runVerify(ctx, workDir, task.Verify); failure != "" {
+			result.Failures = append(result.Failures, failure)
+		}
+	}
+	result.Passed = len(result.Failures) == 0
+	return result
+}
+
+// checkFiles compares the workspace with the expected file states
+func checkFiles(workDir string, files map[string]fileExpectation) []string {
+	var failures []string
+	for _, path := range sortedKeys(files) {
+		want := files[path]
+		data, err := os.ReadFile(filepath.Join(workDir, path))
+		switch {
+		case want.Absent:
+			if err == nil {
+				failures = append(failures, fmt.Sprintf("%s: expected no file", path))
+			}
+			continue
+		case err != nil:
+			failures = append(failures, fmt.Sprintf("%s: %v", path, err))
+			continue
+		}
+		content := string(data)
+		if want.Equals != nil && content != *want.Equals {
+			failures = append(failures, fmt.Sprintf("%s: content differs from the expected content:\n%s", path, strings.TrimRight(unifiedDiff(path, *want.Equals, content, true), "\n")))
+		}
+		for _, s := range want.Contains {
+			if !strings.Contains(content, s) {
+				failures = append(failures, fmt.Sprintf("%s: expected to contain %q", path, s))
+			}
+		}
+	}
+	return failures
+}
+
+// runVerify runs a task's verification command, returning why it failed or "" when it passed
+func runVerify(ctx context.Context, workDir, command string) string {
+	ctx, cancel := context.WithTimeout(ctx, evalVerifyTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = workDir
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		return ""
+	}
+	if len(out) > evalOutputTail {
+		out = append([]byte("..."), out[len(out)-evalOutputTail:]...)
+	}
+	return fmt.Sprintf("verify command failed: %v\n%s", err, strings.TrimRight(string(out), "\n"))
+}
+
+// copyTree copies the files, directories and symlinks under src into dst
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			return fmt.Errorf("%s: unsupported file type", path)
+		}
+	})
+}
+
+// markdown renders the report as tables of the results and of each model's totals
+func (r evalReport) markdown() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "# Evaluation of %s\n\nStarted %s.\n\n", r.Suite, r.StartedAt.Format(time.RFC3339))
+
+	out.WriteString("| Model | Passed | Iterations | Tool calls | Tokens | Time |\n")
+	out.WriteString("|-------|-------:|-----------:|-----------:|-------:|-----:|\n")
+	for _, model := range r.Models {
+		var passed, tasks, iterations, toolCalls, tokens int
+		var wall float64
+		for _, result := range r.Results {
+			if result.Model != model {
+				continue
+			}
+			tasks++
+			if result.Passed {
+				passed++
+			}
+			iterations += result.Iterations
+			toolCalls += result.ToolCalls
+			tokens += result.PromptTokens + result.CompletionTokens
+			wall += result.WallTime
+		}
+		fmt.Fprintf(&out, "| %s | %d/%d | %d | %d | %d | %.1fs |\n", model, passed, tasks, iterations, toolCalls, tokens, wall)
+	}
+
+	out.WriteString("\n| Task | Model | Result | Iterations | Tool calls | Tokens | Time |\n")
+	out.WriteString("|------|-------|--------|-----------:|-----------:|-------:|-----:|\n")
+	for _, result := range r.Results {
+		status := "pass"
+		if !result.Passed {
+			status = "FAIL"
+		}
+		fmt.Fprintf(&out, "| %s | %s | %s | %d | %d | %d | %.1fs |\n", result.Task, result.Model, status, result.Iterations, result.ToolCalls, result.PromptTokens+result.CompletionTokens, result.WallTime)
+	}
+
+	var failed bool
+	for _, result := range r.Results {
+		if result.Passed {
+			continue
+		}
+		if !failed {
+			out.WriteString("\n## Failures\n")
+			failed = true
+		}
+		fmt.Fprintf(&out, "\n### %s with %s\n\n", result.Task, result.Model)
+		for _, failure := range result.Failures {
+			fmt.Fprintf(&out, "```\n%s\n```\n", failure)
+		}
+	}
+	return out.String()
+}
+
+// writeEvalReport saves the report as JSON and markdown in dir, named by its start time, and
+// returns the path of the markdown file
+func writeEvalReport(dir string, report evalReport) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	base := filepath.Join(dir, "eval-"+report.StartedAt.Format("20060102-150405"))
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(base+".json", append(data, '\n'), 0644); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(base+".md", []byte(report.markdown()), 0644); err != nil {
+		return "", err
+	}
+	return base + ".md", nil
+}
+
+// runEval runs an evaluation suite: agent eval [--suite DIR] [--models A,B] [--out DIR] [--timeout D] [--keep]
+func runEval(template *Agent, cwd string, args []string) error {
+	flags := flag.NewFlagSet("eval", flag.ContinueOnError)
+	suite := flags.String("suite", "evals", "Directory of task files")
+	models := flags.String("models", "", "Comma-separated models to compare (default: the configured model)")
+	out := flags.String("out", filepath.Join(projectConfigDir(cwd), "evals"), "Directory for the reports")
+	timeout := flags.Duration("timeout", defaultEvalTimeout, "Time limit of a task's turn, unless the task sets one")
+	keep := flags.Bool("keep", false, "Keep the task workspaces for inspection")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %q", strings.Join(flags.Args(), " "))
+	}
+
+	tasks, err := loadEvalSuite(*suite)
+	if err != nil {
+		return err
+	}
+	var modelNames []string
+	for _, name := range strings.Split(*models, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			modelNames = append(modelNames, name)
+		}
+	}
+	if len(modelNames) == 0 {
+		modelNames = []string{template.model}
+	}
+
+	report := evalReport{Suite: *suite, StartedAt: time.Now(), Models: modelNames}
+	total := len(tasks) * len(modelNames)
+	for _, model := range modelNames {
+		for _, task := range tasks {
+			result := template.runEvalTask(context.Background(), task, model, *timeout, *keep)
+			report.Results = append(report.Results, result)
+			status := "passed"
+			if !result.Passed {
+				status = "failed"
+			}
+			fmt.Printf("[%d/%d] %s with %s: %s (%s, %s, %d tokens, %.1fs)\n", len(report.Results), total, task.Name, model, status,
+				pluralize(result.Iterations, "iteration", "iterations"), pluralize(result.ToolCalls, "tool call", "tool calls"),
+				result.PromptTokens+result.CompletionTokens, result.WallTime)
+		}
+	}
+	sort.SliceStable(report.Results, func(i, j int) bool { return report.Results[i].Task < report.Results[j].Task })
+
+	path, err := writeEvalReport(*out, report)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Report written to %s\n", path)
+	return nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeEvalSuite creates a suite with a greeting task whose fixture holds a README
+func writeEvalSuite(t *testing.T) string {
+	suite := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(suite, "fixtures", "greet"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(suite, "fixtures", "greet", "README"), []byte("say hi\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(suite, "greet.yaml"), []byte(`prompt: Write hello to a.txt
+fixture: fixtures/greet
+verify: test -f README && grep -q hello a.txt
+files:
+  a.txt: {equals: "hello\n"}
+  b.txt: {absent: true}
+timeout: 1m
+`), 0644))
+	return suite
+}
+
+func TestLoadEvalSuite(t *testing.T) {
+	suite := writeEvalSuite(t)
+	require.NoError(t, os.WriteFile(filepath.Join(suite, "notes.md"), []byte("not a task"), 0644))
+
+	tasks, err := loadEvalSuite(suite)
+
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+	assert.Equal(t, "greet", tasks[0].Name)
+	assert.Equal(t, time.Minute, tasks[0].Timeout)
+	assert.Equal(t, filepath.Join(suite, "fixtures", "greet"), tasks[0].fixtureDir())
+	assert.True(t, tasks[0].Files["b.txt"].Absent)
+
+	invalid := map[string]string{
+		"prompt: hi\n":                         "a verify command or expected files are required",
+		"verify: true\n":                       "a prompt is required",
+		"prompt: hi\nverify: true\nfixture: x": "fixture:",
+		"name: a/b\nprompt: hi\nverify: true":  `name "a/b" must not contain a path separator`,
+	}
+	for content, message := range invalid {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "task.yml"), []byte(content), 0644))
+		_, err := loadEvalSuite(dir)
+		assert.ErrorContains(t, err, message)
+	}
+	_, err = loadEvalSuite(t.TempDir())
+	assert.ErrorContains(t, err, "no task files")
+}
+
+func TestRunEvalTask(t *testing.T) {
+	tasks, err := loadEvalSuite(writeEvalSuite(t))
+	require.NoError(t, err)
+	withUsage := func(reply func(ChatRequest) (ChatResponse, error)) func(ChatRequest) (ChatResponse, error) {
+		return func(request ChatRequest) (ChatResponse, error) {
+			resp, err := reply(request)
+			resp.Usage = Usage{PromptTokens: 100, CompletionTokens: 10}
+			return resp, err
+		}
+	}
+	provider := &scriptedProvider{replies: []func(ChatRequest) (ChatResponse, error){
+		withUsage(replyWithToolCall("write_to_file", `{"path":"a.txt","content":"hello\n"}`)),
+		withUsage(replyWith("Done.")),
+		replyWith("I would rather not."),
+	}}
+	template := NewAgent(provider, nil, "test-model")
+
+	passed := template.runEvalTask(context.Background(), tasks[0], "model-a", time.Minute, false)
+	failed := template.runEvalTask(context.Background(), tasks[0], "model-b", time.Minute, true)
+
+	assert.True(t, passed.Passed, "%v", passed.Failures)
+	assert.Equal(t, "greet", passed.Task)
+	assert.Equal(t, "model-a", passed.Model)
+	assert.Equal(t, 2, passed.Iterations)
+	assert.Equal(t, 1, passed.ToolCalls)
+	assert.Equal(t, 200, passed.PromptTokens)
+	assert.Equal(t, 20, passed.CompletionTokens)
+	assert.Empty(t, passed.Workspace)
+	assert.Equal(t, "model-a", provider.requests[0].Model)
+	assert.Equal(t, "Write hello to a.txt", provider.requests[0].Messages[len(provider.requests[0].Messages)-1].Content)
+
+	assert.False(t, failed.Passed)
+	require.Len(t, failed.Failures, 2)
+	assert.Contains(t, failed.Failures[0], "a.txt: open ")
+	assert.Contains(t, failed.Failures[1], "verify command failed: exit status 2\ngrep: a.txt")
+	require.NotEmpty(t, failed.Workspace)
+	defer os.RemoveAll(failed.Workspace)
+	assert.FileExists(t, filepath.Join(failed.Workspace, "README"), "the fixture is copied")
+}
+
+func TestCheckFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.go"), []byte("package a\n"), 0644))
+	want := "package b\n"
+
+	failures := checkFiles(dir, map[string]fileExpectation{
+		"a.go":   {Equals: &want, Contains: []string{"package", "func"}},
+		"gone":   {Absent: true},
+		"extra":  {Contains: []string{"x"}},
+		"README": {},
+	})
+
+	require.Len(t, failures, 4)
+	assert.Contains(t, failures[0], "README: open ")
+	assert.Equal(t, "a.go: content differs from the expected content:\n--- a/a.go\n+++ b/a.go\n@@ -1,1 +1,1 @@\n-package b\n+package a", failures[1])
+	assert.Equal(t, `a.go: expected to contain "func"`, failures[2])
+	assert.Contains(t, failures[3], "extra: open ")
+}
+
+func TestWriteEvalReport(t *testing.T) {
+	report := evalReport{
+		Suite:     "evals",
+		StartedAt: time.Date(2026, 10, 18, 15, 4, 5, 0, time.UTC),
+		Models:    []string{"model-a", "model-b"},
+		Results: []evalResult{
+			{Task: "greet", Model: "model-a", Passed: true, Iterations: 2, ToolCalls: 1, PromptTokens: 200, CompletionTokens: 20, WallTime: 1.25},
+			{Task: "greet", Model: "model-b", Failures: []string{"verify command failed: exit status 1"}, Iterations: 1, WallTime: 0.5},
+		},
+	}
+	dir := filepath.Join(t.TempDir(), "reports")
+
+	path, err := writeEvalReport(dir, report)
+
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "eval-20261018-150405.md"), path)
+	markdown, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(markdown), "| model-a | 1/1 | 2 | 1 | 220 | 1.2s |\n| model-b | 0/1 | 1 | 0 | 0 | 0.5s |\n")
+	assert.Contains(t, string(markdown), "| greet | model-b | FAIL | 1 | 0 | 0 | 0.5s |\n")
+	assert.Contains(t, string(markdown), "### greet with model-b\n\n```\nverify command failed: exit status 1\n```\n")
+
+	data, err := os.ReadFile(filepath.Join(dir, "eval-20261018-150405.json"))
+	require.NoError(t, err)
+	var saved evalReport
+	require.NoError(t, json.Unmarshal(data, &saved))
+	assert.Equal(t, report, saved)
+}
+
+func TestRunEval(t *testing.T) {
+	suite := writeEvalSuite(t)
+	out := t.TempDir()
+	provider := &scriptedProvider{replies: []func(ChatRequest) (ChatResponse, error){
+		replyWithToolCall("write_to_file", `{"path":"a.txt","content":"hello\n"}`),
+		replyWith("Done."),
+	}}
+
+	err := runEval(NewAgent(provider, nil, "test-model"), t.TempDir(), []string{"--suite", suite, "--out", out})
+
+	require.NoError(t, err)
+	reports, err := filepath.Glob(filepath.Join(out, "eval-*.json"))
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	data, err := os.ReadFile(reports[0])
+	require.NoError(t, err)
+	var report evalReport
+	require.NoError(t, json.Unmarshal(data, &report))
+	assert.Equal(t, []string{"test-model"}, report.Models)
+	require.Len(t, report.Results, 1)
+	assert.True(t, report.Results[0].Passed, "%v", report.Results[0].Failures)
+
+	assert.ErrorContains(t, runEval(NewAgent(provider, nil, "test-model"), t.TempDir(), []string{"--suite", suite, "extra"}), `unexpected arguments "extra"`)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Types of the events an agent reports while a turn runs
+const (
+	eventUserMessage     = "user_message"
+	eventAssistantDelta  = "assistant_delta"
+	eventToolCall        = "tool_call"
+	eventToolResult      = "tool_result"
+	eventApprovalRequest = "approval_request"
+	eventTurnEnd         = "turn_end"
+	eventError           = "error"
+)
+
+// agentEvent is something that happened during a turn, for clients driving the agent remotely.
+// Providers return whole messages, so each assistant message arrives as a single delta.
+type agentEvent struct {
+	ID         int       `json:"id"`
+	Type       string    `json:"type"`
+	Content    string    `json:"content,omitempty"`
+	ToolCall   *ToolCall `json:"tool_call,omitempty"`
+	ToolCallID string    `json:"tool_call_id,omitempty"`
+	IsError    bool      `json:"is_error,omitempty"`
+	ApprovalID string    `json:"approval_id,omitempty"`
+	Diff       string    `json:"diff,omitempty"` // unified diff of the file a write_to_file call replaces
+}
+
+// emit reports an event to the agent's listener, if any
+func (a *Agent) emit(event agentEvent) {
+	if a.events != nil {
+		a.events(event)
+	}
+}
+
+// toolApprover decides whether a tool call may run. diff previews the change of a write_to_file call.
+type toolApprover func(ctx context.Context, toolCall ToolCall, diff string) (bool, error)
+
+// approvalTools are the tools that change the workspace and so need approval when an approver is set
+var approvalTools = map[string]bool{
+	"write_to_file": true,
+	"move_file":     true,
+	"copy_file":     true,
+	"delete_file":   true,
+	"make_dir":      true,
+}
+
+// approveToolCall asks the agent's approver whether a tool call that changes the workspace may run
+func (a *Agent) approveToolCall(ctx context.Context, toolCall ToolCall) error {
+	if a.approve == nil || !approvalTools[toolCall.Name] {
+		return nil
+	}
+	approved, err := a.approve(ctx, toolCall, a.toolCallDiff(toolCall))
+	if err != nil {
+		return fmt.Errorf("Tool call not approved: %v", err)
+	}
+	if !approved {
+		return fmt.Errorf("The user denied the %s call", toolCall.Name)
+	}
+	return nil
+}
+
+// approvalQueue holds the approval requests waiting for a remote client's answer
+type approvalQueue struct {
+	mu      sync.Mutex
+	next    int
+	pending map[string]chan bool
+}
+
+// wait registers an approval request, announces it by ID and waits for the client's answer
+func (q *approvalQueue) wait(ctx context.Context, announce func(id string)) (bool, error) {
+	q.mu.Lock()
+	if q.pending == nil {
+		q.pending = make(map[string]chan bool)
+	}
+	q.next++
+	id := strconv.Itoa(q.next)
+	answer := make(chan bool, 1)
+	q.pending[id] = answer
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		delete(q.pending, id)
+		q.mu.Unlock()
+	}()
+
+	announce(id)
+	select {
+	case approved := <-answer:
+		return approved, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// answer delivers an answer to a pending request, reporting false when there is no such request
+func (q *approvalQueue) answer(id string, approved bool) bool {
+	q.mu.Lock()
+	answer, ok := q.pending[id]
+	delete(q.pending, id)
+	q.mu.Unlock()
+	if ok {
+		answer <- approved
+	}
+	return ok
+}
+
+// toolCallDiff returns the change a write_to_file call would make to its file, or "" for other
+// tools and calls that cannot run
+func (a *Agent) toolCallDiff(toolCall ToolCall) string {
+	if toolCall.Name != "write_to_file" {
+		return ""
+	}
+	var input WriteFileInput
+	if err := json.Unmarshal([]byte(toolCall.Arguments), &input); err != nil || input.Path == "" {
+		return ""
+	}
+	path, err := a.workspacePath(input.Path)
+	if err != nil {
+		return ""
+	}
+	before, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return ""
+	}
+	return unifiedDiff(input.Path, string(before), input.Content, err == nil)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// fakeReply scripts one answer of a fakeOpenAI server: a message, or an error when Status is set
+type fakeReply struct {
+	Content    string
+	ToolCalls  []ToolCall
+	Status     int    // HTTP status of an error reply
+	Error      string // error message; defaults to the status text
+	RetryAfter string // Retry-After header of an error reply
+	Delay      time.Duration
+	Usage      openai.Usage
+}
+
+// fakeOpenAI is a local OpenAI-compatible chat completions server scripted with replies, for tests
+// that go through the real HTTP client. Streaming requests are answered with server-sent events.
+type fakeOpenAI struct {
+	*httptest.Server
+	t        *testing.T
+	mu       sync.Mutex
+	replies  []fakeReply
+	requests []fakeRequest
+}
+
+// fakeRequest is a request the server received
+type fakeRequest struct {
+	Authorization string
+	Body          openai.ChatCompletionRequest
+}
+
+func newFakeOpenAI(t *testing.T, replies ...fakeReply) *fakeOpenAI {
+	f := &fakeOpenAI{t: t, replies: replies}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// endpoint is the base URL clients are configured with
+func (f *fakeOpenAI) endpoint() string {
+	return f.URL + "/v1"
+}
+
+// reply queues more replies
+func (f *fakeOpenAI) reply(replies ...fakeReply) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.replies = append(f.replies, replies...)
+}
+
+// received returns the requests received so far
+func (f *fakeOpenAI) received() []fakeRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakeRequest{}, f.requests...)
+}
+
+func (f *fakeOpenAI) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/v1/chat/completions" {
+		writeFakeError(w, http.StatusNotFound, "no route for "+r.Method+" "+r.URL.Path)
+		return
+	}
+	var body openai.ChatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeFakeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	f.mu.Lock()
+	f.requests = append(f.requests, fakeRequest{Authorization: r.Header.Get("Authorization"), Body: body})
+	if len(f.replies) == 0 {
+		f.mu.Unlock()
+		writeFakeError(w, http.StatusInternalServerError, "the fake server has no scripted reply left")
+		return
+	}
+	reply := f.replies[0]
+	f.replies = f.replies[1:]
+	f.mu.Unlock()
+
+	select {
+	case <-time.After(reply.Delay):
+	case <-r.Context().Done():
+		return
+	}
+
+	if reply.Status != 0 {
+		if reply.RetryAfter != "" {
+			w.Header().Set("Retry-After", reply.RetryAfter)
+		}
+		message := reply.Error
+		if message == "" {
+			message = http.StatusText(reply.Status)
+		}
+		writeFakeError(w, reply.Status, message)
+		return
+	}
+
+	var toolCalls []openai.ToolCall
+	for _, call := range reply.ToolCalls {
+		toolCalls = append(toolCalls, openai.ToolCall{ID: call.ID, Type: openai.ToolTypeFunction, Function: openai.FunctionCall{Name: call.Name, Arguments: call.Arguments}})
+	}
+	finishReason := openai.FinishReasonStop
+	if len(toolCalls) > 0 {
+		finishReason = openai.FinishReasonToolCalls
+	}
+	if body.Stream {
+		writeFakeStream(w, body.Model, reply.Content, toolCalls, finishReason)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+		ID:     "chatcmpl-fake",
+		Object: "chat.completion",
+		Model:  body.Model,
+		Choices: []openai.ChatCompletionChoice{{
+			Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: reply.Content, ToolCalls: toolCalls},
+			FinishReason: finishReason,
+		}},
+		Usage: reply.Usage,
+	})
+}
+
+// writeFakeStream sends a reply as chat completion chunks: the content a few characters at a time,
+// then each tool call with its arguments split in two
+func writeFakeStream(w http.ResponseWriter, model, content string, toolCalls []openai.ToolCall, finishReason openai.FinishReason) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	send := func(delta openai.ChatCompletionStreamChoiceDelta, finish openai.FinishReason) {
+		data, _ := json.Marshal(openai.ChatCompletionStreamResponse{
+			ID:      "chatcmpl-fake",
+			Object:  "chat.completion.chunk",
+			Model:   model,
+			Choices: []openai.ChatCompletionStreamChoice{{Delta: delta, FinishReason: finish}},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		w.(http.Flusher).Flush()
+	}
+
+	send(openai.ChatCompletionStreamChoiceDelta{Role: openai.ChatMessageRoleAssistant}, "")
+	for len(content) > 0 {
+		n := min(4, len(content))
+		send(openai.ChatCompletionStreamChoiceDelta{Content: content[:n]}, "")
+		content = content[n:]
+	}
+	for i, call := range toolCalls {
+		index := i
+		half := len(call.Function.Arguments) / 2
+		send(openai.ChatCompletionStreamChoiceDelta{ToolCalls: []openai.ToolCall{{Index: &index, ID: call.ID, Type: call.Type, Function: openai.FunctionCall{Name: call.Function.Name, Arguments: call.Function.Arguments[:half]}}}}, "")
+		send(openai.ChatCompletionStreamChoiceDelta{ToolCalls: []openai.ToolCall{{Index: &index, Function: openai.FunctionCall{Arguments: call.Function.Arguments[half:]}}}}, "")
+	}
+	send(openai.ChatCompletionStreamChoiceDelta{}, finishReason)
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+// writeFakeError sends an error in the shape of the OpenAI API
+func writeFakeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{
+		"message": message,
+		"type":    strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_"),
+		"code":    status,
+	}})
+}
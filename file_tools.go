@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// File management tool input structures
+type MoveFileInput struct {
+	Source      string `json:"source" jsonschema_description:"The relative path of the file or directory to move."`
+	Destination string `json:"destination" jsonschema_description:"The relative path to move it to."`
+}
+
+type CopyFileInput struct {
+	Source      string `json:"source" jsonschema_description:"The relative path of the file to copy."`
+	Destination string `json:"destination" jsonschema_description:"The relative path of the copy."`
+}
+
+type DeleteFileInput struct {
+	Path      string `json:"path" jsonschema_description:"The relative path of the file or directory to delete."`
+	Recursive bool   `json:"recursive" jsonschema_description:"Whether to delete a directory and everything in it"`
+}
+
+type MakeDirInput struct {
+	Path string `json:"path" jsonschema_description:"The relative path of the directory to create."`
+}
+
+func (a *Agent) createMoveFileTool() ToolDefinition {
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"source": map[string]any{
+				"type":        "string",
+				"description": "The relative path of the file or directory to move.",
+			},
+			"destination": map[string]any{
+				"type":        "string",
+				"description": "The relative path to move it to.",
+			},
+		},
+		"required": []string{"source", "destination"},
+	}
+
+	return ToolDefinition{
+		Name:        "move_file",
+		Description: "Move or rename a file or directory. Fails if the destination already exists.",
+		Parameters:  schema,
+	}
+}
+
+func (a *Agent) createCopyFileTool() ToolDefinition {
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"source": map[string]any{
+				"type":        "string",
+				"description": "The relative path of the file to copy.",
+			},
+			"destination": map[string]any{
+				"type":        "string",
+				"description": "The relative path of the copy.",
+			},
+		},
+		"required": []string{"source", "destination"},
+	}
+
+	return ToolDefinition{
+		Name:        "copy_file",
+		Description: "Copy a file to a new path. Fails if the destination already exists. Directories cannot be copied.",
+		Parameters:  schema,
+	}
+}
+
+func (a *Agent) createDeleteFileTool() ToolDefinition {
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"path": map[string]any{
+				"type":        "string",
+				"description": "The relative path of the file or directory to delete.",
+			},
+			"recursive": map[string]any{
+				"type":        "boolean",
+				"description": "Whether to delete a directory and everything in it",
+			},
+		},
+		"required": []string{"path"},
+	}
+
+	return ToolDefinition{
+		Name:        "delete_file",
+		Description: "Delete a file. Directories are only deleted when recursive is set.",
+		Parameters:  schema,
+	}
+}
+
+func (a *Agent) createMakeDirTool() ToolDefinition {
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"path": map[string]any{
+				"type":        "string",
+				"description": "The relative path of the directory to create.",
+			},
+		},
+		"required": []string{"path"},
+	}
+
+	return ToolDefinition{
+		Name:        "make_dir",
+		Description: "Create a directory, including any missing parent directories.",
+		Parameters:  schema,
+	}
+}
+
+func (a *Agent) handleMoveFile(ctx context.Context, toolCall ToolCall) Message {
+	var input MoveFileInput
+	if err := json.Unmarshal([]byte(toolCall.Arguments), &input); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Invalid arguments: %v", err))
+	}
+
+	source, err := a.workspacePath(input.Source)
+	if err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error moving file: %v", err))
+	}
+	destination, err := a.workspacePath(input.Destination)
+	if err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error moving file: %v", err))
+	}
+	if _, err := os.Stat(source); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error moving file: %v", err))
+	}
+	if err := a.claimPaths(input.Source, input.Destination); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error moving file: %v", err))
+	}
+	if _, err := os.Lstat(destination); err == nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error moving file: destination %s already exists", input.Destination))
+	}
+	if err := ensureParentDir(destination); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error creating directory: %v", err))
+	}
+
+	if err := os.Rename(source, destination); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error moving file: %v", err))
+	}
+	a.recordChange(input.Source, input.Destination)
+
+	return Message{
+		Role:       RoleTool,
+		Content:    "File moved successfully.",
+		ToolCallID: toolCall.ID,
+	}
+}
+
+func (a *Agent) handleCopyFile(ctx context.Context, toolCall ToolCall) Message {
+	var input CopyFileInput
+	if err := json.Unmarshal([]byte(toolCall.Arguments), &input); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Invalid arguments: %v", err))
+	}
+
+	source, err := a.workspacePath(input.Source)
+	if err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error copying file: %v", err))
+	}
+	destination, err := a.workspacePath(input.Destination)
+	if err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error copying file: %v", err))
+	}
+	info, err := os.Stat(source)
+	if err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error copying file: %v", err))
+	}
+	if info.IsDir() {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error copying file: %s is a directory", input.Source))
+	}
+	if err := a.claimPaths(input.Destination); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error copying file: %v", err))
+	}
+	if _, err := os.Lstat(destination); err == nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error copying file: destination %s already exists", input.Destination))
+	}
+	if err := ensureParentDir(destination); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error creating directory: %v", err))
+	}
+
+	if err := copyFile(source, destination, info.Mode().Perm()); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error copying file: %v", err))
+	}
+	a.recordChange(input.Destination)
+
+	return Message{
+		Role:       RoleTool,
+		Content:    "File copied successfully.",
+		ToolCallID: toolCall.ID,
+	}
+}
+
+func (a *Agent) handleDeleteFile(ctx context.Context, toolCall ToolCall) Message {
+	var input DeleteFileInput
+	if err := json.Unmarshal([]byte(toolCall.Arguments), &input); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Invalid arguments: %v", err))
+	}
+
+	path, err := a.workspacePath(input.Path)
+	if err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error deleting file: %v", err))
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error deleting file: %v", err))
+	}
+	if a.isWorkspaceRoot(info) {
+		return a.createErrorResponse(toolCall.ID, "Error deleting file: refusing to delete the workspace itself")
+	}
+	if err := a.claimPaths(input.Path); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error deleting file: %v", err))
+	}
+
+	if info.IsDir() {
+		if !input.Recursive {
+			return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error deleting file: %s is a directory, set recursive to delete it", input.Path))
+		}
+		err = os.RemoveAll(path)
+	} else {
+		err = os.Remove(path)
+	}
+	if err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error deleting file: %v", err))
+	}
+	a.recordChange(input.Path)
+
+	return Message{
+		Role:       RoleTool,
+		Content:    "File deleted successfully.",
+		ToolCallID: toolCall.ID,
+	}
+}
+
+func (a *Agent) handleMakeDir(ctx context.Context, toolCall ToolCall) Message {
+	var input MakeDirInput
+	if err := json.Unmarshal([]byte(toolCall.Arguments), &input); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Invalid arguments: %v", err))
+	}
+
+	path, err := a.workspacePath(input.Path)
+	if err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error creating directory: %v", err))
+	}
+	if err := a.claimPaths(input.Path); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error creating directory: %v", err))
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error creating directory: %v", err))
+	}
+	a.recordChange(input.Path)
+
+	return Message{
+		Role:       RoleTool,
+		Content:    "Directory created successfully.",
+		ToolCallID: toolCall.ID,
+	}
+}
+
+// resolvePath resolves a tool path against the agent's working directory
+func (a *Agent) resolvePath(path string) string {
+	if a.workDir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(a.workDir, path)
+}
+
+// workspacePath resolves a path given to a tool, refusing paths that leave the working directory
+// directly, through .. or through a symlink
+func (a *Agent) workspacePath(path string) (string, error) {
+	full := filepath.Clean(a.resolvePath(path))
+	if !insideWorkspace(a.resolvePath("."), full) {
+		return "", fmt.Errorf("%s is outside the workspace", path)
+	}
+	return full, nil
+}
+
+// isWorkspaceRoot reports whether info describes the working directory itself
+func (a *Agent) isWorkspaceRoot(info os.FileInfo) bool {
+	root, err := os.Stat(a.resolvePath("."))
+	return err == nil && os.SameFile(info, root)
+}
+
+// ensureParentDir creates the directory containing path if it does not exist
+func ensureParentDir(path string) error {
+	dir := filepath.Dir(path)
+	if dir == "." {
+		return nil
+	}
+	return os.MkdirAll(dir, 0755)
+}
+
+// copyFile copies the contents of src to a new file at dst with the given permissions
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
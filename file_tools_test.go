@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestToolCall(id, name string, input any) ToolCall {
+	args, _ := json.Marshal(input)
+	return ToolCall{
+		ID:        id,
+		Name:      name,
+		Arguments: string(args),
+	}
+}
+
+func TestHandleMoveFile_Success(t *testing.T) {
+	agent := setupTestAgent()
+	agent.setupTools()
+
+	dir := t.TempDir()
+	agent.workDir = dir
+	src := filepath.Join(dir, "old.go")
+	dst := filepath.Join(dir, "pkg", "new.go")
+	require.NoError(t, os.WriteFile(src, []byte("package old"), 0644))
+
+	response := agent.handleMoveFile(context.Background(), newTestToolCall("move-1", "move_file", MoveFileInput{Source: src, Destination: dst}))
+
+	assert.Equal(t, "File moved successfully.", response.Content)
+	assert.Equal(t, "move-1", response.ToolCallID)
+	assert.NoFileExists(t, src)
+	content, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, "package old", string(content))
+}
+
+func TestHandleMoveFile_DestinationExists(t *testing.T) {
+	agent := setupTestAgent()
+	agent.setupTools()
+
+	dir := t.TempDir()
+	agent.workDir = dir
+	src := filepath.Join(dir, "a.txt")
+	dst := filepath.Join(dir, "b.txt")
+	require.NoError(t, os.WriteFile(src, []byte("a"), 0644))
+	require.NoError(t, os.WriteFile(dst, []byte("b"), 0644))
+
+	response := agent.handleMoveFile(context.Background(), newTestToolCall("move-2", "move_file", MoveFileInput{Source: src, Destination: dst}))
+
+	assert.Contains(t, response.Content, "already exists")
+	assert.FileExists(t, src)
+}
+
+func TestHandleCopyFile_Success(t *testing.T) {
+	agent := setupTestAgent()
+	agent.setupTools()
+
+	agent.workDir = t.TempDir()
+	original, err := os.ReadFile("testdata/sample.txt")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(agent.workDir, "sample.txt"), original, 0644))
+
+	response := agent.handleCopyFile(context.Background(), newTestToolCall("copy-1", "copy_file", CopyFileInput{Source: "sample.txt", Destination: "copy/sample.txt"}))
+
+	assert.Equal(t, "File copied successfully.", response.Content)
+	copied, err := os.ReadFile(filepath.Join(agent.workDir, "copy", "sample.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, original, copied)
+}
+
+func TestHandleCopyFile_RejectsDirectory(t *testing.T) {
+	agent := setupTestAgent()
+	agent.setupTools()
+
+	response := agent.handleCopyFile(context.Background(), newTestToolCall("copy-2", "copy_file", CopyFileInput{Source: "testdata/test_dir", Destination: "testdata/x"}))
+
+	assert.Contains(t, response.Content, "is a directory")
+}
+
+func TestHandleDeleteFile_File(t *testing.T) {
+	agent := setupTestAgent()
+	agent.setupTools()
+
+	agent.workDir = t.TempDir()
+	path := filepath.Join(agent.workDir, "obsolete.go")
+	require.NoError(t, os.WriteFile(path, []byte("package obsolete"), 0644))
+
+	response := agent.handleDeleteFile(context.Background(), newTestToolCall("delete-1", "delete_file", DeleteFileInput{Path: path}))
+
+	assert.Equal(t, "File deleted successfully.", response.Content)
+	assert.NoFileExists(t, path)
+}
+
+func TestHandleDeleteFile_DirectoryRequiresRecursive(t *testing.T) {
+	agent := setupTestAgent()
+	agent.setupTools()
+
+	agent.workDir = t.TempDir()
+	dir := filepath.Join(agent.workDir, "pkg")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "f.txt"), []byte("x"), 0644))
+
+	response := agent.handleDeleteFile(context.Background(), newTestToolCall("delete-2", "delete_file", DeleteFileInput{Path: dir}))
+	assert.Contains(t, response.Content, "set recursive")
+	assert.DirExists(t, dir)
+
+	response = agent.handleDeleteFile(context.Background(), newTestToolCall("delete-3", "delete_file", DeleteFileInput{Path: dir, Recursive: true}))
+	assert.Equal(t, "File deleted successfully.", response.Content)
+	assert.NoDirExists(t, dir)
+}
+
+func TestHandleDeleteFile_NotFound(t *testing.T) {
+	agent := setupTestAgent()
+	agent.setupTools()
+
+	response := agent.handleDeleteFile(context.Background(), newTestToolCall("delete-4", "delete_file", DeleteFileInput{Path: "testdata/does_not_exist.txt"}))
+
+	assert.Contains(t, response.Content, "Error deleting file")
+}
+
+func TestHandleMakeDir(t *testing.T) {
+	agent := setupTestAgent()
+	agent.setupTools()
+
+	agent.workDir = t.TempDir()
+	agent.changes = newChangeTracker()
+	dir := filepath.Join(agent.workDir, "a", "b", "c")
+
+	response := agent.handleMakeDir(context.Background(), newTestToolCall("mkdir-1", "make_dir", MakeDirInput{Path: dir}))
+
+	assert.Equal(t, "Directory created successfully.", response.Content)
+	assert.DirExists(t, dir)
+	assert.Equal(t, []string{dir}, agent.changes.drain())
+}
+
+func TestFileTools_ConfinedToWorkspace(t *testing.T) {
+	agent := setupTestAgent()
+	agent.setupTools()
+	parent := t.TempDir()
+	agent.workDir = filepath.Join(parent, "workspace")
+	require.NoError(t, os.MkdirAll(agent.workDir, 0755))
+	outside := filepath.Join(parent, "outside.txt")
+	require.NoError(t, os.WriteFile(outside, []byte("secret"), 0644))
+	require.NoError(t, os.Symlink(parent, filepath.Join(agent.workDir, "escape")))
+	require.NoError(t, os.Symlink(filepath.Join(parent, "created.txt"), filepath.Join(agent.workDir, "dangling")))
+
+	calls := []ToolCall{
+		newTestToolCall("read", "read_file", ReadFileInput{Path: "../outside.txt"}),
+		newTestToolCall("read-abs", "read_file", ReadFileInput{Path: outside}),
+		newTestToolCall("read-link", "read_file", ReadFileInput{Path: "escape/outside.txt"}),
+		newTestToolCall("list", "list_dir", ListDirInput{Path: ".."}),
+		newTestToolCall("write", "write_to_file", WriteFileInput{Path: "escape/new.txt", Content: "x"}),
+		newTestToolCall("write-dangling", "write_to_file", WriteFileInput{Path: "dangling", Content: "x"}),
+		newTestToolCall("move", "move_file", MoveFileInput{Source: outside, Destination: "stolen.txt"}),
+		newTestToolCall("copy", "copy_file", CopyFileInput{Source: "../outside.txt", Destination: "stolen.txt"}),
+		newTestToolCall("delete", "delete_file", DeleteFileInput{Path: "../..", Recursive: true}),
+		newTestToolCall("delete-root", "delete_file", DeleteFileInput{Path: "/", Recursive: true}),
+		newTestToolCall("mkdir", "make_dir", MakeDirInput{Path: "../made"}),
+	}
+	for _, call := range calls {
+		response := agent.toolHandlers[call.Name](context.Background(), call)
+		assert.True(t, response.IsError, call.ID)
+		assert.Contains(t, response.Content, "is outside the workspace", call.ID)
+	}
+
+	response := agent.handleDeleteFile(context.Background(), newTestToolCall("delete-self", "delete_file", DeleteFileInput{Path: "sub/..", Recursive: true}))
+	assert.Equal(t, "Error deleting file: refusing to delete the workspace itself", response.Content)
+
+	assert.DirExists(t, agent.workDir)
+	assert.FileExists(t, outside)
+	assert.NoFileExists(t, filepath.Join(parent, "created.txt"))
+	assert.NoDirExists(t, filepath.Join(parent, "made"))
+	assert.NoFileExists(t, filepath.Join(agent.workDir, "stolen.txt"))
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// gitMaxOutputBytes caps how much git output is returned to the model
+	gitMaxOutputBytes = 64 * 1024
+	// gitTimeout bounds how long a single git command may run
+	gitTimeout = 30 * time.Second
+	// gitDefaultLogCount is the number of commits git_log returns when no count is given
+	gitDefaultLogCount = 20
+)
+
+// Git tool input structures
+type GitDiffInput struct {
+	Staged bool   `json:"staged" jsonschema_description:"Whether to diff staged changes instead of the working tree"`
+	Ref    string `json:"ref" jsonschema_description:"Optional revision to diff against, such as HEAD~1 or main"`
+	Path   string `json:"path" jsonschema_description:"Optional relative path to limit the diff to"`
+	Stat   bool   `json:"stat" jsonschema_description:"Whether to show only a summary of changed files"`
+}
+
+type GitLogInput struct {
+	Path     string `json:"path" jsonschema_description:"Optional relative path to limit the history to"`
+	MaxCount int    `json:"max_count" jsonschema_description:"Maximum number of commits to show"`
+}
+
+type GitBlameInput struct {
+	Path      string `json:"path" jsonschema_description:"The relative path of a file in the working directory."`
+	StartLine int    `json:"start_line" jsonschema_description:"First line to blame (1-based)"`
+	EndLine   int    `json:"end_line" jsonschema_description:"Last line to blame (inclusive)"`
+}
+
+type GitShowInput struct {
+	Path     string `json:"path" jsonschema_description:"The relative path of a file in the working directory."`
+	Revision string `json:"revision" jsonschema_description:"The revision to show the file at, such as HEAD~2 or a commit hash"`
+}
+
+func (a *Agent) createGitStatusTool() ToolDefinition {
+	schema := map[string]any{
+		"type":       "object",
+		"properties": map[string]any{},
+	}
+
+	return ToolDefinition{
+		Name:        "git_status",
+		Description: "Show the current git branch and which files are staged, modified or untracked.",
+		Parameters:  schema,
+	}
+}
+
+func (a *Agent) createGitDiffTool() ToolDefinition {
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"staged": map[string]any{
+				"type":        "boolean",
+				"description": "Whether to diff staged changes instead of the working tree",
+			},
+			"ref": map[string]any{
+				"type":        "string",
+				"description": "Optional revision to diff against, such as HEAD~1 or main",
+			},
+			"path": map[string]any{
+				"type":        "string",
+				"description": "Optional relative path to limit the diff to",
+			},
+			"stat": map[string]any{
+				"type":        "boolean",
+				"description": "Whether to show only a summary of changed files",
+			},
+		},
+	}
+
+	return ToolDefinition{
+		Name:        "git_diff",
+		Description: "Show a git diff of the working tree, of staged changes, or against a revision.",
+		Parameters:  schema,
+	}
+}
+
+func (a *Agent) createGitLogTool() ToolDefinition {
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"path": map[string]any{
+				"type":        "string",
+				"description": "Optional relative path to limit the history to",
+			},
+			"max_count": map[string]any{
+				"type":        "integer",
+				"description": "Maximum number of commits to show",
+			},
+		},
+	}
+
+	return ToolDefinition{
+		Name:        "git_log",
+		Description: "Show recent git commits, optionally only those touching a path.",
+		Parameters:  schema,
+	}
+}
+
+func (a *Agent) createGitBlameTool() ToolDefinition {
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"path": map[string]any{
+				"type":        "string",
+				"description": "The relative path of a file in the working directory.",
+			},
+			"start_line": map[string]any{
+				"type":        "integer",
+				"description": "First line to blame (1-based)",
+			},
+			"end_line": map[string]any{
+				"type":        "integer",
+				"description": "Last line to blame (inclusive)",
+			},
+		},
+		"required": []string{"path"},
+	}
+
+	return ToolDefinition{
+		Name:        "git_blame",
+		Description: "Show which commit last changed each line of a file, optionally for a line range.",
+		Parameters:  schema,
+	}
+}
+
+func (a *Agent) createGitShowTool() ToolDefinition {
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"path": map[string]any{
+				"type":        "string",
+				"description": "The relative path of a file in the working directory.",
+			},
+			"revision": map[string]any{
+				"type":        "string",
+				"description": "The revision to show the file at, such as HEAD~2 or a commit hash",
+			},
+		},
+		"required": []string{"path", "revision"},
+	}
+
+	return ToolDefinition{
+		Name:        "git_show",
+		Description: "Show the contents of a file as it was at a given git revision.",
+		Parameters:  schema,
+	}
+}
+
+func (a *Agent) handleGitStatus(ctx context.Context, toolCall ToolCall) Message {
+	out, err := a.runGit(ctx, "status", "--porcelain=v1", "--branch")
+	if err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error running git: %v", err))
+	}
+
+	return Message{
+		Role:       RoleTool,
+		Content:    formatGitStatus(out),
+		ToolCallID: toolCall.ID,
+	}
+}
+
+func (a *Agent) handleGitDiff(ctx context.Context, toolCall ToolCall) Message {
+	var input GitDiffInput
+	if err := json.Unmarshal([]byte(toolCall.Arguments), &input); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Invalid arguments: %v", err))
+	}
+	if err := validateGitRevision(input.Ref); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Invalid arguments: %v", err))
+	}
+
+	if err := a.checkGitPath(input.Path); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Invalid arguments: %v", err))
+	}
+
+	args := []string{"--literal-pathspecs", "diff", "--no-color"}
+	if input.Stat {
+		args = append(args, "--stat")
+	}
+	if input.Staged {
+		args = append(args, "--cached")
+	}
+	if input.Ref != "" {
+		args = append(args, input.Ref)
+	}
+	args = append(args, "--")
+	if input.Path != "" {
+		args = append(args, input.Path)
+	}
+
+	out, err := a.runGit(ctx, args...)
+	if err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error running git: %v", err))
+	}
+	if out == "" {
+		out = "No changes."
+	}
+
+	return Message{
+		Role:       RoleTool,
+		Content:    capGitOutput(out),
+		ToolCallID: toolCall.ID,
+	}
+}
+
+func (a *Agent) handleGitLog(ctx context.Context, toolCall ToolCall) Message {
+	var input GitLogInput
+	if err := json.Unmarshal([]byte(toolCall.Arguments), &input); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Invalid arguments: %v", err))
+	}
+
+	count := input.MaxCount
+	if count <= 0 {
+		count = gitDefaultLogCount
+	}
+
+	if err := a.checkGitPath(input.Path); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Invalid arguments: %v", err))
+	}
+
+	args := []string{"--literal-pathspecs", "log", "--no-color", "--date=short", fmt.Sprintf("--max-count=%d", count), "--format=%h%x1f%ad%x1f%an%x1f%s%x1e", "--"}
+	if input.Path != "" {
+		args = append(args, input.Path)
+	}
+
+	out, err := a.runGit(ctx, args...)
+	if err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error running git: %v", err))
+	}
+
+	return Message{
+		Role:       RoleTool,
+		Content:    capGitOutput(formatGitLog(out)),
+		ToolCallID: toolCall.ID,
+	}
+}
+
+func (a *Agent) handleGitBlame(ctx context.Context, toolCall ToolCall) Message {
+	var input GitBlameInput
+	if err := json.Unmarshal([]byte(toolCall.Arguments), &input); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Invalid arguments: %v", err))
+	}
+
+	if err := a.checkGitPath(input.Path); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Invalid arguments: %v", err))
+	}
+
+	args := []string{"blame", "--line-porcelain"}
+	if input.StartLine > 0 || input.EndLine > 0 {
+		start := max(input.StartLine, 1)
+		if input.EndLine > 0 && input.EndLine < start {
+			return a.createErrorResponse(toolCall.ID, "Invalid arguments: end_line is before start_line")
+		}
+		lineRange := strconv.Itoa(start) + ","
+		if input.EndLine > 0 {
+			lineRange += strconv.Itoa(input.EndLine)
+		}
+		args = append(args, "-L", lineRange)
+	}
+	args = append(args, "--", input.Path)
+
+	out, err := a.runGit(ctx, args...)
+	if err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error running git: %v", err))
+	}
+
+	return Message{
+		Role:       RoleTool,
+		Content:    capGitOutput(formatGitBlame(out)),
+		ToolCallID: toolCall.ID,
+	}
+}
+
+func (a *Agent) handleGitShow(ctx context.Context, toolCall ToolCall) Message {
+	var input GitShowInput
+	if err := json.Unmarshal([]byte(toolCall.Arguments), &input); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Invalid arguments: %v", err))
+	}
+	if input.Revision == "" {
+		return a.createErrorResponse(toolCall.ID, "Invalid arguments: revision is required")
+	}
+	if err := validateGitRevision(input.Revision); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Invalid arguments: %v", err))
+	}
+	if err := a.checkGitPath(input.Path); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Invalid arguments: %v", err))
+	}
+
+	// The ./ prefix makes git resolve the path relative to the working directory rather than the repository root
+	out, err := a.runGit(ctx, "show", fmt.Sprintf("%s:./%s", input.Revision, strings.TrimPrefix(input.Path, "./")))
+	if err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error running git: %v", err))
+	}
+
+	return Message{
+		Role:       RoleTool,
+		Content:    capGitOutput(out),
+		ToolCallID: toolCall.ID,
+	}
+}
+
+// runGit runs the local git binary in the agent's working directory and returns its stdout
+func (a *Agent) runGit(ctx context.Context, args ...string) (string, error) {
+	return runGitIn(ctx, a.workDir, args...)
+}
+
+// runGitIn runs the local git binary in dir and returns its stdout
+func runGitIn(ctx context.Context, dir string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s", msg)
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+// validateGitRevision rejects revisions that git would parse as command line options
+func validateGitRevision(rev string) error {
+	if strings.HasPrefix(rev, "-") {
+		return fmt.Errorf("invalid revision %q", rev)
+	}
+	return nil
+}
+
+// checkGitPath refuses a git tool path that leaves the working directory. Pathspec magic such as
+// :/ is disabled separately with --literal-pathspecs.
+func (a *Agent) checkGitPath(path string) error {
+	if path == "" {
+		return nil
+	}
+	_, err := a.workspacePath(path)
+	return err
+}
+
+// capGitOutput truncates output that would flood the model's context
+func capGitOutput(out string) string {
+	if len(out) <= gitMaxOutputBytes {
+		return out
+	}
+	kept := truncateBytes(out, gitMaxOutputBytes)
+	return fmt.Sprintf("%s\n... output truncated (%d more bytes)\n", kept, len(out)-len(kept))
+}
+
+// formatGitStatus turns porcelain v1 status output into readable lines
+func formatGitStatus(porcelain string) string {
+	var out strings.Builder
+	var changes int
+
+	for _, line := range strings.Split(strings.TrimRight(porcelain, "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		if branch, ok := strings.CutPrefix(line, "## "); ok {
+			out.WriteString("Branch: " + branch + "\n")
+			continue
+		}
+		if len(line) < 4 {
+			continue
+		}
+
+		changes++
+		index, worktree, path := line[0], line[1], line[3:]
+		switch {
+		case index == '?' && worktree == '?':
+			out.WriteString("untracked: " + path + "\n")
+		case index == 'U' || worktree == 'U' || (index == 'A' && worktree == 'A') || (index == 'D' && worktree == 'D'):
+			out.WriteString("conflicted: " + path + "\n")
+		default:
+			if index != ' ' {
+				out.WriteString(fmt.Sprintf("staged %s: %s\n", gitStatusWord(index), path))
+			}
+			if worktree != ' ' {
+				out.WriteString(fmt.Sprintf("unstaged %s: %s\n", gitStatusWord(worktree), path))
+			}
+		}
+	}
+
+	if changes == 0 {
+		out.WriteString("Working tree clean.\n")
+	}
+	return out.String()
+}
+
+func gitStatusWord(code byte) string {
+	switch code {
+	case 'M':
+		return "modified"
+	case 'A':
+		return "added"
+	case 'D':
+		return "deleted"
+	case 'R':
+		return "renamed"
+	case 'C':
+		return "copied"
+	case 'T':
+		return "type changed"
+	default:
+		return string(code)
+	}
+}
+
+// formatGitLog renders unit/record-separated log output as one commit per line
+func formatGitLog(raw string) string {
+	var out strings.Builder
+	for _, record := range strings.Split(raw, "\x1e") {
+		record = strings.TrimSpace(record)
+		if record == "" {
+			continue
+		}
+		fields := strings.SplitN(record, "\x1f", 4)
+		if len(fields) != 4 {
+			continue
+		}
+		out.WriteString(fmt.Sprintf("%s %s %s: %s\n", fields[0], fields[1], fields[2], fields[3]))
+	}
+	if out.Len() == 0 {
+		return "No commits.\n"
+	}
+	return out.String()
+}
+
+// formatGitBlame renders --line-porcelain output as "hash date author lineno| content"
+func formatGitBlame(raw string) string {
+	var out strings.Builder
+	var hash, author, date, lineNo string
+
+	for _, line := range strings.Split(raw, "\n") {
+		switch {
+		case strings.HasPrefix(line, "\t"):
+			out.WriteString(fmt.Sprintf("%s %s %s %s| %s\n", hash, date, author, lineNo, line[1:]))
+		case strings.HasPrefix(line, "author "):
+			author = strings.TrimPrefix(line, "author ")
+		case strings.HasPrefix(line, "author-time "):
+			if sec, err := strconv.ParseInt(strings.TrimPrefix(line, "author-time "), 10, 64); err == nil {
+				date = time.Unix(sec, 0).UTC().Format("2006-01-02")
+			}
+		default:
+			fields := strings.Fields(line)
+			if len(fields) >= 3 && (len(fields[0]) == 40 || len(fields[0]) == 64) {
+				hash = fields[0][:8]
+				lineNo = fields[2]
+			}
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// initTestRepo creates a temporary git repository with a single committed file
+func initTestRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not available")
+	}
+
+	dir := t.TempDir()
+	gitInDir(t, dir, "init", "-q", "-b", "main")
+	gitInDir(t, dir, "config", "user.name", "Test User")
+	gitInDir(t, dir, "config", "user.email", "test@example.com")
+	gitInDir(t, dir, "config", "commit.gpgsign", "false")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644))
+	gitInDir(t, dir, "add", "main.go")
+	gitInDir(t, dir, "commit", "-q", "-m", "Initial commit")
+	return dir
+}
+
+func gitInDir(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	return string(out)
+}
+
+func setupGitTestAgent(t *testing.T) (*Agent, string) {
+	dir := initTestRepo(t)
+	agent := setupTestAgent()
+	agent.workDir = dir
+	agent.setupTools()
+	return agent, dir
+}
+
+func TestHandleGitStatus(t *testing.T) {
+	agent, dir := setupGitTestAgent(t)
+
+	response := agent.handleGitStatus(context.Background(), newTestToolCall("status-1", "git_status", struct{}{}))
+	assert.Contains(t, response.Content, "Branch: main")
+	assert.Contains(t, response.Content, "Working tree clean.")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "new.go"), []byte("package main\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "staged.go"), []byte("package main\n"), 0644))
+	gitInDir(t, dir, "add", "staged.go")
+
+	response = agent.handleGitStatus(context.Background(), newTestToolCall("status-2", "git_status", struct{}{}))
+	assert.Contains(t, response.Content, "unstaged modified: main.go")
+	assert.Contains(t, response.Content, "untracked: new.go")
+	assert.Contains(t, response.Content, "staged added: staged.go")
+	assert.Equal(t, "status-2", response.ToolCallID)
+}
+
+func TestHandleGitStatus_NotARepository(t *testing.T) {
+	agent := setupTestAgent()
+	agent.workDir = t.TempDir()
+	agent.setupTools()
+
+	response := agent.handleGitStatus(context.Background(), newTestToolCall("status-3", "git_status", struct{}{}))
+	assert.Contains(t, response.Content, "Error running git")
+}
+
+func TestHandleGitDiff(t *testing.T) {
+	agent, dir := setupGitTestAgent(t)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() { println(1) }\n"), 0644))
+
+	response := agent.handleGitDiff(context.Background(), newTestToolCall("diff-1", "git_diff", GitDiffInput{}))
+	assert.Contains(t, response.Content, "+func main() { println(1) }")
+
+	response = agent.handleGitDiff(context.Background(), newTestToolCall("diff-2", "git_diff", GitDiffInput{Staged: true}))
+	assert.Equal(t, "No changes.", response.Content)
+
+	gitInDir(t, dir, "commit", "-q", "-am", "Print one")
+	response = agent.handleGitDiff(context.Background(), newTestToolCall("diff-3", "git_diff", GitDiffInput{Ref: "HEAD~1", Stat: true}))
+	assert.Contains(t, response.Content, "main.go")
+	assert.Contains(t, response.Content, "1 file changed")
+}
+
+func TestHandleGitDiff_RejectsOptionRef(t *testing.T) {
+	agent, _ := setupGitTestAgent(t)
+
+	response := agent.handleGitDiff(context.Background(), newTestToolCall("diff-4", "git_diff", GitDiffInput{Ref: "--output=/tmp/x"}))
+	assert.Contains(t, response.Content, "Invalid arguments")
+}
+
+func TestHandleGitLog(t *testing.T) {
+	agent, dir := setupGitTestAgent(t)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.go"), []byte("package main\n"), 0644))
+	gitInDir(t, dir, "add", "other.go")
+	gitInDir(t, dir, "commit", "-q", "-m", "Add other")
+
+	response := agent.handleGitLog(context.Background(), newTestToolCall("log-1", "git_log", GitLogInput{}))
+	assert.Contains(t, response.Content, "Test User: Add other")
+	assert.Contains(t, response.Content, "Test User: Initial commit")
+
+	response = agent.handleGitLog(context.Background(), newTestToolCall("log-2", "git_log", GitLogInput{Path: "main.go"}))
+	assert.Contains(t, response.Content, "Initial commit")
+	assert.NotContains(t, response.Content, "Add other")
+
+	response = agent.handleGitLog(context.Background(), newTestToolCall("log-3", "git_log", GitLogInput{MaxCount: 1}))
+	assert.Contains(t, response.Content, "Add other")
+	assert.NotContains(t, response.Content, "Initial commit")
+}
+
+func TestHandleGitBlame(t *testing.T) {
+	agent, _ := setupGitTestAgent(t)
+
+	response := agent.handleGitBlame(context.Background(), newTestToolCall("blame-1", "git_blame", GitBlameInput{Path: "main.go", StartLine: 3, EndLine: 3}))
+	assert.Contains(t, response.Content, "Test User 3| func main() {}")
+	assert.NotContains(t, response.Content, "package main")
+
+	response = agent.handleGitBlame(context.Background(), newTestToolCall("blame-2", "git_blame", GitBlameInput{Path: "main.go", StartLine: 3, EndLine: 1}))
+	assert.Contains(t, response.Content, "end_line is before start_line")
+}
+
+func TestHandleGitShow(t *testing.T) {
+	agent, dir := setupGitTestAgent(t)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("changed\n"), 0644))
+	gitInDir(t, dir, "commit", "-q", "-am", "Change main")
+
+	response := agent.handleGitShow(context.Background(), newTestToolCall("show-1", "git_show", GitShowInput{Path: "main.go", Revision: "HEAD~1"}))
+	assert.Equal(t, "package main\n\nfunc main() {}\n", response.Content)
+
+	response = agent.handleGitShow(context.Background(), newTestToolCall("show-2", "git_show", GitShowInput{Path: "main.go"}))
+	assert.Contains(t, response.Content, "revision is required")
+}
+
+func TestGitTools_ConfinedToWorkspace(t *testing.T) {
+	agent, dir := setupGitTestAgent(t)
+	agent.workDir = filepath.Join(dir, "sub")
+	require.NoError(t, os.MkdirAll(agent.workDir, 0755))
+
+	calls := []ToolCall{
+		newTestToolCall("show", "git_show", GitShowInput{Path: "../main.go", Revision: "HEAD"}),
+		newTestToolCall("blame", "git_blame", GitBlameInput{Path: filepath.Join(dir, "main.go")}),
+		newTestToolCall("diff", "git_diff", GitDiffInput{Path: ".."}),
+		newTestToolCall("log", "git_log", GitLogInput{Path: "../main.go"}),
+	}
+	for _, call := range calls {
+		response := agent.toolHandlers[call.Name](context.Background(), call)
+		assert.Contains(t, response.Content, "is outside the workspace", call.ID)
+	}
+
+	response := agent.handleGitLog(context.Background(), newTestToolCall("log-magic", "git_log", GitLogInput{Path: ":/main.go"}))
+	assert.NotContains(t, response.Content, "Initial commit", "pathspec magic is taken literally")
+}
+
+func TestCapGitOutput(t *testing.T) {
+	short := "short output"
+	assert.Equal(t, short, capGitOutput(short))
+
+	long := string(make([]byte, gitMaxOutputBytes+10))
+	capped := capGitOutput(long)
+	assert.Contains(t, capped, "output truncated (10 more bytes)")
+
+	wide := strings.Repeat("x", gitMaxOutputBytes-1) + "é"
+	capped = capGitOutput(wide)
+	assert.True(t, utf8.ValidString(capped))
+	assert.True(t, strings.HasPrefix(capped, strings.Repeat("x", gitMaxOutputBytes-1)+"\n... output truncated (2 more bytes)"), "the split character is dropped whole")
+}
@@ -2,13 +2,17 @@ module agent
 
 go 1.22.5
 
-require github.com/sashabaranov/go-openai v1.41.2
+require (
+	github.com/sashabaranov/go-openai v1.41.2
+	github.com/stretchr/testify v1.8.4
+	golang.org/x/term v0.27.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stretchr/objx v0.5.0 // indirect
-	github.com/stretchr/testify v1.8.4 // indirect
 	go.uber.org/mock v0.4.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	golang.org/x/sys v0.28.0 // indirect
 )
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"io"
 	"os"
 	"strings"
 	"syscall"
@@ -135,4 +136,37 @@ func TestInputManager_Cleanup(t *testing.T) {
 	assert.NotPanics(t, func() {
 		im.Cleanup()
 	})
-}
\ No newline at end of file
+}
+func TestInputManager_DoubleCtrlCDuringTurnExitsEditor(t *testing.T) {
+	im := NewInputManager()
+	defer im.Cleanup()
+	im.editor = newLineEditor(strings.NewReader("next prompt\r"), io.Discard, func() int { return 80 }, &lineHistory{})
+
+	// A double Ctrl-C while a turn runs: the first cancels the turn, the second asks to exit
+	cancelled := make(chan struct{})
+	stop := im.CancelOnInterrupt(func() { close(cancelled) })
+	im.sigChan <- syscall.SIGINT
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for the turn to be cancelled")
+	}
+	stop()
+	im.sigChan <- syscall.SIGINT
+	require.Eventually(t, func() bool { return len(im.shouldExit) == 1 }, time.Second, time.Millisecond)
+
+	input, ok := im.GetInput()
+	assert.False(t, ok, "the editor is not entered after an exit request")
+	assert.Empty(t, input)
+
+	// Signals are still handled, so later turns can be interrupted
+	im.ctrlCMu.Lock()
+	im.lastCtrlC = time.Time{}
+	im.ctrlCMu.Unlock()
+	im.sigChan <- syscall.SIGINT
+	select {
+	case <-im.shouldClear:
+	case <-time.After(time.Second):
+		t.Fatal("Ctrl-C is no longer handled after an exit request")
+	}
+}
@@ -16,7 +16,7 @@ import (
 
 func TestIntegration_ReadWriteWorkflow(t *testing.T) {
 	mockClient := mocks.NewMockOpenAIClient()
-	agent := NewAgent(mockClient, nil, "test-model")
+	agent := NewAgent(NewOpenAIProvider(mockClient), nil, "test-model")
 
 	// Setup test scenario: AI wants to read a file, then write a new file
 	readArgs, _ := json.Marshal(ReadFileInput{Path: "testdata/sample.txt"})
@@ -41,9 +41,9 @@ func TestIntegration_ReadWriteWorkflow(t *testing.T) {
 	mockClient.AddResponse(thirdResponse)
 
 	// Start conversation
-	initialMessages := []openai.ChatCompletionMessage{
+	initialMessages := []Message{
 		{
-			Role:    openai.ChatMessageRoleUser,
+			Role:    RoleUser,
 			Content: "Read the sample file and create a processed version",
 		},
 	}
@@ -53,7 +53,7 @@ func TestIntegration_ReadWriteWorkflow(t *testing.T) {
 		logOutput = append(logOutput, format)
 	}
 
-	finalMessages, err := agent.DriveConversation(context.Background(), initialMessages, logf)
+	finalMessages, err := agent.DriveConversation(context.Background(), initialMessages, logf, nil)
 
 	require.NoError(t, err)
 	assert.Equal(t, 3, mockClient.CallCount)
@@ -85,7 +85,7 @@ func TestIntegration_ReadWriteWorkflow(t *testing.T) {
 
 func TestIntegration_DirectoryExplorationWorkflow(t *testing.T) {
 	mockClient := mocks.NewMockOpenAIClient()
-	agent := NewAgent(mockClient, nil, "test-model")
+	agent := NewAgent(NewOpenAIProvider(mockClient), nil, "test-model")
 
 	// Create a temporary directory structure for testing
 	tempDir := filepath.Join("testdata", "temp_explore")
@@ -117,14 +117,14 @@ func TestIntegration_DirectoryExplorationWorkflow(t *testing.T) {
 	mockClient.AddResponse(secondResponse)
 	mockClient.AddResponse(thirdResponse)
 
-	initialMessages := []openai.ChatCompletionMessage{
+	initialMessages := []Message{
 		{
-			Role:    openai.ChatMessageRoleUser,
+			Role:    RoleUser,
 			Content: "Explore the temp directory structure",
 		},
 	}
 
-	finalMessages, err := agent.DriveConversation(context.Background(), initialMessages, nil)
+	finalMessages, err := agent.DriveConversation(context.Background(), initialMessages, nil, nil)
 
 	require.NoError(t, err)
 	assert.Equal(t, 3, mockClient.CallCount)
@@ -133,7 +133,7 @@ func TestIntegration_DirectoryExplorationWorkflow(t *testing.T) {
 	// Verify tool responses contain expected file listings
 	var toolResponses []string
 	for _, msg := range finalMessages {
-		if msg.Role == openai.ChatMessageRoleTool {
+		if msg.Role == RoleTool {
 			toolResponses = append(toolResponses, msg.Content)
 		}
 	}
@@ -145,7 +145,7 @@ func TestIntegration_DirectoryExplorationWorkflow(t *testing.T) {
 
 func TestIntegration_ErrorHandling(t *testing.T) {
 	mockClient := mocks.NewMockOpenAIClient()
-	agent := NewAgent(mockClient, nil, "test-model")
+	agent := NewAgent(NewOpenAIProvider(mockClient), nil, "test-model")
 
 	// Setup scenario with file that doesn't exist
 	readArgs, _ := json.Marshal(ReadFileInput{Path: "nonexistent_file.txt"})
@@ -160,14 +160,14 @@ func TestIntegration_ErrorHandling(t *testing.T) {
 	mockClient.AddResponse(firstResponse)
 	mockClient.AddResponse(secondResponse)
 
-	initialMessages := []openai.ChatCompletionMessage{
+	initialMessages := []Message{
 		{
-			Role:    openai.ChatMessageRoleUser,
+			Role:    RoleUser,
 			Content: "Read the nonexistent file",
 		},
 	}
 
-	finalMessages, err := agent.DriveConversation(context.Background(), initialMessages, nil)
+	finalMessages, err := agent.DriveConversation(context.Background(), initialMessages, nil, nil)
 
 	require.NoError(t, err)
 	assert.Equal(t, 2, mockClient.CallCount)
@@ -175,7 +175,7 @@ func TestIntegration_ErrorHandling(t *testing.T) {
 	// Find the tool error response
 	var errorResponse string
 	for _, msg := range finalMessages {
-		if msg.Role == openai.ChatMessageRoleTool && msg.ToolCallID == "call-1" {
+		if msg.Role == RoleTool && msg.ToolCallID == "call-1" {
 			errorResponse = msg.Content
 			break
 		}
@@ -186,7 +186,7 @@ func TestIntegration_ErrorHandling(t *testing.T) {
 
 func TestIntegration_SubAgentExecution(t *testing.T) {
 	mockClient := mocks.NewMockOpenAIClient()
-	agent := NewAgent(mockClient, nil, "test-model")
+	agent := NewAgent(NewOpenAIProvider(mockClient), nil, "test-model")
 
 	// Main agent wants to run a sub-agent
 	runAgentArgs, _ := json.Marshal(RunAgentInput{Task: "Create a test file with specific content"})
@@ -214,14 +214,14 @@ func TestIntegration_SubAgentExecution(t *testing.T) {
 	mockClient.AddResponse(subAgentComplete)
 	mockClient.AddResponse(mainComplete)
 
-	initialMessages := []openai.ChatCompletionMessage{
+	initialMessages := []Message{
 		{
-			Role:    openai.ChatMessageRoleUser,
+			Role:    RoleUser,
 			Content: "Use a sub-agent to create a test file",
 		},
 	}
 
-	finalMessages, err := agent.DriveConversation(context.Background(), initialMessages, nil)
+	finalMessages, err := agent.DriveConversation(context.Background(), initialMessages, nil, nil)
 
 	require.NoError(t, err)
 
@@ -236,19 +236,21 @@ func TestIntegration_SubAgentExecution(t *testing.T) {
 	// Verify run_agent tool response contains sub-agent output
 	var runAgentResponse string
 	for _, msg := range finalMessages {
-		if msg.Role == openai.ChatMessageRoleTool && msg.ToolCallID == "call-1" {
+		if msg.Role == RoleTool && msg.ToolCallID == "call-1" {
 			runAgentResponse = msg.Content
 			break
 		}
 	}
 
 	assert.Contains(t, runAgentResponse, "Agent task: Create a test file with specific content")
-	assert.Contains(t, runAgentResponse, "Creating the requested file")
+	assert.Contains(t, runAgentResponse, "File created successfully")
+	assert.Contains(t, runAgentResponse, "Files modified: testdata/subagent_output.txt")
+	assert.NotContains(t, runAgentResponse, "Creating the requested file")
 }
 
 func TestIntegration_ComplexMultiToolWorkflow(t *testing.T) {
 	mockClient := mocks.NewMockOpenAIClient()
-	agent := NewAgent(mockClient, nil, "test-model")
+	agent := NewAgent(NewOpenAIProvider(mockClient), nil, "test-model")
 
 	// Complex workflow: list dir -> read files -> process -> write summary
 	listArgs, _ := json.Marshal(ListDirInput{Path: "testdata", Recursive: false})
@@ -278,14 +280,14 @@ func TestIntegration_ComplexMultiToolWorkflow(t *testing.T) {
 	mockClient.AddResponse(response3)
 	mockClient.AddResponse(response4)
 
-	initialMessages := []openai.ChatCompletionMessage{
+	initialMessages := []Message{
 		{
-			Role:    openai.ChatMessageRoleUser,
+			Role:    RoleUser,
 			Content: "Analyze the testdata directory and create a summary",
 		},
 	}
 
-	finalMessages, err := agent.DriveConversation(context.Background(), initialMessages, nil)
+	finalMessages, err := agent.DriveConversation(context.Background(), initialMessages, nil, nil)
 
 	require.NoError(t, err)
 	assert.Equal(t, 4, mockClient.CallCount)
@@ -0,0 +1,705 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/term"
+)
+
+const (
+	// historyFileName is where entered lines are kept between sessions, within the user config directory
+	historyFileName = "history"
+	// maxHistoryEntries is how many entered lines the history keeps
+	maxHistoryEntries = 1000
+	// continuationPrompt starts each further line of multiline input
+	continuationPrompt = "... "
+)
+
+var ansiEscapePattern = regexp.MustCompile("\x1b\\[[0-9;?]*[A-Za-z]")
+
+// keyCode identifies an editing key decoded from terminal input
+type keyCode int
+
+const (
+	keyRune keyCode = iota
+	keyEnter
+	keyNewline // inserts a line break: Alt-Enter, Shift-Enter where the terminal reports it, or Ctrl-J
+	keyBackspace
+	keyDelete
+	keyLeft
+	keyRight
+	keyUp
+	keyDown
+	keyHome
+	keyEnd
+	keyWordLeft
+	keyWordRight
+	keyDeleteWordBack // Ctrl-W, deletes back to whitespace
+	keyDeleteWordPart // Alt-Backspace, deletes back to a non-word character
+	keyDeleteWordForward
+	keyKillToEnd
+	keyKillToStart
+	keyInterrupt
+	keyEOF
+	keySearch
+	keyCancel
+	keyTab
+	keyClearScreen
+	keyPasteStart
+	keyPasteEnd
+	keyEscape
+	keyUnknown
+)
+
+// key is one decoded keypress
+type key struct {
+	code keyCode
+	r    rune // the typed character for keyRune
+}
+
+// lineHistory is the list of previously entered lines, optionally persisted to a file
+type lineHistory struct {
+	entries []string
+	path    string // empty keeps the history in memory only
+}
+
+// loadLineHistory reads the history file at path; each line holds one JSON-encoded entry so
+// multiline input survives. A missing file starts an empty history.
+func loadLineHistory(path string) *lineHistory {
+	h := &lineHistory{path: path}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return h
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		var entry string
+		if json.Unmarshal([]byte(line), &entry) == nil && entry != "" {
+			h.entries = append(h.entries, entry)
+		}
+	}
+	if len(h.entries) > maxHistoryEntries {
+		h.entries = h.entries[len(h.entries)-maxHistoryEntries:]
+		h.rewrite()
+	}
+	return h
+}
+
+// add records an entered line, skipping blanks and repeats of the previous entry
+func (h *lineHistory) add(entry string) {
+	if strings.TrimSpace(entry) == "" || (len(h.entries) > 0 && h.entries[len(h.entries)-1] == entry) {
+		return
+	}
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > maxHistoryEntries {
+		h.entries = h.entries[len(h.entries)-maxHistoryEntries:]
+		h.rewrite()
+		return
+	}
+	if h.path == "" {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return
+	}
+	file, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	encoded, _ := json.Marshal(entry)
+	file.Write(append(encoded, '\n'))
+}
+
+// rewrite replaces the history file with the current entries
+func (h *lineHistory) rewrite() {
+	if h.path == "" {
+		return
+	}
+	var out strings.Builder
+	for _, entry := range h.entries {
+		encoded, _ := json.Marshal(entry)
+		out.Write(encoded)
+		out.WriteByte('\n')
+	}
+	os.WriteFile(h.path, []byte(out.String()), 0600)
+}
+
+// lineEditor reads lines from a terminal in raw mode, providing cursor movement, word editing,
+// history with reverse search, tab completion and multiline input
+type lineEditor struct {
+	in        *bufio.Reader
+	out       io.Writer
+	width     func() int // terminal width in columns
+	history   *lineHistory
+	complete  func(line string) []string // nil disables completion
+	interrupt func() bool                // called on Ctrl-C; true ends input
+
+	// state of the line being edited
+	prompt    string
+	buf       []rune
+	pos       int
+	cursorRow int    // row of the cursor below the prompt's row, as last drawn
+	histIndex int    // entry being shown; len(history.entries) is the draft
+	draft     []rune // the new line, kept while browsing history
+	searching bool   // reverse search is active
+	query     []rune // reverse search query
+	match     int    // history entry matching the query, or -1
+}
+
+// terminalWidth returns the width of the terminal on stdout
+func terminalWidth() int {
+	width, _, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return 0
+	}
+	return width
+}
+
+func newLineEditor(in io.Reader, out io.Writer, width func() int, history *lineHistory) *lineEditor {
+	return &lineEditor{in: bufio.NewReader(in), out: out, width: width, history: history}
+}
+
+// readLine shows prompt and edits a line until Enter. It returns false when input ends, on Ctrl-D on
+// an empty line, or when interrupt asks to stop.
+func (e *lineEditor) readLine(prompt string) (string, bool) {
+	e.prompt, e.buf, e.pos, e.cursorRow = prompt, nil, 0, 0
+	e.histIndex, e.draft, e.searching = len(e.history.entries), nil, false
+	e.refresh()
+
+	for {
+		k, err := e.readKey()
+		if err != nil {
+			e.finish()
+			return "", false
+		}
+		if e.searching && e.handleSearchKey(k) {
+			continue
+		}
+
+		switch k.code {
+		case keyRune:
+			e.insert(k.r)
+		case keyEnter:
+			// A trailing backslash continues the input on a new line
+			if e.pos == len(e.buf) && e.pos > 0 && e.buf[e.pos-1] == '\\' {
+				e.buf[e.pos-1] = '\n'
+				break
+			}
+			line := string(e.buf)
+			e.finish()
+			e.history.add(line)
+			return line, true
+		case keyNewline:
+			e.insert('\n')
+		case keyBackspace:
+			if e.pos > 0 {
+				e.buf = append(e.buf[:e.pos-1], e.buf[e.pos:]...)
+				e.pos--
+			}
+		case keyDelete:
+			if e.pos < len(e.buf) {
+				e.buf = append(e.buf[:e.pos], e.buf[e.pos+1:]...)
+			}
+		case keyEOF:
+			if len(e.buf) == 0 {
+				e.finish()
+				return "", false
+			}
+			if e.pos < len(e.buf) {
+				e.buf = append(e.buf[:e.pos], e.buf[e.pos+1:]...)
+			}
+		case keyLeft:
+			if e.pos > 0 {
+				e.pos--
+			}
+		case keyRight:
+			if e.pos < len(e.buf) {
+				e.pos++
+			}
+		case keyHome:
+			e.pos = e.lineStart()
+		case keyEnd:
+			e.pos = e.lineEnd()
+		case keyWordLeft:
+			e.pos = e.wordStart(e.pos, isWordRune)
+		case keyWordRight:
+			e.pos = e.wordEnd(e.pos)
+		case keyDeleteWordBack:
+			e.deleteBack(e.wordStart(e.pos, func(r rune) bool { return !unicode.IsSpace(r) }))
+		case keyDeleteWordPart:
+			e.deleteBack(e.wordStart(e.pos, isWordRune))
+		case keyDeleteWordForward:
+			end := e.wordEnd(e.pos)
+			e.buf = append(e.buf[:e.pos], e.buf[end:]...)
+		case keyKillToEnd:
+			end := e.lineEnd()
+			e.buf = append(e.buf[:e.pos], e.buf[end:]...)
+		case keyKillToStart:
+			e.deleteBack(e.lineStart())
+		case keyUp:
+			if !e.moveLine(-1) {
+				e.showHistory(e.histIndex - 1)
+			}
+		case keyDown:
+			if !e.moveLine(1) {
+				e.showHistory(e.histIndex + 1)
+			}
+		case keySearch:
+			e.searching, e.query, e.match = true, nil, -1
+		case keyTab:
+			e.completeLine()
+		case keyClearScreen:
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+			e.cursorRow = 0
+		case keyPasteStart:
+			if err := e.paste(); err != nil {
+				e.finish()
+				return "", false
+			}
+		case keyInterrupt:
+			if e.interrupt != nil && e.interrupt() {
+				e.finish()
+				return "", false
+			}
+			// A single Ctrl-C clears the input
+			e.buf, e.pos = nil, 0
+			e.histIndex = len(e.history.entries)
+		}
+		e.refresh()
+	}
+}
+
+// handleSearchKey applies a key during reverse search, returning false when the key ends the search
+// and should then be handled as a normal key
+func (e *lineEditor) handleSearchKey(k key) bool {
+	switch k.code {
+	case keyRune:
+		e.query = append(e.query, k.r)
+		e.findMatch(len(e.history.entries) - 1)
+	case keyBackspace:
+		if len(e.query) > 0 {
+			e.query = e.query[:len(e.query)-1]
+		}
+		e.findMatch(len(e.history.entries) - 1)
+	case keySearch:
+		if e.match > 0 {
+			e.findMatch(e.match - 1)
+		}
+	case keyCancel, keyInterrupt:
+		e.searching = false
+	default:
+		// Any other key accepts the match
+		e.searching = false
+		if e.match >= 0 {
+			e.buf = []rune(e.history.entries[e.match])
+			e.pos = len(e.buf)
+			e.histIndex = e.match
+		}
+		if k.code == keyEnter {
+			return false
+		}
+	}
+	e.refresh()
+	return true
+}
+
+// findMatch finds the newest history entry at or before from containing the search query
+func (e *lineEditor) findMatch(from int) {
+	for i := from; i >= 0; i-- {
+		if strings.Contains(e.history.entries[i], string(e.query)) {
+			e.match = i
+			return
+		}
+	}
+	if len(e.query) == 0 {
+		e.match = -1
+	}
+}
+
+func (e *lineEditor) insert(r rune) {
+	e.buf = append(e.buf[:e.pos], append([]rune{r}, e.buf[e.pos:]...)...)
+	e.pos++
+}
+
+func (e *lineEditor) deleteBack(start int) {
+	e.buf = append(e.buf[:start], e.buf[e.pos:]...)
+	e.pos = start
+}
+
+// lineStart and lineEnd bound the line of multiline input the cursor is on
+func (e *lineEditor) lineStart() int {
+	i := e.pos
+	for i > 0 && e.buf[i-1] != '\n' {
+		i--
+	}
+	return i
+}
+
+func (e *lineEditor) lineEnd() int {
+	i := e.pos
+	for i < len(e.buf) && e.buf[i] != '\n' {
+		i++
+	}
+	return i
+}
+
+// wordStart returns the start of the word before pos, where inWord decides which runes form words
+func (e *lineEditor) wordStart(pos int, inWord func(rune) bool) int {
+	for pos > 0 && !inWord(e.buf[pos-1]) {
+		pos--
+	}
+	for pos > 0 && inWord(e.buf[pos-1]) {
+		pos--
+	}
+	return pos
+}
+
+func (e *lineEditor) wordEnd(pos int) int {
+	for pos < len(e.buf) && !isWordRune(e.buf[pos]) {
+		pos++
+	}
+	for pos < len(e.buf) && isWordRune(e.buf[pos]) {
+		pos++
+	}
+	return pos
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// moveLine moves the cursor to the same column of the previous or next line of multiline input,
+// reporting false when there is no such line
+func (e *lineEditor) moveLine(direction int) bool {
+	start := e.lineStart()
+	column := e.pos - start
+	if direction < 0 {
+		if start == 0 {
+			return false
+		}
+		e.pos = start - 1
+		e.pos = e.lineStart() + min(column, e.lineEnd()-e.lineStart())
+		return true
+	}
+	end := e.lineEnd()
+	if end == len(e.buf) {
+		return false
+	}
+	e.pos = end + 1
+	e.pos = e.lineStart() + min(column, e.lineEnd()-e.lineStart())
+	return true
+}
+
+// showHistory replaces the input with history entry index, where the index past the newest entry is
+// the line being written
+func (e *lineEditor) showHistory(index int) {
+	if index < 0 || index > len(e.history.entries) || index == e.histIndex {
+		return
+	}
+	if e.histIndex == len(e.history.entries) {
+		e.draft = e.buf
+	}
+	e.histIndex = index
+	if index == len(e.history.entries) {
+		e.buf = e.draft
+	} else {
+		e.buf = []rune(e.history.entries[index])
+	}
+	e.pos = len(e.buf)
+}
+
+// completeLine completes the input with the completer when the cursor is at the end, listing the
+// candidates when the input cannot be extended
+func (e *lineEditor) completeLine() {
+	if e.complete == nil || e.pos != len(e.buf) {
+		return
+	}
+	matches := e.complete(string(e.buf))
+	switch len(matches) {
+	case 0:
+		return
+	case 1:
+		e.buf = []rune(matches[0])
+		if !strings.HasSuffix(matches[0], "/") {
+			e.buf = append(e.buf, ' ')
+		}
+	default:
+		prefix := matches[0]
+		for _, match := range matches[1:] {
+			for !strings.HasPrefix(match, prefix) {
+				prefix = prefix[:len(prefix)-1]
+			}
+		}
+		if len(prefix) > len(string(e.buf)) {
+			e.buf = []rune(prefix)
+		} else {
+			e.moveToEnd()
+			words := make([]string, len(matches))
+			for i, match := range matches {
+				words[i] = match[strings.LastIndexAny(match, " \t\n")+1:]
+			}
+			fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(words, "  "))
+			e.cursorRow = 0
+		}
+	}
+	e.pos = len(e.buf)
+}
+
+// paste inserts bracketed paste content literally, so pasted line breaks do not submit the input
+func (e *lineEditor) paste() error {
+	for {
+		k, err := e.readKey()
+		if err != nil {
+			return err
+		}
+		switch k.code {
+		case keyPasteEnd:
+			return nil
+		case keyRune:
+			e.insert(k.r)
+		case keyEnter, keyNewline:
+			e.insert('\n')
+		case keyTab:
+			e.insert('\t')
+		}
+	}
+}
+
+// readKey decodes the next keypress, including the escape sequences terminals send for special keys
+func (e *lineEditor) readKey() (key, error) {
+	r, _, err := e.in.ReadRune()
+	if err != nil {
+		return key{}, err
+	}
+	switch r {
+	case '\r':
+		return key{code: keyEnter}, nil
+	case '\n':
+		return key{code: keyNewline}, nil
+	case 0x7f, 0x08:
+		return key{code: keyBackspace}, nil
+	case 0x01:
+		return key{code: keyHome}, nil
+	case 0x02:
+		return key{code: keyLeft}, nil
+	case 0x03:
+		return key{code: keyInterrupt}, nil
+	case 0x04:
+		return key{code: keyEOF}, nil
+	case 0x05:
+		return key{code: keyEnd}, nil
+	case 0x06:
+		return key{code: keyRight}, nil
+	case 0x07:
+		return key{code: keyCancel}, nil
+	case '\t':
+		return key{code: keyTab}, nil
+	case 0x0b:
+		return key{code: keyKillToEnd}, nil
+	case 0x0c:
+		return key{code: keyClearScreen}, nil
+	case 0x0e:
+		return key{code: keyDown}, nil
+	case 0x10:
+		return key{code: keyUp}, nil
+	case 0x12:
+		return key{code: keySearch}, nil
+	case 0x15:
+		return key{code: keyKillToStart}, nil
+	case 0x17:
+		return key{code: keyDeleteWordBack}, nil
+	case 0x1b:
+		return e.readEscape()
+	}
+	if r < 0x20 {
+		return key{code: keyUnknown}, nil
+	}
+	return key{code: keyRune, r: r}, nil
+}
+
+// readEscape decodes the rest of an escape sequence. A lone Escape is reported when nothing follows
+// it in the same read.
+func (e *lineEditor) readEscape() (key, error) {
+	if e.in.Buffered() == 0 {
+		return key{code: keyEscape}, nil
+	}
+	r, _, err := e.in.ReadRune()
+	if err != nil {
+		return key{}, err
+	}
+	switch r {
+	case '\r', '\n':
+		return key{code: keyNewline}, nil
+	case 0x7f, 0x08:
+		return key{code: keyDeleteWordPart}, nil
+	case 'b':
+		return key{code: keyWordLeft}, nil
+	case 'f':
+		return key{code: keyWordRight}, nil
+	case 'd':
+		return key{code: keyDeleteWordForward}, nil
+	case 'O':
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return key{}, err
+		}
+		return key{code: map[rune]keyCode{'A': keyUp, 'B': keyDown, 'C': keyRight, 'D': keyLeft, 'H': keyHome, 'F': keyEnd}[r]}, nil
+	case '[':
+	default:
+		return key{code: keyUnknown}, nil
+	}
+
+	// Control sequence: parameter bytes followed by a final byte
+	var params strings.Builder
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return key{}, err
+		}
+		if r >= 0x40 && r <= 0x7e {
+			return csiKey(params.String(), r), nil
+		}
+		params.WriteRune(r)
+	}
+}
+
+// csiKey maps a control sequence to a key
+func csiKey(params string, final rune) key {
+	ctrl := strings.HasSuffix(params, ";5") || strings.HasSuffix(params, ";3") // Ctrl or Alt modifier
+	switch final {
+	case 'A':
+		return key{code: keyUp}
+	case 'B':
+		return key{code: keyDown}
+	case 'C':
+		if ctrl {
+			return key{code: keyWordRight}
+		}
+		return key{code: keyRight}
+	case 'D':
+		if ctrl {
+			return key{code: keyWordLeft}
+		}
+		return key{code: keyLeft}
+	case 'H':
+		return key{code: keyHome}
+	case 'F':
+		return key{code: keyEnd}
+	case 'u':
+		// Keys reported with the kitty keyboard protocol, e.g. Shift-Enter as 13;2u
+		if strings.HasPrefix(params, "13;") {
+			return key{code: keyNewline}
+		}
+	case '~':
+		switch params {
+		case "1", "7":
+			return key{code: keyHome}
+		case "4", "8":
+			return key{code: keyEnd}
+		case "3":
+			return key{code: keyDelete}
+		case "200":
+			return key{code: keyPasteStart}
+		case "201":
+			return key{code: keyPasteEnd}
+		case "27;2;13", "27;3;13", "27;5;13":
+			// Modified Enter reported by xterm's modifyOtherKeys
+			return key{code: keyNewline}
+		}
+	}
+	return key{code: keyUnknown}
+}
+
+// refresh redraws the prompt and input and places the cursor
+func (e *lineEditor) refresh() {
+	prompt, text, cursor := e.prompt, e.buf, e.pos
+	if e.searching {
+		prompt = fmt.Sprintf("(reverse-i-search)`%s': ", string(e.query))
+		text, cursor = nil, 0
+		if e.match >= 0 {
+			entry := e.history.entries[e.match]
+			text = []rune(entry)
+			cursor = len([]rune(entry[:max(strings.Index(entry, string(e.query)), 0)]))
+		}
+	}
+	e.draw(prompt, text, cursor)
+}
+
+// moveToEnd moves the terminal cursor past the end of the input
+func (e *lineEditor) moveToEnd() {
+	e.pos = len(e.buf)
+	e.refresh()
+}
+
+// finish leaves the cursor on a new line after the input
+func (e *lineEditor) finish() {
+	e.searching = false
+	e.moveToEnd()
+	fmt.Fprint(e.out, "\r\n")
+	e.cursorRow = 0
+}
+
+// draw renders prompt and text from the start of the prompt's row and puts the cursor at index cursor
+func (e *lineEditor) draw(prompt string, text []rune, cursor int) {
+	width := e.width()
+	if width <= 0 {
+		width = 80
+	}
+	var out strings.Builder
+	if e.cursorRow > 0 {
+		fmt.Fprintf(&out, "\x1b[%dA", e.cursorRow)
+	}
+	out.WriteString("\r\x1b[J")
+	out.WriteString(prompt)
+	out.WriteString(strings.ReplaceAll(string(text), "\n", "\r\n"+continuationPrompt))
+
+	layout := newTextLayout(len([]rune(ansiEscapePattern.ReplaceAllString(prompt, ""))), width)
+	endRow, endCol := layout.position(text, len(text))
+	if endCol == 0 && endRow > 0 {
+		out.WriteString("\r\n") // leave the pending wrap at the right margin
+	}
+	row, col := layout.position(text, cursor)
+	if endRow > row {
+		fmt.Fprintf(&out, "\x1b[%dA", endRow-row)
+	}
+	out.WriteString("\r")
+	if col > 0 {
+		fmt.Fprintf(&out, "\x1b[%dC", col)
+	}
+	e.cursorRow = row
+	fmt.Fprint(e.out, out.String())
+}
+
+// textLayout computes where text is drawn after a prompt on a terminal of the given width
+type textLayout struct {
+	promptWidth int
+	width       int
+}
+
+func newTextLayout(promptWidth, width int) textLayout {
+	return textLayout{promptWidth: promptWidth, width: width}
+}
+
+// position returns the row and column at which rune index i of text is drawn
+func (l textLayout) position(text []rune, i int) (row, col int) {
+	row, col = l.promptWidth/l.width, l.promptWidth%l.width
+	for _, r := range text[:i] {
+		if r == '\n' {
+			row, col = row+1, len(continuationPrompt)
+			continue
+		}
+		col++
+		if col == l.width {
+			row, col = row+1, 0
+		}
+	}
+	return row, col
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// editKeys runs the line editor over the given terminal input and returns the lines it read
+func editKeys(t *testing.T, history *lineHistory, input string, lines int) []string {
+	t.Helper()
+	var out bytes.Buffer
+	editor := newLineEditor(strings.NewReader(input), &out, func() int { return 80 }, history)
+	var result []string
+	for i := 0; i < lines; i++ {
+		line, ok := editor.readLine("> ")
+		require.True(t, ok, "line %d", i)
+		result = append(result, line)
+	}
+	return result
+}
+
+func TestLineEditor_CursorMovement(t *testing.T) {
+	tests := map[string]string{
+		"helo\x1b[Dl\r":             "hello",       // left arrow, insert
+		"world\x01hello \r":         "hello world", // Ctrl-A
+		"abc\x02\x02\x06\x06X\r":    "abcX",        // Ctrl-B, Ctrl-F
+		"abc\x1b[H1\x1b[F2\r":       "1abc2",       // Home, End
+		"abc\x1b[D\x1b[3~\r":        "ab",          // Delete
+		"abcd\x1b[D\x1b[D\x7f\r":    "acd",         // Backspace mid-line
+		"one two\x1b[1;5DX\r":       "one Xtwo",    // Ctrl-Left
+		"one two\x1bb\x1bb\x1bfX\r": "oneX two",    // Alt-b, Alt-f
+		"café olé\x1b[D\x1b[Dx\r":   "café oxlé",
+	}
+	for input, want := range tests {
+		assert.Equal(t, []string{want}, editKeys(t, &lineHistory{}, input, 1), "%q", input)
+	}
+}
+
+func TestLineEditor_Deletion(t *testing.T) {
+	tests := map[string]string{
+		"git commit -m\x17\r":      "git commit ", // Ctrl-W
+		"path/to/file\x1b\x7f\r":   "path/to/",    // Alt-Backspace
+		"one two three\x01\x1bd\r": " two three",  // Alt-d
+		"one two\x1bb\x0b\r":       "one ",        // Ctrl-K
+		"one two\x1bb\x15\r":       "two",         // Ctrl-U
+		"abc\x02\x04\r":            "ab",          // Ctrl-D deletes under the cursor
+	}
+	for input, want := range tests {
+		assert.Equal(t, []string{want}, editKeys(t, &lineHistory{}, input, 1), "%q", input)
+	}
+}
+
+func TestLineEditor_Multiline(t *testing.T) {
+	// Alt-Enter, Ctrl-J, kitty Shift-Enter and a trailing backslash all insert line breaks
+	assert.Equal(t, []string{"one\ntwo\nthree\nfour\nfive"},
+		editKeys(t, &lineHistory{}, "one\x1b\rtwo\nthree\x1b[13;2ufour\\\rfive\r", 1))
+
+	// Up and Down move between lines before reaching the history
+	assert.Equal(t, []string{"aXbc\nde"}, editKeys(t, &lineHistory{}, "abc\nde\x1b[A\x01\x1b[CX\r", 1))
+}
+
+func TestLineEditor_BracketedPaste(t *testing.T) {
+	lines := editKeys(t, &lineHistory{}, "Fix this:\x1b[200~line 1\rline 2\n\x1b[201~ please\r", 1)
+
+	assert.Equal(t, []string{"Fix this:line 1\nline 2\n please"}, lines)
+}
+
+func TestLineEditor_History(t *testing.T) {
+	history := &lineHistory{entries: []string{"first", "second"}}
+
+	lines := editKeys(t, history, "\x1b[A\x1b[A\rdraft\x1b[A\x1b[B!\r", 2)
+
+	assert.Equal(t, []string{"first", "draft!"}, lines)
+	assert.Equal(t, []string{"first", "second", "first", "draft!"}, history.entries)
+}
+
+func TestLineEditor_ReverseSearch(t *testing.T) {
+	history := &lineHistory{entries: []string{"git status", "go test ./...", "git diff main"}}
+
+	lines := editKeys(t, history,
+		"\x12git\r"+ // newest match
+			"\x12git\x12\r"+ // Ctrl-R again finds an older match
+			"\x12test\x1b[F -race\r"+ // End accepts the match for editing
+			"\x12diff\x07new\r", // Ctrl-G cancels
+		4)
+
+	assert.Equal(t, []string{"git diff main", "git status", "go test ./... -race", "new"}, lines)
+}
+
+func TestLineEditor_Interrupt(t *testing.T) {
+	var out bytes.Buffer
+	editor := newLineEditor(strings.NewReader("typo\x03fixed\r\x03\x03"), &out, func() int { return 80 }, &lineHistory{})
+	presses := 0
+	editor.interrupt = func() bool {
+		presses++
+		return presses == 3
+	}
+
+	line, ok := editor.readLine("> ")
+	assert.True(t, ok)
+	assert.Equal(t, "fixed", line)
+
+	// A second Ctrl-C ends input
+	_, ok = editor.readLine("> ")
+	assert.False(t, ok)
+}
+
+func TestLineEditor_EOF(t *testing.T) {
+	var out bytes.Buffer
+	editor := newLineEditor(strings.NewReader("\x04"), &out, func() int { return 80 }, &lineHistory{})
+
+	_, ok := editor.readLine("> ")
+	assert.False(t, ok)
+
+	editor = newLineEditor(strings.NewReader("unfinished"), &out, func() int { return 80 }, &lineHistory{})
+	_, ok = editor.readLine("> ")
+	assert.False(t, ok)
+}
+
+func TestLineEditor_TabCompletion(t *testing.T) {
+	var out bytes.Buffer
+	editor := newLineEditor(strings.NewReader("/he\t\r/h\t\t\r"), &out, func() int { return 80 }, &lineHistory{})
+	editor.complete = newCommandRegistry().complete
+
+	line, _ := editor.readLine("> ")
+	assert.Equal(t, "/help ", line)
+
+	out.Reset()
+	line, _ = editor.readLine("> ")
+	assert.Equal(t, "/h", line)
+	assert.Contains(t, out.String(), "/help  /history\r\n")
+}
+
+func TestLineEditor_TabCompletesPaths(t *testing.T) {
+	var out bytes.Buffer
+	editor := newLineEditor(strings.NewReader("read @p	m		"), &out, func() int { return 80 }, &lineHistory{})
+	editor.complete = func(line string) []string {
+		switch line {
+		case "read @p":
+			return []string{"read @pkg/"}
+		case "read @pkg/m":
+			return []string{"read @pkg/main.go", "read @pkg/model.go"}
+		}
+		return nil
+	}
+
+	line, _ := editor.readLine("> ")
+
+	// Directories complete without a trailing space and candidates are listed by their last word
+	assert.Equal(t, "read @pkg/m", line)
+	assert.Contains(t, out.String(), "\r\n@pkg/main.go  @pkg/model.go\r\n")
+}
+
+func TestLineEditor_WrapsLongLines(t *testing.T) {
+	var out bytes.Buffer
+	editor := newLineEditor(strings.NewReader("abcdefgh\x01\r"), &out, func() int { return 6 }, &lineHistory{})
+
+	_, ok := editor.readLine("> ")
+	require.True(t, ok)
+
+	// "> abcd" fills the first row, so Home moves up one row to column 2
+	assert.Contains(t, out.String(), "> abcdefgh\x1b[1A\r\x1b[2C")
+}
+
+func TestTextLayout_Position(t *testing.T) {
+	layout := newTextLayout(2, 10)
+	text := []rune("12345678\nabc")
+
+	row, col := layout.position(text, 7)
+	assert.Equal(t, []int{0, 9}, []int{row, col})
+	row, col = layout.position(text, 8)
+	assert.Equal(t, []int{1, 0}, []int{row, col})
+	row, col = layout.position(text, len(text))
+	assert.Equal(t, []int{2, len(continuationPrompt) + 3}, []int{row, col})
+}
+
+func TestLineHistory_Persists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent", historyFileName)
+	history := loadLineHistory(path)
+
+	history.add("first")
+	history.add("first")
+	history.add("  ")
+	history.add("two\nlines")
+
+	assert.Equal(t, []string{"first", "two\nlines"}, loadLineHistory(path).entries)
+}
+
+func TestLineHistory_Trims(t *testing.T) {
+	path := filepath.Join(t.TempDir(), historyFileName)
+	var data strings.Builder
+	for i := 0; i < maxHistoryEntries+5; i++ {
+		data.WriteString(`"entry"` + "\n")
+	}
+	require.NoError(t, os.WriteFile(path, []byte(data.String()), 0600))
+
+	history := loadLineHistory(path)
+
+	assert.Len(t, history.entries, maxHistoryEntries)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, maxHistoryEntries, strings.Count(string(content), "\n"))
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// listDirMaxEntries is the number of entries shown per directory before the rest are collapsed
+	listDirMaxEntries = 50
+	// listDirMaxLineCountSize is the largest file whose lines are counted
+	listDirMaxLineCountSize = 1 << 20
+	// listDirIndent is the indentation used per tree level
+	listDirIndent = "  "
+)
+
+// renderDirListing renders a detailed listing of dirPath, marking directories with a trailing
+// slash, annotating files with their size and line count, and collapsing large directories.
+// Recursive listings are rendered as an indented tree.
+func renderDirListing(dirPath string, recursive bool) (string, error) {
+	var out strings.Builder
+	if err := renderDirLevel(&out, dirPath, "", recursive); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// renderDirLevel writes the entries of a single directory at the given indentation
+func renderDirLevel(out *strings.Builder, dirPath, indent string, recursive bool) error {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return err
+	}
+
+	shown := entries
+	if len(shown) > listDirMaxEntries {
+		shown = entries[:listDirMaxEntries]
+	}
+
+	for _, entry := range shown {
+		entryPath := filepath.Join(dirPath, entry.Name())
+		if entry.IsDir() {
+			if !recursive {
+				out.WriteString(fmt.Sprintf("%s%s/ (%s)\n", indent, entry.Name(), describeDirSize(entryPath)))
+				continue
+			}
+			out.WriteString(fmt.Sprintf("%s%s/\n", indent, entry.Name()))
+			if err := renderDirLevel(out, entryPath, indent+listDirIndent, recursive); err != nil {
+				out.WriteString(fmt.Sprintf("%s%s(unreadable: %v)\n", indent, listDirIndent, err))
+			}
+			continue
+		}
+		out.WriteString(fmt.Sprintf("%s%s (%s)\n", indent, entry.Name(), describeFile(entryPath, entry)))
+	}
+
+	if hidden := entries[len(shown):]; len(hidden) > 0 {
+		out.WriteString(fmt.Sprintf("%s… %s\n", indent, summarizeCollapsed(hidden)))
+	}
+	return nil
+}
+
+// describeDirSize returns a short entry count for a directory shown without its contents
+func describeDirSize(dirPath string) string {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return "unreadable"
+	}
+	return pluralize(len(entries), "entry", "entries")
+}
+
+// describeFile returns the size and, for text files, the line count of a file
+func describeFile(path string, entry os.DirEntry) string {
+	info, err := entry.Info()
+	if err != nil {
+		return "unreadable"
+	}
+	if !info.Mode().IsRegular() {
+		return info.Mode().Type().String()
+	}
+
+	size := formatSize(info.Size())
+	if info.Size() > listDirMaxLineCountSize {
+		return size
+	}
+
+	lines, isText := countLines(path)
+	if !isText {
+		return size + ", binary"
+	}
+	return size + ", " + pluralize(lines, "line", "lines")
+}
+
+// countLines counts the lines in a file, reporting false if the file looks binary
+func countLines(path string) (int, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, listDirMaxLineCountSize))
+	if err != nil {
+		return 0, false
+	}
+	if bytes.IndexByte(data, 0) != -1 {
+		return 0, false
+	}
+	if len(data) == 0 {
+		return 0, true
+	}
+
+	lines := bytes.Count(data, []byte("\n"))
+	if data[len(data)-1] != '\n' {
+		lines++
+	}
+	return lines, true
+}
+
+// summarizeCollapsed describes entries that were omitted from a listing
+func summarizeCollapsed(entries []os.DirEntry) string {
+	var files, dirs int
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs++
+		} else {
+			files++
+		}
+	}
+
+	switch {
+	case dirs == 0:
+		return fmt.Sprintf("%d more %s", files, pluralWord(files, "file", "files"))
+	case files == 0:
+		return fmt.Sprintf("%d more %s", dirs, pluralWord(dirs, "directory", "directories"))
+	default:
+		return fmt.Sprintf("%d more %s and %s", files, pluralWord(files, "file", "files"), pluralize(dirs, "directory", "directories"))
+	}
+}
+
+// formatSize renders a byte count in a human readable unit
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// truncateBytes cuts s to at most n bytes, backing off so no UTF-8 character is split
+func truncateBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+func pluralize(n int, singular, plural string) string {
+	return fmt.Sprintf("%d %s", n, pluralWord(n, singular, plural))
+}
+
+func pluralWord(n int, singular, plural string) string {
+	if n == 1 {
+		return singular
+	}
+	return plural
+}
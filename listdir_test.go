@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderDirListing_Flat(t *testing.T) {
+	listing, err := renderDirListing("testdata", false)
+	require.NoError(t, err)
+
+	assert.Contains(t, listing, "empty_file.txt (0 B, 0 lines)\n")
+	assert.Contains(t, listing, "sample.txt (87 B, 3 lines)\n")
+	assert.Contains(t, listing, "test_dir/ (1 entry)\n")
+	assert.NotContains(t, listing, "nested_file.txt")
+}
+
+func TestRenderDirListing_RecursiveTree(t *testing.T) {
+	listing, err := renderDirListing("testdata", true)
+	require.NoError(t, err)
+
+	assert.Contains(t, listing, "test_dir/\n")
+	assert.Contains(t, listing, listDirIndent+"nested_file.txt (62 B, 1 line)\n")
+}
+
+func TestRenderDirListing_CollapsesLargeDirectories(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < listDirMaxEntries+3; i++ {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%03d.txt", i)), []byte("x\n"), 0644))
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "zz_dir"), 0755))
+
+	listing, err := renderDirListing(dir, true)
+	require.NoError(t, err)
+
+	assert.Contains(t, listing, "file000.txt")
+	assert.NotContains(t, listing, fmt.Sprintf("file%03d.txt", listDirMaxEntries))
+	assert.Contains(t, listing, "… 3 more files and 1 directory\n")
+}
+
+func TestRenderDirListing_Binary(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "blob.bin"), []byte{0x00, 0x01, 0x02}, 0644))
+
+	listing, err := renderDirListing(dir, false)
+	require.NoError(t, err)
+	assert.Equal(t, "blob.bin (3 B, binary)\n", listing)
+}
+
+func TestFormatSize(t *testing.T) {
+	assert.Equal(t, "0 B", formatSize(0))
+	assert.Equal(t, "1023 B", formatSize(1023))
+	assert.Equal(t, "1.0 KB", formatSize(1024))
+	assert.Equal(t, "1.5 MB", formatSize(1536*1024))
+}
+
+func TestHandleListDir_Detailed(t *testing.T) {
+	agent := setupTestAgent()
+	agent.setupTools()
+
+	args, _ := json.Marshal(ListDirInput{Path: "testdata", Recursive: true, Detailed: true})
+	toolCall := ToolCall{
+		ID:        "test-call-detailed",
+		Name:      "list_dir",
+		Arguments: string(args),
+	}
+
+	response := agent.handleListDir(context.Background(), toolCall)
+
+	assert.Equal(t, RoleTool, response.Role)
+	assert.Contains(t, response.Content, "test_dir/\n")
+	assert.Contains(t, response.Content, "nested_file.txt (62 B, 1 line)")
+	assert.Equal(t, "test-call-detailed", response.ToolCallID)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// redacted replaces secrets in debug logs
+const redacted = "[REDACTED]"
+
+// setupLogging makes the default slog logger write diagnostics at the configured level to the log
+// file, or to stderr when there is none, away from the output meant for the user. With debug, the
+// level is debug and the log goes to a file under .agent/logs unless one is set. The returned
+// function closes the log file.
+func setupLogging(settings *Settings, cwd string) (func() error, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(settings.String("log.level"))); err != nil {
+		return nil, fmt.Errorf("log level %q: expected debug, info, warn or error", settings.String("log.level"))
+	}
+	path := settings.String("log.file")
+	if settings.Bool("log.debug") {
+		level = slog.LevelDebug
+		if path == "" {
+			dir := filepath.Join(projectConfigDir(cwd), "logs")
+			if err := ignoreDir(dir); err != nil {
+				return nil, err
+			}
+			path = filepath.Join(dir, "agent-"+time.Now().Format("20060102-150405")+".log")
+			// The log itself is no place to say where the log is
+			slog.New(slog.NewTextHandler(os.Stderr, nil)).Info("writing the debug log", "file", path)
+		}
+	}
+
+	closeLog := func() error { return nil }
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+	if path != "" {
+		if err := ensureParentDir(path); err != nil {
+			return nil, err
+		}
+		// Debug logs hold whole conversations, including the files the agent read
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+		closeLog = file.Close
+		handler = slog.NewJSONHandler(file, &slog.HandlerOptions{Level: level})
+	}
+
+	slog.SetDefault(slog.New(handler))
+	// SetDefault routes the log package through the handler too; fatal errors are for the user
+	log.SetOutput(os.Stderr)
+	log.SetFlags(log.LstdFlags)
+	return closeLog, nil
+}
+
+// ignoreDir creates dir with a .gitignore that keeps git from tracking anything in it, so debug logs
+// are not committed with the project
+func ignoreDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, ".gitignore")
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	return os.WriteFile(path, []byte("*\n"), 0644)
+}
+
+// logger returns the default logger annotated with the agent's session
+func (a *Agent) logger() *slog.Logger {
+	return slog.Default().With("session", a.sessionID)
+}
+
+// secretNamePattern matches the names of environment variables, headers and query parameters
+// that hold credentials
+var secretNamePattern = regexp.MustCompile(`(?i)(key|token|secret|password|passwd|authorization|cookie|credential)`)
+
+// secretValuePattern matches credentials by their well-known formats
+var secretValuePattern = regexp.MustCompile(`\b(sk-[A-Za-z0-9_\-]{16,}|gh[pousr]_[A-Za-z0-9]{20,}|xox[abpr]-[A-Za-z0-9\-]{10,}|AKIA[0-9A-Z]{16})\b`)
+
+// redactor removes credentials from debug logs: headers and query parameters with credential
+// names, known secret values and strings in well-known credential formats
+type redactor struct {
+	secrets []string
+}
+
+// newRedactor redacts the values of credential environment variables and the given secrets
+func newRedactor(secrets ...string) *redactor {
+	r := &redactor{}
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+		if secretNamePattern.MatchString(name) {
+			secrets = append(secrets, value)
+		}
+	}
+	for _, secret := range secrets {
+		// Short values are more likely settings than secrets, and would redact unrelated text
+		if len(secret) >= 8 {
+			r.secrets = append(r.secrets, secret)
+		}
+	}
+	// Longest first, so a secret containing another is redacted whole
+	sort.Slice(r.secrets, func(i, j int) bool { return len(r.secrets[i]) > len(r.secrets[j]) })
+	return r
+}
+
+func (r *redactor) redact(s string) string {
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	return secretValuePattern.ReplaceAllString(s, redacted)
+}
+
+// header returns the header with credential values redacted, one value per name
+func (r *redactor) header(h http.Header) map[string]string {
+	values := make(map[string]string, len(h))
+	for name, value := range h {
+		if secretNamePattern.MatchString(name) {
+			values[name] = redacted
+		} else {
+			values[name] = r.redact(strings.Join(value, ", "))
+		}
+	}
+	return values
+}
+
+// url returns the request URL with credential query parameters redacted
+func (r *redactor) url(req *http.Request) string {
+	u := *req.URL
+	query := u.Query()
+	for name := range query {
+		if secretNamePattern.MatchString(name) {
+			query.Set(name, redacted)
+		}
+	}
+	u.RawQuery = query.Encode()
+	u.User = nil
+	return r.redact(u.String())
+}
+
+// payload is a body as a log attribute, embedded as JSON when it is JSON
+func (r *redactor) payload(body []byte) any {
+	text := r.redact(string(body))
+	if json.Valid([]byte(text)) {
+		return json.RawMessage(text)
+	}
+	return text
+}
+
+// debugTransport logs the raw request and response of every model API call at debug level
+type debugTransport struct {
+	base     http.RoundTripper
+	redactor *redactor
+	calls    atomic.Int64
+}
+
+// newDebugHTTPClient returns an HTTP client for providers that logs their API calls, with the
+// given secrets, like configured API keys, redacted
+func newDebugHTTPClient(secrets ...string) *http.Client {
+	return &http.Client{Transport: &debugTransport{base: http.DefaultTransport, redactor: newRedactor(secrets...)}}
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	call := t.calls.Add(1)
+	logger := slog.Default().With("call", call)
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	logger.Debug("llm request", "method", req.Method, "url", t.redactor.url(req), "header", t.redactor.header(req.Header), "body", t.redactor.payload(body))
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		logger.Debug("llm request failed", "duration", time.Since(start), "error", err)
+		return nil, err
+	}
+	// Streamed responses are logged once read, so the caller still receives them as they arrive
+	resp.Body = &loggedBody{ReadCloser: resp.Body, log: func(body []byte) {
+		logger.Debug("llm response", "status", resp.StatusCode, "duration", time.Since(start), "header", t.redactor.header(resp.Header), "body", t.redactor.payload(body))
+	}}
+	return resp, nil
+}
+
+// loggedBody captures a response body and logs it at EOF or when closed, whichever comes first
+type loggedBody struct {
+	io.ReadCloser
+	buf  bytes.Buffer
+	log  func(body []byte)
+	once sync.Once
+}
+
+func (b *loggedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	if err == io.EOF {
+		b.once.Do(func() { b.log(b.buf.Bytes()) })
+	}
+	return n, err
+}
+
+func (b *loggedBody) Close() error {
+	b.once.Do(func() { b.log(b.buf.Bytes()) })
+	return b.ReadCloser.Close()
+}
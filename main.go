@@ -4,9 +4,11 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -15,16 +17,11 @@ import (
 	"syscall"
 	"time"
 
-	openai "github.com/sashabaranov/go-openai"
+	"golang.org/x/term"
 )
 
 const DEFAULT_MODEL = "anthropic/claude-sonnet-4"
 
-// OpenAIClient interface for mocking
-type OpenAIClient interface {
-	CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
-}
-
 // Tool input structures
 type ReadFileInput struct {
 	Path string `json:"path" jsonschema_description:"The relative path of a file in the working directory."`
@@ -33,6 +30,7 @@ type ReadFileInput struct {
 type ListDirInput struct {
 	Path      string `json:"path" jsonschema_description:"The relative path of a directory in the working directory."`
 	Recursive bool   `json:"recursive" jsonschema_description:"Whether to list the directory recursively"`
+	Detailed  bool   `json:"detailed" jsonschema_description:"Whether to mark directories, show file sizes and line counts, and render recursive listings as a tree"`
 }
 
 type WriteFileInput struct {
@@ -41,11 +39,12 @@ type WriteFileInput struct {
 }
 
 type RunAgentInput struct {
-	Task string `json:"task" jsonschema_description:"Description of the task for the agent to perform"`
+	Task      string `json:"task" jsonschema_description:"Description of the task for the agent to perform"`
+	AgentType string `json:"agent_type" jsonschema_description:"Optional agent type selecting the sub-agent's persona, tools and model"`
 }
 
 // Tool handler function type
-type ToolHandler func(toolCall openai.ToolCall) openai.ChatCompletionMessage
+type ToolHandler func(ctx context.Context, toolCall ToolCall) Message
 
 // InputManager handles user input with signal management
 type InputManager struct {
@@ -56,6 +55,9 @@ type InputManager struct {
 	shouldClear  chan bool
 	shouldExit   chan bool
 	cleanupOnce  sync.Once
+	prompt       string      // printed before reading each line of input
+	editor       *lineEditor // edits input when stdin is a terminal; nil reads plain lines
+	ctrlCMu      sync.Mutex  // guards lastCtrlC
 }
 
 // NewInputManager creates a new input manager with signal handling
@@ -66,6 +68,16 @@ func NewInputManager() *InputManager {
 		ctrlCPressed: make(chan bool, 1),
 		shouldClear:  make(chan bool, 1),
 		shouldExit:   make(chan bool, 1),
+		prompt:       "\u001b[94mYou\u001b[0m: ",
+	}
+
+	if term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd())) {
+		historyPath := ""
+		if dir := userConfigDir(); dir != "" {
+			historyPath = filepath.Join(dir, historyFileName)
+		}
+		im.editor = newLineEditor(os.Stdin, os.Stdout, terminalWidth, loadLineHistory(historyPath))
+		im.editor.interrupt = im.ctrlC
 	}
 
 	// Set up signal handling for Ctrl-C
@@ -77,27 +89,82 @@ func NewInputManager() *InputManager {
 	return im
 }
 
-// handleSignals processes Ctrl-C signals
+// handleSignals processes Ctrl-C signals until Cleanup, so turns can be interrupted after an exit
+// request was ignored. A request already pending is not repeated.
 func (im *InputManager) handleSignals() {
-	for {
-		<-im.sigChan
-		now := time.Now()
-
-		// Check if this is a double Ctrl-C (within 2 seconds)
-		if now.Sub(im.lastCtrlC) < 2*time.Second {
-			// Double Ctrl-C, exit
-			im.shouldExit <- true
-			return
+	for range im.sigChan {
+		request := im.shouldClear // Single Ctrl-C, clear input
+		if im.ctrlC() {
+			request = im.shouldExit // Double Ctrl-C, exit
+		}
+		select {
+		case request <- true:
+		default:
 		}
+	}
+}
+
+// exitRequested reports whether a double Ctrl-C asked to exit, without waiting for one
+func (im *InputManager) exitRequested() bool {
+	select {
+	case <-im.shouldExit:
+		return true
+	default:
+		return false
+	}
+}
+
+// ctrlC records a Ctrl-C press and reports whether it is the second within 2 seconds
+func (im *InputManager) ctrlC() bool {
+	im.ctrlCMu.Lock()
+	defer im.ctrlCMu.Unlock()
 
-		// Single Ctrl-C, clear input
-		im.lastCtrlC = now
-		im.shouldClear <- true
+	now := time.Now()
+	if now.Sub(im.lastCtrlC) < 2*time.Second {
+		return true
 	}
+	im.lastCtrlC = now
+	return false
 }
 
-// GetInput reads user input with Ctrl-C handling
+// GetInput shows the standard prompt and reads a line of input
 func (im *InputManager) GetInput() (string, bool) {
+	return im.ReadLine(im.prompt)
+}
+
+// ReadLine shows prompt and reads a line of input. On a terminal the line is edited in raw mode, with
+// Ctrl-C clearing the input and a second Ctrl-C within 2 seconds ending it.
+func (im *InputManager) ReadLine(prompt string) (string, bool) {
+	// A double Ctrl-C during a turn asks to exit; the editor sees no signals in raw mode
+	if im.exitRequested() {
+		return "", false
+	}
+	if im.editor != nil {
+		fd := int(os.Stdin.Fd())
+		if state, err := term.MakeRaw(fd); err == nil {
+			defer term.Restore(fd, state)
+			fmt.Print("\u001b[?2004h") // report pastes so pasted line breaks do not submit
+			defer fmt.Print("\u001b[?2004l")
+			line, ok := im.editor.readLine(prompt)
+			if im.exitRequested() {
+				return "", false
+			}
+			return line, ok
+		}
+	}
+	fmt.Print(prompt)
+	return im.readPlainLine(prompt)
+}
+
+// SetCompleter sets the function tab completion asks for candidates
+func (im *InputManager) SetCompleter(complete func(line string) []string) {
+	if im.editor != nil {
+		im.editor.complete = complete
+	}
+}
+
+// readPlainLine reads a line without editing, for input that is not a terminal
+func (im *InputManager) readPlainLine(prompt string) (string, bool) {
 	inputChan := make(chan string, 1)
 	errorChan := make(chan error, 1)
 
@@ -120,13 +187,33 @@ func (im *InputManager) GetInput() (string, bool) {
 	case <-im.shouldClear:
 		// Clear the current line and return empty string to retry
 		fmt.Print("\r\u001b[K") // Clear line
-		fmt.Print("\u001b[94mYou\u001b[0m: ")
-		return im.GetInput() // Recursive call for new input
+		fmt.Print(prompt)
+		return im.readPlainLine(prompt) // Recursive call for new input
 	case <-im.shouldExit:
 		return "", false
 	}
 }
 
+// CancelOnInterrupt calls cancel if Ctrl-C is pressed before the returned stop function is called
+func (im *InputManager) CancelOnInterrupt(cancel context.CancelFunc) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case <-im.shouldClear:
+			cancel()
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
 // Cleanup stops signal handling
 func (im *InputManager) Cleanup() {
 	im.cleanupOnce.Do(func() {
@@ -137,20 +224,45 @@ func (im *InputManager) Cleanup() {
 
 // Agent represents an AI assistant with tool capabilities
 type Agent struct {
-	client       OpenAIClient
-	inputManager *InputManager
-	tools        []openai.Tool
-	toolHandlers map[string]ToolHandler
-	model        string
+	provider          Provider
+	inputManager      *InputManager
+	tools             []ToolDefinition
+	toolHandlers      map[string]ToolHandler
+	model             string
+	workDir           string // directory tool paths and git commands are relative to; empty means the process working directory
+	changes           *changeTracker
+	sessionID         string
+	autoCommit        bool      // commit files modified by tools at the end of each turn
+	worktree          *worktree // isolated worktree the session runs in, if any
+	isolateSubAgents  bool      // run each sub-agent in its own worktree
+	depth             int       // nesting level; zero for the top-level agent
+	maxDepth          int       // deepest nesting level run_agent may create
+	budget            *agentBudget
+	usage             agentUsage
+	usageMu           sync.Mutex
+	agentTypes        map[string]AgentType // personas run_agent can select; nil means the built-ins
+	transcripts       *transcriptStore     // where sub-agent transcripts are saved; nil disables saving
+	failedToolCalls   idSet                // IDs of tool calls answered with an error
+	maxParallelAgents int                  // how many run_agents sub-agents run at once; zero means the default
+	claims            *fileClaims          // files claimed by the run_agents batch this agent belongs to, if any
+	claimOwner        int                  // this agent's task number within its run_agents batch
+	router            *modelRouter         // configured models /model and routing choose from; nil uses provider for every model
+	allowedTools      []string             // tools this agent and its sub-agents may use; empty allows all
+	commands          *commandRegistry     // slash commands available in Run; nil means the built-ins
+	markdown          *markdownRenderer    // renders assistant output in Run; nil prints it as written
+	events            func(agentEvent)     // receives the events of each turn; nil ignores them
+	approve           toolApprover         // decides whether tools that change the workspace may run; nil allows all
 }
 
 // NewAgent creates a new agent instance
-func NewAgent(client OpenAIClient, inputManager *InputManager, model string) *Agent {
+func NewAgent(provider Provider, inputManager *InputManager, model string) *Agent {
 	agent := &Agent{
-		client:       client,
+		provider:     provider,
 		inputManager: inputManager,
 		toolHandlers: make(map[string]ToolHandler),
 		model:        model,
+		changes:      newChangeTracker(),
+		sessionID:    newSessionID(),
 	}
 
 	agent.setupTools()
@@ -159,23 +271,43 @@ func NewAgent(client OpenAIClient, inputManager *InputManager, model string) *Ag
 
 // setupTools initializes all tools and their handlers
 func (a *Agent) setupTools() {
-	a.tools = []openai.Tool{
+	a.tools = []ToolDefinition{
 		a.createReadFileTool(),
 		a.createListDirTool(),
 		a.createWriteFileTool(),
+		a.createMoveFileTool(),
+		a.createCopyFileTool(),
+		a.createDeleteFileTool(),
+		a.createMakeDirTool(),
+		a.createGitStatusTool(),
+		a.createGitDiffTool(),
+		a.createGitLogTool(),
+		a.createGitBlameTool(),
+		a.createGitShowTool(),
 		a.createRunAgentTool(),
+		a.createRunAgentsTool(),
 	}
 
 	a.toolHandlers = map[string]ToolHandler{
 		"read_file":     a.handleReadFile,
 		"list_dir":      a.handleListDir,
 		"write_to_file": a.handleWriteFile,
+		"move_file":     a.handleMoveFile,
+		"copy_file":     a.handleCopyFile,
+		"delete_file":   a.handleDeleteFile,
+		"make_dir":      a.handleMakeDir,
+		"git_status":    a.handleGitStatus,
+		"git_diff":      a.handleGitDiff,
+		"git_log":       a.handleGitLog,
+		"git_blame":     a.handleGitBlame,
+		"git_show":      a.handleGitShow,
 		"run_agent":     a.handleRunAgent,
+		"run_agents":    a.handleRunAgents,
 	}
 }
 
 // Tool creation methods
-func (a *Agent) createReadFileTool() openai.Tool {
+func (a *Agent) createReadFileTool() ToolDefinition {
 	schema := map[string]any{
 		"type": "object",
 		"properties": map[string]any{
@@ -187,17 +319,14 @@ func (a *Agent) createReadFileTool() openai.Tool {
 		"required": []string{"path"},
 	}
 
-	return openai.Tool{
-		Type: openai.ToolTypeFunction,
-		Function: &openai.FunctionDefinition{
-			Name:        "read_file",
-			Description: "Read the contents of a given relative file path. Use this when you want to see what's inside a file. Do not use this with directory names.",
-			Parameters:  schema,
-		},
+	return ToolDefinition{
+		Name:        "read_file",
+		Description: "Read the contents of a given relative file path. Use this when you want to see what's inside a file. Do not use this with directory names.",
+		Parameters:  schema,
 	}
 }
 
-func (a *Agent) createListDirTool() openai.Tool {
+func (a *Agent) createListDirTool() ToolDefinition {
 	schema := map[string]any{
 		"type": "object",
 		"properties": map[string]any{
@@ -209,21 +338,22 @@ func (a *Agent) createListDirTool() openai.Tool {
 				"type":        "boolean",
 				"description": "Whether to list the directory recursively",
 			},
+			"detailed": map[string]any{
+				"type":        "boolean",
+				"description": "Whether to mark directories, show file sizes and line counts, and render recursive listings as a tree",
+			},
 		},
 		"required": []string{"path"},
 	}
 
-	return openai.Tool{
-		Type: openai.ToolTypeFunction,
-		Function: &openai.FunctionDefinition{
-			Name:        "list_dir",
-			Description: "List the contents of a given relative directory path.",
-			Parameters:  schema,
-		},
+	return ToolDefinition{
+		Name:        "list_dir",
+		Description: "List the contents of a given relative directory path. Set detailed to see which entries are directories, along with file sizes and line counts.",
+		Parameters:  schema,
 	}
 }
 
-func (a *Agent) createWriteFileTool() openai.Tool {
+func (a *Agent) createWriteFileTool() ToolDefinition {
 	schema := map[string]any{
 		"type": "object",
 		"properties": map[string]any{
@@ -239,17 +369,14 @@ func (a *Agent) createWriteFileTool() openai.Tool {
 		"required": []string{"path", "content"},
 	}
 
-	return openai.Tool{
-		Type: openai.ToolTypeFunction,
-		Function: &openai.FunctionDefinition{
-			Name:        "write_to_file",
-			Description: "Write content to a file, overwriting it if it exists.",
-			Parameters:  schema,
-		},
+	return ToolDefinition{
+		Name:        "write_to_file",
+		Description: "Write content to a file, overwriting it if it exists.",
+		Parameters:  schema,
 	}
 }
 
-func (a *Agent) createRunAgentTool() openai.Tool {
+func (a *Agent) createRunAgentTool() ToolDefinition {
 	schema := map[string]any{
 		"type": "object",
 		"properties": map[string]any{
@@ -257,50 +384,78 @@ func (a *Agent) createRunAgentTool() openai.Tool {
 				"type":        "string",
 				"description": "Description of the task for the agent to perform",
 			},
+			"agent_type": map[string]any{
+				"type":        "string",
+				"description": "Optional agent type selecting the sub-agent's persona, tools and model",
+				"enum":        agentTypeNames(a.availableAgentTypes()),
+			},
 		},
 		"required": []string{"task"},
 	}
 
-	return openai.Tool{
-		Type: openai.ToolTypeFunction,
-		Function: &openai.FunctionDefinition{
-			Name:        "run_agent",
-			Description: "Run a new agent instance to handle tasks involving reading and writing files. Use this when you need to perform complex file operations or when the task involves multiple file manipulations that would benefit from a fresh agent context.",
-			Parameters:  schema,
-		},
+	var types strings.Builder
+	for _, name := range agentTypeNames(a.availableAgentTypes()) {
+		types.WriteString(fmt.Sprintf("\n- %s: %s", name, a.availableAgentTypes()[name].Description))
+	}
+
+	return ToolDefinition{
+		Name:        "run_agent",
+		Description: "Run a new agent instance to handle tasks involving reading and writing files. Use this when you need to perform complex file operations or when the task involves multiple file manipulations that would benefit from a fresh agent context. Without agent_type the sub-agent has every tool; available agent types:" + types.String(),
+		Parameters:  schema,
 	}
 }
 
 // Tool handler methods
-func (a *Agent) handleReadFile(toolCall openai.ToolCall) openai.ChatCompletionMessage {
+func (a *Agent) handleReadFile(ctx context.Context, toolCall ToolCall) Message {
 	var input ReadFileInput
-	if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &input); err != nil {
+	if err := json.Unmarshal([]byte(toolCall.Arguments), &input); err != nil {
 		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Invalid arguments: %v", err))
 	}
 
-	content, err := os.ReadFile(input.Path)
+	path, err := a.workspacePath(input.Path)
+	if err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error reading file: %v", err))
+	}
+	content, err := os.ReadFile(path)
 	if err != nil {
 		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error reading file: %v", err))
 	}
 
-	return openai.ChatCompletionMessage{
-		Role:       openai.ChatMessageRoleTool,
+	return Message{
+		Role:       RoleTool,
 		Content:    string(content),
 		ToolCallID: toolCall.ID,
 	}
 }
 
-func (a *Agent) handleListDir(toolCall openai.ToolCall) openai.ChatCompletionMessage {
+func (a *Agent) handleListDir(ctx context.Context, toolCall ToolCall) Message {
 	var input ListDirInput
-	if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &input); err != nil {
+	if err := json.Unmarshal([]byte(toolCall.Arguments), &input); err != nil {
 		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Invalid arguments: %v", err))
 	}
 
+	path, err := a.workspacePath(input.Path)
+	if err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error reading directory: %v", err))
+	}
+
+	if input.Detailed {
+		listing, err := renderDirListing(path, input.Recursive)
+		if err != nil {
+			return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error reading directory: %v", err))
+		}
+		return Message{
+			Role:       RoleTool,
+			Content:    listing,
+			ToolCallID: toolCall.ID,
+		}
+	}
+
 	if input.Recursive {
-		return a.handleRecursiveListDir(toolCall.ID, input.Path)
+		return a.handleRecursiveListDir(toolCall.ID, path)
 	}
 
-	files, err := os.ReadDir(input.Path)
+	files, err := os.ReadDir(path)
 	if err != nil {
 		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error reading directory: %v", err))
 	}
@@ -310,14 +465,14 @@ func (a *Agent) handleListDir(toolCall openai.ToolCall) openai.ChatCompletionMes
 		fileList.WriteString(file.Name() + "\n")
 	}
 
-	return openai.ChatCompletionMessage{
-		Role:       openai.ChatMessageRoleTool,
+	return Message{
+		Role:       RoleTool,
 		Content:    fileList.String(),
 		ToolCallID: toolCall.ID,
 	}
 }
 
-func (a *Agent) handleRecursiveListDir(toolCallID, dirPath string) openai.ChatCompletionMessage {
+func (a *Agent) handleRecursiveListDir(toolCallID, dirPath string) Message {
 	var fileList strings.Builder
 
 	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
@@ -335,44 +490,72 @@ func (a *Agent) handleRecursiveListDir(toolCallID, dirPath string) openai.ChatCo
 		return a.createErrorResponse(toolCallID, fmt.Sprintf("Error walking directory: %v", err))
 	}
 
-	return openai.ChatCompletionMessage{
-		Role:       openai.ChatMessageRoleTool,
+	return Message{
+		Role:       RoleTool,
 		Content:    fileList.String(),
 		ToolCallID: toolCallID,
 	}
 }
 
-func (a *Agent) handleWriteFile(toolCall openai.ToolCall) openai.ChatCompletionMessage {
+func (a *Agent) handleWriteFile(ctx context.Context, toolCall ToolCall) Message {
 	var input WriteFileInput
-	if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &input); err != nil {
+	if err := json.Unmarshal([]byte(toolCall.Arguments), &input); err != nil {
 		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Invalid arguments: %v", err))
 	}
 
+	path, err := a.workspacePath(input.Path)
+	if err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error writing file: %v", err))
+	}
+	if err := a.claimPaths(input.Path); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error writing file: %v", err))
+	}
+
 	// Ensure directory exists
-	dir := filepath.Dir(input.Path)
-	if dir != "." {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error creating directory: %v", err))
-		}
+	if err := ensureParentDir(path); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error creating directory: %v", err))
 	}
 
-	err := os.WriteFile(input.Path, []byte(input.Content), 0644)
+	err = os.WriteFile(path, []byte(input.Content), 0644)
 	if err != nil {
 		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error writing file: %v", err))
 	}
+	a.recordChange(input.Path)
 
-	return openai.ChatCompletionMessage{
-		Role:       openai.ChatMessageRoleTool,
+	return Message{
+		Role:       RoleTool,
 		Content:    "File written successfully.",
 		ToolCallID: toolCall.ID,
 	}
 }
 
+// logTurn prints the progress of a turn in Run
+func logTurn(format string, args ...any) {
+	fmt.Printf(format+"\n", args...)
+}
+
+// printAssistant prints an assistant message in Run, rendering its markdown when enabled
+func (a *Agent) printAssistant(content string) {
+	if a.markdown == nil {
+		fmt.Printf("Assistant: %s\n", content)
+		return
+	}
+	fmt.Println("Assistant:")
+	w := newMarkdownWriter(os.Stdout, a.markdown)
+	fmt.Fprint(w, content)
+	w.Flush()
+}
+
 // DriveConversation runs the assistant-tool loop until no tool calls are returned or a safety iteration cap is reached.
 // It appends all generated messages to the provided slice and returns the updated slice.
-func (a *Agent) DriveConversation(ctx context.Context, messages []openai.ChatCompletionMessage, logf func(format string, args ...any)) ([]openai.ChatCompletionMessage, error) {
+// assistant receives the assistant's replies; when it is nil they go to logf like the tool calls.
+func (a *Agent) DriveConversation(ctx context.Context, messages []Message, logf func(format string, args ...any), assistant func(content string)) ([]Message, error) {
 	const maxIterations = 10
 	for i := 0; i < maxIterations; i++ {
+		if err := ctx.Err(); err != nil {
+			return messages, err
+		}
+
 		assistantMsg, err := a.createChatCompletion(ctx, messages)
 		if err != nil {
 			return messages, err
@@ -380,18 +563,28 @@ func (a *Agent) DriveConversation(ctx context.Context, messages []openai.ChatCom
 
 		messages = append(messages, assistantMsg)
 
-		if assistantMsg.Content != "" && logf != nil {
-			logf("Assistant: %s", assistantMsg.Content)
+		if assistantMsg.Content != "" {
+			a.emit(agentEvent{Type: eventAssistantDelta, Content: assistantMsg.Content})
+			if assistant != nil {
+				assistant(assistantMsg.Content)
+			} else if logf != nil {
+				logf("Assistant: %s", assistantMsg.Content)
+			}
 		}
 
 		if len(assistantMsg.ToolCalls) > 0 {
-			toolResponses := a.processToolCalls(assistantMsg.ToolCalls)
+			if logf != nil {
+				for _, toolCall := range assistantMsg.ToolCalls {
+					logf("Tool call: %v", toolCall.Name)
+				}
+			}
+			toolResponses := a.processToolCalls(ctx, assistantMsg.ToolCalls)
 			messages = append(messages, toolResponses...)
 
 			if logf != nil {
 				for j, toolResponse := range toolResponses {
 					if j < len(assistantMsg.ToolCalls) {
-						logf("Tool used: %s", assistantMsg.ToolCalls[j].Function.Name)
+						logf("Tool used: %s", assistantMsg.ToolCalls[j].Name)
 					}
 					logf("Tool result: %s", toolResponse.Content)
 				}
@@ -403,101 +596,114 @@ func (a *Agent) DriveConversation(ctx context.Context, messages []openai.ChatCom
 	return messages, nil
 }
 
-func (a *Agent) handleRunAgent(toolCall openai.ToolCall) openai.ChatCompletionMessage {
+func (a *Agent) handleRunAgent(ctx context.Context, toolCall ToolCall) Message {
 	var input RunAgentInput
-	if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &input); err != nil {
+	if err := json.Unmarshal([]byte(toolCall.Arguments), &input); err != nil {
 		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Invalid arguments: %v", err))
 	}
 
-	// Create a new agent instance with the same client and model
-	newAgent := &Agent{
-		client:       a.client,
-		inputManager: nil, // No input manager needed for programmatic execution
-		toolHandlers: make(map[string]ToolHandler),
-		model:        a.model,
+	run, err := a.runSubAgent(ctx, input, nil)
+	if err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error in agent execution: %v", err))
 	}
-	newAgent.setupTools()
 
-	// Create initial conversation with the task
-	messages := []openai.ChatCompletionMessage{
-		{
-			Role:    openai.ChatMessageRoleUser,
-			Content: input.Task,
-		},
+	content := run.output
+	if run.worktree != nil {
+		content += "\n" + a.finishSubAgentWorktree(run.worktree, input.Task, run.err == nil) + "\n"
 	}
-
-	var output strings.Builder
-	output.WriteString(fmt.Sprintf("Agent task: %s\n\n", input.Task))
-
-	_, err := newAgent.DriveConversation(context.Background(), messages, func(format string, args ...any) {
-		output.WriteString(fmt.Sprintf(format, args...))
-		output.WriteString("\n")
-	})
-	if err != nil {
-		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error in agent execution: %v", err))
+	if run.err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Error in agent execution: %v\n\n%s", run.err, content))
 	}
 
-	return openai.ChatCompletionMessage{
-		Role:       openai.ChatMessageRoleTool,
-		Content:    output.String(),
+	return Message{
+		Role:       RoleTool,
+		Content:    content,
 		ToolCallID: toolCall.ID,
 	}
 }
 
-func (a *Agent) createErrorResponse(toolCallID, errorMsg string) openai.ChatCompletionMessage {
-	return openai.ChatCompletionMessage{
-		Role:       openai.ChatMessageRoleTool,
+func (a *Agent) createErrorResponse(toolCallID, errorMsg string) Message {
+	a.failedToolCalls.add(toolCallID)
+	return Message{
+		Role:       RoleTool,
 		Content:    errorMsg,
 		ToolCallID: toolCallID,
+		IsError:    true,
 	}
 }
 
 // processToolCalls handles all tool calls from the assistant
-func (a *Agent) processToolCalls(toolCalls []openai.ToolCall) []openai.ChatCompletionMessage {
-	var responses []openai.ChatCompletionMessage
+func (a *Agent) processToolCalls(ctx context.Context, toolCalls []ToolCall) []Message {
+	var responses []Message
 
 	for _, toolCall := range toolCalls {
-		if toolCall.Type == "function" {
-			fmt.Printf("Tool call: %v\n", toolCall.Function.Name)
+		a.logger().Info("tool call", "tool", toolCall.Name, "id", toolCall.ID)
+		call := toolCall
+		event := agentEvent{Type: eventToolCall, ToolCall: &call}
+		if a.events != nil {
+			event.Diff = a.toolCallDiff(toolCall)
+		}
+		a.emit(event)
 
-			if handler, exists := a.toolHandlers[toolCall.Function.Name]; exists {
-				response := handler(toolCall)
-				responses = append(responses, response)
+		var response Message
+		if handler, exists := a.toolHandlers[toolCall.Name]; exists {
+			if err := a.approveToolCall(ctx, toolCall); err != nil {
+				response = a.createErrorResponse(toolCall.ID, err.Error())
 			} else {
-				response := a.createErrorResponse(toolCall.ID, fmt.Sprintf("Unknown tool: %v", toolCall.Function.Name))
-				responses = append(responses, response)
+				response = handler(ctx, toolCall)
 			}
+		} else {
+			response = a.createErrorResponse(toolCall.ID, fmt.Sprintf("Unknown tool: %v", toolCall.Name))
 		}
+		a.logger().Debug("tool result", "tool", toolCall.Name, "id", toolCall.ID, "error", response.IsError, "bytes", len(response.Content))
+		a.emit(agentEvent{Type: eventToolResult, ToolCallID: toolCall.ID, Content: response.Content, IsError: response.IsError})
+		responses = append(responses, response)
 	}
 
 	return responses
 }
 
 // createChatCompletion makes a request to the AI model
-func (a *Agent) createChatCompletion(ctx context.Context, messages []openai.ChatCompletionMessage) (openai.ChatCompletionMessage, error) {
-	resp, err := a.client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+func (a *Agent) createChatCompletion(ctx context.Context, messages []Message) (Message, error) {
+	if err := a.budget.reserve(); err != nil {
+		return Message{}, err
+	}
+
+	start := time.Now()
+	resp, err := a.provider.Chat(ctx, ChatRequest{
 		Model:    a.model,
 		Messages: messages,
 		Tools:    a.tools,
 	})
 	if err != nil {
-		return openai.ChatCompletionMessage{}, err
+		a.logger().Info("model call failed", "model", a.model, "duration", time.Since(start), "error", err)
+		return Message{}, err
 	}
+	a.logger().Debug("model call", "model", a.model, "duration", time.Since(start), "messages", len(messages),
+		"prompt_tokens", resp.Usage.PromptTokens, "completion_tokens", resp.Usage.CompletionTokens, "tool_calls", len(resp.Message.ToolCalls))
+
+	a.budget.charge(resp.Usage.PromptTokens, resp.Usage.CompletionTokens)
+	a.addUsage(agentUsage{Iterations: 1, PromptTokens: resp.Usage.PromptTokens, CompletionTokens: resp.Usage.CompletionTokens})
 
-	return resp.Choices[0].Message, nil
+	return resp.Message, nil
 }
 
 // Run starts the main conversation loop
 func (a *Agent) Run(ctx context.Context) error {
-	var messages []openai.ChatCompletionMessage
+	session := &replSession{agent: a, out: os.Stdout}
+	if a.commands == nil {
+		a.commands = newCommandRegistry()
+	}
+	a.inputManager.SetCompleter(a.completeInput)
 
-	fmt.Printf("Chat with %v (single ctrl-c to clear input, double ctrl-c to quit)\n", a.model)
+	fmt.Printf("Chat with %v (single ctrl-c to clear input, double ctrl-c to quit, /help for commands)\n", a.model)
 
 	defer a.inputManager.Cleanup()
+	defer a.closeWorktree()
 
-	for {
+	for !session.exit {
 		// Get user input
-		fmt.Print("\n\u001b[94mYou\u001b[0m: ")
+		fmt.Println()
 		userInput, ok := a.inputManager.GetInput()
 		if !ok {
 			fmt.Println() // Add newline before exiting
@@ -509,77 +715,260 @@ func (a *Agent) Run(ctx context.Context) error {
 			continue
 		}
 
+		if isCommand(userInput) {
+			prompt, err := a.commands.dispatch(ctx, session, userInput)
+			if err != nil {
+				fmt.Printf("%v\n", err)
+			}
+			if prompt == "" {
+				continue
+			}
+			userInput = prompt
+		}
+
+		// Attach the files referenced as @path
+		userInput, notes := a.expandMentions(ctx, userInput)
+		for _, note := range notes {
+			fmt.Println(note)
+		}
+
 		// Add user message to conversation
-		messages = append(messages, openai.ChatCompletionMessage{
-			Role:    openai.ChatMessageRoleUser,
+		session.messages = append(session.messages, Message{
+			Role:    RoleUser,
 			Content: userInput,
 		})
 
-		// Drive the conversation until the model is idle (no more tool calls)
+		// Drive the conversation until the model is idle (no more tool calls).
+		// Ctrl-C during the turn cancels it, including any running sub-agents.
+		a.budget.reset()
+		turnCtx, cancel := context.WithCancel(ctx)
+		stop := a.inputManager.CancelOnInterrupt(cancel)
 		var err error
-		messages, err = a.DriveConversation(ctx, messages, func(format string, args ...any) {
-			fmt.Printf(format+"\n", args...)
-		})
+		session.messages, err = a.DriveConversation(turnCtx, session.messages, logTurn, a.printAssistant)
+		stop()
+		cancel()
 		if err != nil {
-			return fmt.Errorf("error creating chat completion: %w", err)
+			if !errors.Is(err, errBudgetExhausted) && !(errors.Is(err, context.Canceled) && ctx.Err() == nil) {
+				return fmt.Errorf("error creating chat completion: %w", err)
+			}
+			fmt.Printf("Turn stopped: %v\n", err)
+		}
+
+		if a.autoCommit {
+			hash, err := a.commitTurn(ctx, session.messages)
+			if err != nil {
+				fmt.Printf("Auto-commit failed: %v\n", err)
+			} else if hash != "" {
+				fmt.Printf("Committed changes as %s\n", hash)
+			}
 		}
 	}
 
 	return nil
 }
 
-// setupClient creates and configures the OpenAI client
-func setupClient() (*openai.Client, error) {
-	apiKey := os.Getenv("LLM_KEY")
-	baseURL := os.Getenv("LLM_ENDPOINT")
+// Provider names accepted by --provider and LLM_PROVIDER
+const (
+	providerOpenAI    = "openai"
+	providerAnthropic = "anthropic"
+	providerOllama    = "ollama"
+)
 
-	if baseURL == "" {
-		return nil, fmt.Errorf("LLM_ENDPOINT environment variable is required")
+// defaultOllamaEndpoint is the OpenAI-compatible API of a local Ollama server
+const defaultOllamaEndpoint = "http://localhost:11434/v1"
+
+// setupProvider creates the model backend selected by the provider settings
+func setupProvider(settings *Settings, recorder *cassetteRecorder, httpClient *http.Client) (Provider, error) {
+	name := settings.String("provider")
+	config := ProviderConfig{Type: name, ToolMode: settings.String("tool_mode"), ThinkingBudget: settings.Int("thinking_budget"), recorder: recorder, httpClient: httpClient}
+	switch name {
+	case providerOpenAI, providerOllama:
+		config.Endpoint = settings.String("endpoint")
+		config.APIKey = os.Getenv("LLM_KEY")
+		if config.Endpoint == "" && name == providerOpenAI {
+			return nil, fmt.Errorf("an endpoint is required, set LLM_ENDPOINT or endpoint in config.yaml")
+		}
+	case providerAnthropic:
+		config.Endpoint = os.Getenv("ANTHROPIC_BASE_URL")
+		config.APIKey = os.Getenv("ANTHROPIC_API_KEY")
+		if config.APIKey == "" {
+			config.APIKey = os.Getenv("LLM_KEY")
+		}
+		if config.APIKey == "" {
+			return nil, fmt.Errorf("ANTHROPIC_API_KEY environment variable is required")
+		}
+	default:
+		return nil, fmt.Errorf("unknown provider %q, expected %s, %s or %s", name, providerOpenAI, providerAnthropic, providerOllama)
 	}
+	return buildProvider(config)
+}
 
-	config := openai.DefaultConfig(apiKey)
-	config.BaseURL = baseURL
+func main() {
+	// Parse CLI arguments
+	profileFlag := flag.String("profile", "", "Settings profile from config.yaml to use (overrides LLM_PROFILE env var)")
+	recordFlag := flag.String("record-cassette", "", "Record the chat completions of OpenAI-compatible providers to a cassette file for replay in tests")
+	stdioRPC := flag.Bool("stdio-rpc", false, "Serve editor integrations with JSON-RPC 2.0 on stdin and stdout instead of the terminal chat")
+	registerSettingFlags(flag.CommandLine)
+	flag.Parse()
 
-	return openai.NewClientWithConfig(config), nil
-}
+	// Editors read the protocol from stdout, so everything printed for a terminal goes to stderr
+	protocolOut := os.Stdout
+	if *stdioRPC {
+		os.Stdout = os.Stderr
+	}
 
-// getModel determines the model to use based on CLI args and environment variables
-// Priority: CLI argument > LLM_MODEL env var > DEFAULT_MODEL constant
-func getModel(cliModel *string) string {
-	// If CLI argument is provided and not empty, use it
-	if cliModel != nil && *cliModel != "" {
-		return *cliModel
+	cwd, _ := os.Getwd()
+	if err := loadDotEnv(cwd, userConfigDir()); err != nil {
+		log.Fatalf("loading .env: %v", err)
 	}
 
-	// Check environment variable
-	if envModel := os.Getenv("LLM_MODEL"); envModel != "" {
-		return envModel
+	profile := *profileFlag
+	if profile == "" {
+		profile = os.Getenv("LLM_PROFILE")
+	}
+	layers, err := loadSettingsLayers(projectConfigDir(cwd), userConfigDir(), profile)
+	if err != nil {
+		log.Fatalf("loading config: %v", err)
+	}
+	settings, err := resolveSettings(profile, layers, os.Getenv, setFlags(flag.CommandLine))
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	// Fall back to default
-	return DEFAULT_MODEL
-}
+	args := flag.Args()
+	serving := len(args) > 0 && args[0] == "serve"
+	evaluating := len(args) > 0 && args[0] == "eval"
+	if len(args) > 0 && !serving && !evaluating {
+		if len(args) == 2 && args[0] == "config" && args[1] == "show" {
+			fmt.Print(settings.format())
+			return
+		}
+		log.Fatalf("unknown command %q, expected config show, serve or eval", strings.Join(args, " "))
+	}
 
-func main() {
-	// Parse CLI arguments
-	modelFlag := flag.String("model", "", "AI model to use (overrides LLM_MODEL env var)")
-	flag.Parse()
+	closeLog, err := setupLogging(settings, cwd)
+	if err != nil {
+		log.Fatalf("setting up logging: %v", err)
+	}
+	defer closeLog()
+
+	config, err := loadConfig(projectConfigDir(cwd), userConfigDir())
+	if err != nil {
+		log.Fatalf("loading config: %v", err)
+	}
 
-	log.Printf("model flag %v", modelFlag)
 	// Determine which model to use
-	model := getModel(modelFlag)
+	model := settings.String("model")
+	providerName := settings.String("provider")
+	// A model chosen anywhere, even one named like the default, wins over routing and provider defaults
+	if settings.Source("model") == "default" {
+		switch {
+		case config.Routing[routeMain] != "":
+			model = config.Routing[routeMain]
+		case providerName == providerAnthropic:
+			model = defaultAnthropicModel // the default is an OpenRouter model name
+		case providerName == providerOllama:
+			log.Fatal("set --model or LLM_MODEL to a model available on the Ollama server")
+		}
+	}
 
-	// Setup client
-	client, err := setupClient()
+	// Setup provider from the settings; it serves models the config file does not define
+	var recorder *cassetteRecorder
+	if *recordFlag != "" {
+		if providerName == providerAnthropic {
+			log.Fatalf("--record-cassette can only record OpenAI-compatible providers, not %s", providerAnthropic)
+		}
+		recorder = newCassetteRecorder(*recordFlag)
+	}
+	var httpClient *http.Client
+	if settings.Bool("log.debug") {
+		var apiKeys []string
+		for _, provider := range config.Providers {
+			apiKeys = append(apiKeys, provider.APIKey)
+		}
+		httpClient = newDebugHTTPClient(apiKeys...)
+	}
+	provider, err := setupProvider(settings, recorder, httpClient)
 	if err != nil {
-		log.Fatal(err)
+		if len(config.Models) == 0 {
+			log.Fatal(err)
+		}
+		provider = nil // only configured models are available
 	}
 
-	// Create input manager
-	inputManager := NewInputManager()
+	// Create input manager; the server and editors send their input over their protocols, and
+	// evaluations take theirs from the task files
+	var inputManager *InputManager
+	if !serving && !evaluating && !*stdioRPC {
+		inputManager = NewInputManager()
+		if !settings.Bool("output.color") {
+			inputManager.prompt = "You: "
+		}
+	}
 
 	// Create agent with specified model
-	agent := NewAgent(client, inputManager, model)
+	agent := NewAgent(provider, inputManager, model)
+	agent.router = newModelRouter(config, provider)
+	agent.router.recorder = recorder
+	agent.router.httpClient = httpClient
+	if err := agent.useModel(model); err != nil {
+		log.Fatal(err)
+	}
+	agent.autoCommit = settings.Bool("permissions.auto_commit")
+	agent.isolateSubAgents = settings.Bool("permissions.subagent_worktrees")
+	agent.maxDepth = settings.Int("limits.max_agent_depth")
+	agent.maxParallelAgents = settings.Int("limits.max_parallel_agents")
+	// Render markdown only on a color terminal, so pipes and logs get the text as written
+	if settings.Bool("output.markdown") && settings.Bool("output.color") && os.Getenv("NO_COLOR") == "" && term.IsTerminal(int(os.Stdout.Fd())) {
+		agent.markdown = &markdownRenderer{width: terminalWidth}
+	}
+
+	agentTypes, err := loadAgentTypes(projectConfigDir(cwd), userConfigDir())
+	if err != nil {
+		log.Fatalf("loading agent types: %v", err)
+	}
+	agent.agentTypes = agentTypes
+	agent.setupTools() // refresh the agent types offered by run_agent
+	agent.allowedTools = settings.List("tools.enabled")
+	if err := agent.restrictTools(agent.allowedTools); err != nil {
+		log.Fatalf("tools.enabled: %v", err)
+	}
+	agent.commands = newCommandRegistry()
+	if err := agent.commands.loadCustomCommands(projectConfigDir(cwd), userConfigDir()); err != nil {
+		log.Fatalf("loading commands: %v", err)
+	}
+	agent.transcripts = newTranscriptStore(sessionTranscriptDir(agent.sessionID))
+	agent.budget = newAgentBudget(settings.Int("limits.max_turn_tokens"), settings.Int("limits.max_turn_iterations"))
+
+	if serving {
+		if err := serve(agent, cwd, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if evaluating {
+		if err := runEval(agent, cwd, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if settings.Bool("permissions.worktree") {
+		wt, err := createWorktree(context.Background(), "", "session-"+agent.sessionID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		agent.workDir = wt.path
+		agent.worktree = wt
+		fmt.Printf("Working in worktree %s on branch %s\n", wt.path, wt.branch)
+	}
+
+	if *stdioRPC {
+		if err := runStdioRPC(agent, protocolOut); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	// Run agent
 	if err := agent.Run(context.Background()); err != nil {
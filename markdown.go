@@ -0,0 +1,585 @@
+package main
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Terminal styles used for rendered markdown
+const (
+	styleReset     = "\u001b[0m"
+	styleBold      = "\u001b[1m"
+	styleDim       = "\u001b[2m"
+	styleItalic    = "\u001b[3m"
+	styleUnderline = "\u001b[4m"
+	styleStrike    = "\u001b[9m"
+	styleHeading1  = "\u001b[1;4;96m"
+	styleHeading2  = "\u001b[1;96m"
+	styleHeading   = "\u001b[1m"
+	styleCode      = "\u001b[93m"
+	styleKeyword   = "\u001b[95m"
+	styleString    = "\u001b[92m"
+	styleComment   = "\u001b[90m"
+	styleNumber    = "\u001b[96m"
+)
+
+// defaultMarkdownWidth is used when the terminal width is unknown
+const defaultMarkdownWidth = 80
+
+// markdownRenderer renders the markdown written by the model for display in a terminal
+type markdownRenderer struct {
+	width func() int // terminal width in columns
+}
+
+// render renders a complete markdown document
+func (r *markdownRenderer) render(text string) string {
+	var out strings.Builder
+	w := newMarkdownWriter(&out, r)
+	io.WriteString(w, text)
+	w.Flush()
+	return out.String()
+}
+
+func (r *markdownRenderer) columns() int {
+	if r.width == nil {
+		return defaultMarkdownWidth
+	}
+	if width := r.width(); width > 0 {
+		return width
+	}
+	return defaultMarkdownWidth
+}
+
+// markdownBlock is the kind of block a markdownWriter is collecting
+type markdownBlock int
+
+const (
+	blockNone markdownBlock = iota
+	blockParagraph
+	blockList
+	blockQuote
+	blockTable
+	blockCode
+)
+
+var (
+	headingPattern        = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	listItemPattern       = regexp.MustCompile(`^(\s*)([-*+]|\d{1,9}[.)])\s+(.*)$`)
+	tableSeparatorPattern = regexp.MustCompile(`^\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?$`)
+)
+
+// markdownWriter renders markdown as it is written, so streamed output is displayed a block at a
+// time: headings as soon as their line is complete, other blocks once they end. Flush renders
+// whatever is left at the end of the output.
+type markdownWriter struct {
+	out     io.Writer
+	r       *markdownRenderer
+	partial string // the incomplete last line
+	kind    markdownBlock
+	lines   []string // lines of the block being collected
+	fence   string   // fence that closes the code block being collected
+	lang    string   // language of the code block being collected
+	written bool     // whether a block was written, so the next is preceded by a blank line
+	err     error
+}
+
+func newMarkdownWriter(out io.Writer, r *markdownRenderer) *markdownWriter {
+	return &markdownWriter{out: out, r: r}
+}
+
+func (w *markdownWriter) Write(p []byte) (int, error) {
+	w.partial += string(p)
+	for {
+		i := strings.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimSuffix(w.partial[:i], "\r")
+		w.partial = w.partial[i+1:]
+		w.line(line)
+	}
+	return len(p), w.err
+}
+
+// Flush renders the incomplete line and block
+func (w *markdownWriter) Flush() error {
+	if w.partial != "" {
+		w.line(w.partial)
+		w.partial = ""
+	}
+	w.emit()
+	return w.err
+}
+
+// line adds a complete line to the document
+func (w *markdownWriter) line(line string) {
+	if w.kind == blockCode {
+		if strings.HasPrefix(strings.TrimSpace(line), w.fence) && strings.Trim(strings.TrimSpace(line), w.fence[:1]) == "" {
+			w.emit()
+			return
+		}
+		w.lines = append(w.lines, line)
+		return
+	}
+
+	trimmed := strings.TrimSpace(line)
+	switch {
+	case trimmed == "":
+		w.emit()
+	case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+		w.emit()
+		marker := trimmed[:1]
+		w.fence = trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, marker))]
+		w.lang = strings.ToLower(strings.TrimSpace(strings.TrimLeft(trimmed, marker)))
+		w.kind = blockCode
+	case headingPattern.MatchString(trimmed):
+		w.emit()
+		match := headingPattern.FindStringSubmatch(trimmed)
+		w.write(w.r.heading(len(match[1]), match[2]))
+	case isRule(trimmed):
+		w.emit()
+		w.write(styleDim + strings.Repeat("─", w.r.columns()) + styleReset + "\n")
+	case strings.HasPrefix(trimmed, "|"):
+		w.collect(blockTable, line)
+	case strings.HasPrefix(trimmed, ">"):
+		w.collect(blockQuote, line)
+	case listItemPattern.MatchString(line):
+		w.collect(blockList, line)
+	case w.kind == blockList && strings.HasPrefix(line, " "), w.kind == blockParagraph, w.kind == blockQuote:
+		// Continuation of an item, a paragraph or, lazily, a quote
+		w.lines = append(w.lines, line)
+	default:
+		w.collect(blockParagraph, line)
+	}
+}
+
+// isRule reports whether a line is a thematic break: three or more of the same -, * or _
+func isRule(line string) bool {
+	line = strings.ReplaceAll(line, " ", "")
+	return len(line) >= 3 && strings.Contains("-*_", line[:1]) && strings.Trim(line, line[:1]) == ""
+}
+
+// collect adds a line to a block of the given kind, ending any block of another kind
+func (w *markdownWriter) collect(kind markdownBlock, line string) {
+	if w.kind != kind {
+		w.emit()
+		w.kind = kind
+	}
+	w.lines = append(w.lines, line)
+}
+
+// emit renders the block being collected
+func (w *markdownWriter) emit() {
+	lines, kind := w.lines, w.kind
+	w.lines, w.kind = nil, blockNone
+	switch kind {
+	case blockParagraph:
+		w.write(w.r.paragraph(lines))
+	case blockList:
+		w.write(w.r.list(lines))
+	case blockQuote:
+		w.write(w.r.quote(lines))
+	case blockTable:
+		w.write(w.r.table(lines))
+	case blockCode:
+		w.write(w.r.code(w.lang, lines))
+	}
+}
+
+func (w *markdownWriter) write(rendered string) {
+	if w.written {
+		rendered = "\n" + rendered
+	}
+	w.written = true
+	if w.err == nil {
+		_, w.err = io.WriteString(w.out, rendered)
+	}
+}
+
+func (r *markdownRenderer) heading(level int, text string) string {
+	style := styleHeading
+	switch level {
+	case 1:
+		style = styleHeading1
+	case 2:
+		style = styleHeading2
+	}
+	return wrapText(style+renderInline(text, style)+styleReset, r.columns(), "", "")
+}
+
+func (r *markdownRenderer) paragraph(lines []string) string {
+	return wrapText(renderInline(joinLines(lines), ""), r.columns(), "", "")
+}
+
+func (r *markdownRenderer) quote(lines []string) string {
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		lines[i] = strings.TrimSpace(strings.TrimPrefix(line, ">"))
+	}
+	prefix := styleDim + "│ " + styleReset
+	return wrapText(renderInline(joinLines(lines), ""), r.columns(), prefix, prefix)
+}
+
+// list renders list items, indenting nested items and wrapping each under its marker
+func (r *markdownRenderer) list(lines []string) string {
+	type item struct {
+		indent int
+		marker string
+		text   []string
+	}
+	var items []*item
+	for _, line := range lines {
+		match := listItemPattern.FindStringSubmatch(line)
+		if match == nil {
+			items[len(items)-1].text = append(items[len(items)-1].text, line)
+			continue
+		}
+		items = append(items, &item{indent: len(strings.ReplaceAll(match[1], "\t", "    ")), marker: match[2], text: []string{match[3]}})
+	}
+
+	var out strings.Builder
+	base := items[0].indent
+	for _, it := range items {
+		depth := (it.indent - base) / 2
+		if depth < 0 {
+			depth = 0
+		}
+		marker := it.marker
+		if marker == "-" || marker == "*" || marker == "+" {
+			marker = "•"
+			if depth%2 == 1 {
+				marker = "◦"
+			}
+		}
+		text := joinLines(it.text)
+		switch {
+		case strings.HasPrefix(text, "[ ] "):
+			marker, text = "☐", text[4:]
+		case strings.HasPrefix(text, "[x] "), strings.HasPrefix(text, "[X] "):
+			marker, text = "☑", text[4:]
+		}
+		indent := strings.Repeat("  ", depth)
+		first := indent + marker + " "
+		out.WriteString(wrapText(renderInline(text, ""), r.columns(), first, strings.Repeat(" ", visibleWidth(first))))
+	}
+	return out.String()
+}
+
+// table renders a pipe table with aligned columns, falling back to the source when it is too wide
+func (r *markdownRenderer) table(lines []string) string {
+	var rows [][]string
+	var aligns []string
+	for i, line := range lines {
+		if i == 1 && tableSeparatorPattern.MatchString(strings.TrimSpace(line)) {
+			aligns = splitTableRow(line)
+			continue
+		}
+		base := ""
+		if i == 0 && len(lines) > 1 && tableSeparatorPattern.MatchString(strings.TrimSpace(lines[1])) {
+			base = styleBold
+		}
+		cells := splitTableRow(line)
+		for j, cell := range cells {
+			cells[j] = renderInline(cell, base)
+			if base != "" {
+				cells[j] = base + cells[j] + styleReset
+			}
+		}
+		rows = append(rows, cells)
+	}
+
+	columns := 0
+	for _, row := range rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	widths := make([]int, columns)
+	for _, row := range rows {
+		for j, cell := range row {
+			if w := visibleWidth(cell); w > widths[j] {
+				widths[j] = w
+			}
+		}
+	}
+	total := 3 * (columns - 1)
+	for _, w := range widths {
+		total += w
+	}
+	if total > r.columns() {
+		return strings.Join(lines, "\n") + "\n"
+	}
+
+	var out strings.Builder
+	separator := styleDim + " │ " + styleReset
+	for i, row := range rows {
+		for j := 0; j < columns; j++ {
+			cell, align := "", ""
+			if j < len(row) {
+				cell = row[j]
+			}
+			if j < len(aligns) {
+				align = aligns[j]
+			}
+			if j > 0 {
+				out.WriteString(separator)
+			}
+			out.WriteString(padCell(cell, widths[j], align, j == columns-1))
+		}
+		out.WriteString("\n")
+		if i == 0 && aligns != nil {
+			parts := make([]string, columns)
+			for j, w := range widths {
+				parts[j] = strings.Repeat("─", w)
+			}
+			out.WriteString(styleDim + strings.Join(parts, "─┼─") + styleReset + "\n")
+		}
+	}
+	return out.String()
+}
+
+// splitTableRow returns the trimmed cells of a table row
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimSuffix(strings.TrimPrefix(line, "|"), "|")
+	cells := strings.Split(line, "|")
+	for i, cell := range cells {
+		cells[i] = strings.TrimSpace(cell)
+	}
+	return cells
+}
+
+// padCell pads a cell to width according to its separator row alignment
+func padCell(cell string, width int, align string, last bool) string {
+	gap := width - visibleWidth(cell)
+	switch {
+	case strings.HasPrefix(align, ":") && strings.HasSuffix(align, ":"):
+		left := gap / 2
+		cell = strings.Repeat(" ", left) + cell
+		gap -= left
+	case strings.HasSuffix(align, ":"):
+		return strings.Repeat(" ", gap) + cell
+	}
+	if last {
+		return cell
+	}
+	return cell + strings.Repeat(" ", gap)
+}
+
+// code renders a code block indented and highlighted for its language. Code is never wrapped.
+func (r *markdownRenderer) code(lang string, lines []string) string {
+	var out strings.Builder
+	highlight := newHighlighter(lang)
+	for _, line := range lines {
+		out.WriteString("  " + highlight.line(strings.ReplaceAll(line, "\t", "    ")) + "\n")
+	}
+	return out.String()
+}
+
+// joinLines joins the lines of a paragraph into one line
+func joinLines(lines []string) string {
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.Join(lines, " ")
+}
+
+var (
+	linkPattern   = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	boldPattern   = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	italicPattern = regexp.MustCompile(`\*([^*\s][^*]*)\*|(^|[^\w])_([^_\s][^_]*)_([^\w]|$)`)
+	strikePattern = regexp.MustCompile(`~~([^~]+)~~`)
+)
+
+// renderInline styles code spans, links, bold, italic and strikethrough text. base is the style
+// of the surrounding text, restored after each styled span.
+func renderInline(text, base string) string {
+	end := styleReset + base
+	var out strings.Builder
+	for {
+		start := strings.IndexByte(text, '`')
+		if start < 0 {
+			break
+		}
+		close := strings.IndexByte(text[start+1:], '`')
+		if close < 0 {
+			break
+		}
+		out.WriteString(styleSpans(text[:start], end))
+		out.WriteString(styleCode + text[start+1:start+1+close] + end)
+		text = text[start+close+2:]
+	}
+	out.WriteString(styleSpans(text, end))
+	return out.String()
+}
+
+func styleSpans(text, end string) string {
+	text = linkPattern.ReplaceAllStringFunc(text, func(link string) string {
+		match := linkPattern.FindStringSubmatch(link)
+		if match[1] == match[2] {
+			return styleUnderline + match[2] + end
+		}
+		return styleUnderline + match[1] + end + styleDim + " (" + match[2] + ")" + end
+	})
+	text = boldPattern.ReplaceAllString(text, styleBold+"$1$2"+end)
+	text = italicPattern.ReplaceAllString(text, "$2"+styleItalic+"$1$3"+end+"$4")
+	return strikePattern.ReplaceAllString(text, styleStrike+"$1"+end)
+}
+
+// wrapText wraps styled text to width columns, starting the first line with first and the others
+// with rest
+func wrapText(text string, width int, first, rest string) string {
+	var out strings.Builder
+	prefix := first
+	line, lineWidth := "", 0
+	for _, word := range strings.Fields(text) {
+		w := visibleWidth(word)
+		if line != "" && visibleWidth(prefix)+lineWidth+1+w > width {
+			out.WriteString(prefix + line + "\n")
+			prefix, line, lineWidth = rest, "", 0
+		}
+		if line != "" {
+			line += " "
+			lineWidth++
+		}
+		line += word
+		lineWidth += w
+	}
+	out.WriteString(prefix + line + "\n")
+	return out.String()
+}
+
+func stripANSI(s string) string {
+	return ansiEscapePattern.ReplaceAllString(s, "")
+}
+
+// visibleWidth is the number of columns s takes on screen
+func visibleWidth(s string) int {
+	return len([]rune(stripANSI(s)))
+}
+
+// codeSyntax describes enough of a language to highlight it
+type codeSyntax struct {
+	keywords     map[string]bool
+	lineComment  string
+	blockComment bool // /* */ comments
+}
+
+func newCodeSyntax(lineComment string, blockComment bool, keywords string) *codeSyntax {
+	s := &codeSyntax{keywords: make(map[string]bool), lineComment: lineComment, blockComment: blockComment}
+	for _, keyword := range strings.Fields(keywords) {
+		s.keywords[keyword] = true
+	}
+	return s
+}
+
+var (
+	goSyntax = newCodeSyntax("//", true, "break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var "+
+		"nil true false iota any bool byte error int int64 float64 rune string uint")
+	pythonSyntax = newCodeSyntax("#", false, "and as assert async await break class continue def del elif else except finally for from global if import in is lambda nonlocal not or pass raise return try while with yield None True False self")
+	jsSyntax     = newCodeSyntax("//", true, "async await break case catch class const continue default delete do else export extends finally for from function if import in instanceof interface let new of return static super switch this throw try type typeof var void while yield null undefined true false")
+	shellSyntax  = newCodeSyntax("#", false, "if then else elif fi for while until do done case esac in function return export local echo cd exit")
+	rustSyntax   = newCodeSyntax("//", true, "as async await break const continue crate else enum fn for if impl in let loop match mod move mut pub ref return self Self static struct trait true false type unsafe use where while Some None Ok Err")
+	cSyntax      = newCodeSyntax("//", true, "break case char class const continue default do double else enum extern final float for if implements import int long new null package private protected public return short static struct switch this throw try typedef union unsigned void while true false")
+	dataSyntax   = newCodeSyntax("#", false, "true false null yes no")
+)
+
+// codeSyntaxes maps code block languages to their syntax
+var codeSyntaxes = map[string]*codeSyntax{
+	"go": goSyntax, "golang": goSyntax,
+	"python": pythonSyntax, "py": pythonSyntax,
+	"javascript": jsSyntax, "js": jsSyntax, "jsx": jsSyntax, "typescript": jsSyntax, "ts": jsSyntax, "tsx": jsSyntax,
+	"sh": shellSyntax, "bash": shellSyntax, "shell": shellSyntax, "zsh": shellSyntax, "console": shellSyntax,
+	"rust": rustSyntax, "rs": rustSyntax,
+	"c": cSyntax, "cpp": cSyntax, "c++": cSyntax, "h": cSyntax, "java": cSyntax, "kotlin": cSyntax, "cs": cSyntax, "csharp": cSyntax,
+	"json": dataSyntax, "yaml": dataSyntax, "yml": dataSyntax, "toml": dataSyntax,
+}
+
+// highlighter colors the lines of a code block, tracking block comments across lines
+type highlighter struct {
+	syntax    *codeSyntax
+	inComment bool
+}
+
+func newHighlighter(lang string) *highlighter {
+	if fields := strings.Fields(lang); len(fields) > 0 {
+		return &highlighter{syntax: codeSyntaxes[fields[0]]}
+	}
+	return &highlighter{}
+}
+
+// line highlights keywords, strings, numbers and comments in one line of code
+func (h *highlighter) line(line string) string {
+	if h.syntax == nil {
+		return line
+	}
+	var out strings.Builder
+	runes := []rune(line)
+	for i := 0; i < len(runes); {
+		rest := string(runes[i:])
+		switch c := runes[i]; {
+		case h.inComment:
+			end := strings.Index(rest, "*/")
+			if end < 0 {
+				out.WriteString(styleComment + rest + styleReset)
+				return out.String()
+			}
+			h.inComment = false
+			out.WriteString(styleComment + rest[:end+2] + styleReset)
+			i += len([]rune(rest[:end+2]))
+		case h.syntax.blockComment && strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest[2:], "*/")
+			if end < 0 {
+				h.inComment = true
+				out.WriteString(styleComment + rest + styleReset)
+				return out.String()
+			}
+			out.WriteString(styleComment + rest[:end+4] + styleReset)
+			i += len([]rune(rest[:end+4]))
+		case h.syntax.lineComment != "" && strings.HasPrefix(rest, h.syntax.lineComment) && (h.syntax.lineComment != "#" || i == 0 || unicode.IsSpace(runes[i-1])):
+			out.WriteString(styleComment + rest + styleReset)
+			return out.String()
+		case c == '"' || c == '\'' || c == '`':
+			j := i + 1
+			for j < len(runes) && runes[j] != c {
+				if runes[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(runes) {
+				j = len(runes) - 1
+			}
+			out.WriteString(styleString + string(runes[i:j+1]) + styleReset)
+			i = j + 1
+		case unicode.IsDigit(c) && (i == 0 || !isIdentRune(runes[i-1])):
+			j := i
+			for j < len(runes) && (isIdentRune(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			out.WriteString(styleNumber + string(runes[i:j]) + styleReset)
+			i = j
+		case isIdentRune(c):
+			j := i
+			for j < len(runes) && isIdentRune(runes[j]) {
+				j++
+			}
+			word := string(runes[i:j])
+			if h.syntax.keywords[word] {
+				word = styleKeyword + word + styleReset
+			}
+			out.WriteString(word)
+			i = j
+		default:
+			out.WriteRune(c)
+			i++
+		}
+	}
+	return out.String()
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
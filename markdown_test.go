@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func renderMarkdown(text string, width int) string {
+	r := &markdownRenderer{width: func() int { return width }}
+	return r.render(text)
+}
+
+func TestMarkdown_BlocksLayout(t *testing.T) {
+	text := "# Plan\n" +
+		"First we read the **config** loader and then we\nupdate the `parse` function so it wraps.\n\n" +
+		"- one\n- two\n  continued\n  - nested\n- [ ] todo\n- [x] done\n\n" +
+		"1. first\n2. second item that is long enough to wrap\n\n" +
+		"> quoted\n> text\n\n" +
+		"***\n" +
+		"## Done"
+
+	rendered := stripANSI(renderMarkdown(text, 30))
+
+	assert.Equal(t, "Plan\n\n"+
+		"First we read the config\nloader and then we update the\nparse function so it wraps.\n\n"+
+		"• one\n• two continued\n  ◦ nested\n☐ todo\n☑ done\n\n"+
+		"1. first\n2. second item that is long\n   enough to wrap\n\n"+
+		"│ quoted text\n\n"+
+		strings.Repeat("─", 30)+"\n\n"+
+		"Done\n", rendered)
+}
+
+func TestMarkdown_InlineStyles(t *testing.T) {
+	rendered := renderMarkdown("**bold** *italic* _under_ snake_case_name ~~gone~~ `a **b**` [docs](https://x.io) <https://y.io>", 200)
+
+	assert.Equal(t, styleBold+"bold"+styleReset+" "+
+		styleItalic+"italic"+styleReset+" "+
+		styleItalic+"under"+styleReset+" snake_case_name "+
+		styleStrike+"gone"+styleReset+" "+
+		styleCode+"a **b**"+styleReset+" "+
+		styleUnderline+"docs"+styleReset+styleDim+" (https://x.io)"+styleReset+" <https://y.io>\n", rendered)
+}
+
+func TestMarkdown_HeadingRestoresStyleAfterSpans(t *testing.T) {
+	rendered := renderMarkdown("## The `run` command", 80)
+
+	assert.Equal(t, styleHeading2+"The "+styleCode+"run"+styleReset+styleHeading2+" command"+styleReset+"\n", rendered)
+}
+
+func TestMarkdown_Table(t *testing.T) {
+	text := "| Name | Size | Kind |\n|:-----|-----:|:----:|\n| main.go | 10 | go |\n| `b` | 2000 | x |\n"
+
+	rendered := stripANSI(renderMarkdown(text, 80))
+
+	assert.Equal(t, "Name    │ Size │ Kind\n"+
+		"────────┼──────┼─────\n"+
+		"main.go │   10 │  go\n"+
+		"b       │ 2000 │  x\n", rendered)
+
+	// Tables wider than the terminal are left as written
+	assert.Equal(t, text, stripANSI(renderMarkdown(text, 10)))
+}
+
+func TestMarkdown_CodeBlock(t *testing.T) {
+	text := "```go\nfunc main() {\n\t// say hi\n\tfmt.Println(\"hi\", 42) /* done\n*/ return\n}\n```\n```\n**not markdown**\n```"
+
+	rendered := renderMarkdown(text, 20)
+
+	assert.Equal(t, "  "+styleKeyword+"func"+styleReset+" main() {\n"+
+		"      "+styleComment+"// say hi"+styleReset+"\n"+
+		"      fmt.Println("+styleString+`"hi"`+styleReset+", "+styleNumber+"42"+styleReset+") "+styleComment+"/* done"+styleReset+"\n"+
+		"  "+styleComment+"*/"+styleReset+" "+styleKeyword+"return"+styleReset+"\n"+
+		"  }\n\n"+
+		"  **not markdown**\n", rendered)
+}
+
+func TestMarkdown_ShellComments(t *testing.T) {
+	h := newHighlighter("bash")
+
+	assert.Equal(t, "echo $# "+styleComment+"# count"+styleReset, strings.Replace(h.line("echo $# # count"), styleKeyword+"echo"+styleReset, "echo", 1))
+}
+
+func TestMarkdownWriter_Streaming(t *testing.T) {
+	text := "# Title\nSome text\nthat continues.\n\n```python\nprint('hi')\n```\n\n- a\n- b\n\n| x | y |\n|---|---|\n| 1 | 2 |\n\nThe end"
+	want := renderMarkdown(text, 40)
+
+	var out strings.Builder
+	w := newMarkdownWriter(&out, &markdownRenderer{width: func() int { return 40 }})
+	for i := 0; i < len(text); i += 3 {
+		end := i + 3
+		if end > len(text) {
+			end = len(text)
+		}
+		_, err := w.Write([]byte(text[i:end]))
+		require.NoError(t, err)
+		if i == 3 {
+			assert.Empty(t, out.String(), "nothing is rendered before a line is complete")
+		}
+		if strings.HasPrefix(text[end:], "Some text") {
+			assert.Equal(t, renderMarkdown("# Title", 40), out.String(), "headings are rendered as soon as they end")
+		}
+	}
+	assert.NotContains(t, stripANSI(out.String()), "The end", "the last paragraph waits for Flush")
+	require.NoError(t, w.Flush())
+
+	assert.Equal(t, want, out.String())
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// mentionMaxFileSize is the most of a single file attached for an @mention
+	mentionMaxFileSize = 64 << 10
+	// mentionMaxTotalSize is the most attached to one message across all its mentions
+	mentionMaxTotalSize = 256 << 10
+)
+
+// mentionPattern matches @path references at the start of the input or after whitespace, so e-mail
+// addresses are left alone
+var mentionPattern = regexp.MustCompile(`(^|\s)@([^\s@]+)`)
+
+// mention is a workspace file or directory referenced in user input
+type mention struct {
+	path string // as written, relative to the workspace
+	full string // resolved against the agent's working directory
+	dir  bool
+}
+
+// expandMentions attaches the files and directory listings referenced as @path in input to the
+// message sent to the model. References that do not name an existing path are left as they are. The
+// returned notes tell the user what was attached or skipped.
+func (a *Agent) expandMentions(ctx context.Context, input string) (string, []string) {
+	var mentions []mention
+	var notes []string
+	seen := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(input, -1) {
+		m, ok, err := a.resolveMention(match[2])
+		if err != nil {
+			notes = append(notes, fmt.Sprintf("Skipping @%s: %v", match[2], err))
+			continue
+		}
+		if ok && !seen[m.path] {
+			seen[m.path] = true
+			mentions = append(mentions, m)
+		}
+	}
+	if len(mentions) == 0 {
+		return input, notes
+	}
+
+	ignored := a.ignoredPaths(ctx, mentions)
+	var attached strings.Builder
+	var names []string
+	total := 0
+	for _, m := range mentions {
+		if ignored[m.path] {
+			notes = append(notes, fmt.Sprintf("Skipping @%s: ignored by git", m.path))
+			continue
+		}
+		content, err := mentionContent(m)
+		if err != nil {
+			notes = append(notes, fmt.Sprintf("Skipping @%s: %v", m.path, err))
+			continue
+		}
+		if total+len(content) > mentionMaxTotalSize {
+			notes = append(notes, fmt.Sprintf("Skipping @%s: attachments are limited to %s per message", m.path, formatSize(mentionMaxTotalSize)))
+			continue
+		}
+		total += len(content)
+
+		kind := "file"
+		if m.dir {
+			kind = "directory"
+		}
+		fmt.Fprintf(&attached, "\n\n<%s path=%q>\n%s", kind, m.path, content)
+		if !strings.HasSuffix(content, "\n") {
+			attached.WriteByte('\n')
+		}
+		fmt.Fprintf(&attached, "</%s>", kind)
+		names = append(names, m.path)
+	}
+	if len(names) == 0 {
+		return input, notes
+	}
+
+	notes = append(notes, "Attached "+strings.Join(names, ", "))
+	return input + "\n\nReferenced files:" + attached.String(), notes
+}
+
+// resolveMention resolves a referenced path against the workspace. It reports false when nothing
+// exists at the path, and an error when the path leaves the workspace.
+func (a *Agent) resolveMention(path string) (mention, bool, error) {
+	// Allow punctuation after a reference, as in "look at @main.go, then..."
+	for {
+		m, ok, err := a.statMention(path)
+		if ok || err != nil {
+			return m, ok, err
+		}
+		trimmed := strings.TrimRight(path, ".,;:!?)'\"")
+		if trimmed == path || trimmed == "" {
+			return mention{}, false, nil
+		}
+		path = trimmed
+	}
+}
+
+func (a *Agent) statMention(path string) (mention, bool, error) {
+	clean := filepath.Clean(path)
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return mention{}, false, fmt.Errorf("outside the workspace")
+	}
+	full := a.resolvePath(clean)
+	info, err := os.Stat(full)
+	if err != nil {
+		return mention{}, false, nil
+	}
+	if !insideWorkspace(a.resolvePath("."), full) {
+		return mention{}, false, fmt.Errorf("outside the workspace")
+	}
+	if clean == ".git" || strings.HasPrefix(clean, ".git"+string(filepath.Separator)) {
+		return mention{}, false, fmt.Errorf("ignored by git")
+	}
+	return mention{path: filepath.ToSlash(clean), full: full, dir: info.IsDir()}, true, nil
+}
+
+// insideWorkspace reports whether path stays within root once symlinks are followed.
+// Missing trailing elements of path, such as a file about to be written, are taken as given.
+func insideWorkspace(root, path string) bool {
+	root, err := evalExistingSymlinks(root)
+	if err != nil {
+		return false
+	}
+	path, err = evalExistingSymlinks(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// evalExistingSymlinks makes path absolute and follows the symlinks of its longest existing prefix.
+// A dangling symlink is an error, since writing through it would create its target.
+func evalExistingSymlinks(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	var missing []string
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...), nil
+		}
+		if _, lerr := os.Lstat(path); lerr == nil || !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", err
+		}
+		missing = append([]string{filepath.Base(path)}, missing...)
+		path = parent
+	}
+}
+
+// ignoredPaths returns which of the mentions git ignores. Outside a repository nothing is ignored.
+func (a *Agent) ignoredPaths(ctx context.Context, mentions []mention) map[string]bool {
+	args := []string{"check-ignore", "--"}
+	for _, m := range mentions {
+		args = append(args, m.path)
+	}
+	// check-ignore exits with status 1 when no path is ignored, which runGit reports as an error
+	out, _ := a.runGit(ctx, args...)
+	ignored := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if line != "" {
+			ignored[filepath.ToSlash(line)] = true
+		}
+	}
+	return ignored
+}
+
+// mentionContent returns what is attached for a mention: a directory listing or the file's text,
+// truncated to mentionMaxFileSize
+func mentionContent(m mention) (string, error) {
+	if m.dir {
+		return renderDirListing(m.full, false)
+	}
+
+	f, err := os.Open(m.full)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("not a regular file")
+	}
+
+	data, err := io.ReadAll(io.LimitReader(f, mentionMaxFileSize))
+	if err != nil {
+		return "", err
+	}
+	if bytes.IndexByte(data, 0) != -1 {
+		return "", fmt.Errorf("binary file")
+	}
+	content := string(data)
+	if info.Size() > mentionMaxFileSize {
+		content += fmt.Sprintf("\n[truncated: showing the first %s of %s, use read_file for the rest]\n", formatSize(mentionMaxFileSize), formatSize(info.Size()))
+	}
+	return content, nil
+}
+
+// completeInput completes slash commands and @path references for the line editor
+func (a *Agent) completeInput(line string) []string {
+	if a.commands != nil {
+		if matches := a.commands.complete(line); matches != nil {
+			return matches
+		}
+	}
+	return a.completeMention(line)
+}
+
+// completeMention completes an @path reference being typed at the end of line with the matching
+// workspace entries, directories ending in a slash. Hidden entries are offered only once a dot is
+// typed, and git-ignored entries are left out.
+func (a *Agent) completeMention(line string) []string {
+	start := strings.LastIndexAny(line, " \t\n") + 1
+	word := line[start:]
+	if !strings.HasPrefix(word, "@") {
+		return nil
+	}
+	typed := word[1:]
+	dir, prefix := "", typed
+	if i := strings.LastIndex(typed, "/"); i >= 0 {
+		dir, prefix = typed[:i+1], typed[i+1:]
+	}
+	if _, ok, err := a.statMention(strings.TrimSuffix("./"+dir, "/")); !ok || err != nil {
+		return nil
+	}
+	entries, err := os.ReadDir(a.resolvePath("./" + dir))
+	if err != nil {
+		return nil
+	}
+
+	var candidates []mention
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || name == ".git" || (strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".")) {
+			continue
+		}
+		candidates = append(candidates, mention{path: dir + name, dir: entry.IsDir()})
+	}
+	ignored := map[string]bool{}
+	if len(candidates) > 0 {
+		ignored = a.ignoredPaths(context.Background(), candidates)
+	}
+
+	var matches []string
+	for _, c := range candidates {
+		if ignored[c.path] {
+			continue
+		}
+		completion := line[:start] + "@" + c.path
+		if c.dir {
+			completion += "/"
+		}
+		matches = append(matches, completion)
+	}
+	sort.Strings(matches)
+	return matches
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupMentionsAgent(t *testing.T) (*Agent, string) {
+	agent, dir := setupGitTestAgent(t)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".gitignore"), []byte("secrets.env\nbuild/\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "secrets.env"), []byte("TOKEN=x\n"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "build"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "pkg", "util"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "pkg", "util", "strings.go"), []byte("package util"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "pkg", "parse.go"), []byte("package pkg\n"), 0644))
+	return agent, dir
+}
+
+func TestExpandMentions_AttachesFilesAndDirectories(t *testing.T) {
+	agent, _ := setupMentionsAgent(t)
+
+	message, notes := agent.expandMentions(context.Background(), "Compare @main.go with @pkg/util/strings.go, and look at @pkg. Mail me@example.com @main.go")
+
+	assert.Equal(t, "Compare @main.go with @pkg/util/strings.go, and look at @pkg. Mail me@example.com @main.go\n\n"+
+		"Referenced files:\n\n"+
+		"<file path=\"main.go\">\npackage main\n\nfunc main() {}\n</file>\n\n"+
+		"<file path=\"pkg/util/strings.go\">\npackage util\n</file>\n\n"+
+		"<directory path=\"pkg\">\nparse.go (12 B, 1 line)\nutil/ (1 entry)\n</directory>", message)
+	assert.Equal(t, []string{"Attached main.go, pkg/util/strings.go, pkg"}, notes)
+}
+
+func TestExpandMentions_LeavesUnknownReferences(t *testing.T) {
+	agent, _ := setupMentionsAgent(t)
+
+	message, notes := agent.expandMentions(context.Background(), "ask @alice about @missing.go")
+
+	assert.Equal(t, "ask @alice about @missing.go", message)
+	assert.Empty(t, notes)
+}
+
+func TestExpandMentions_Skips(t *testing.T) {
+	agent, dir := setupMentionsAgent(t)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "image.png"), []byte("\x89PNG\x00\x00"), 0644))
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "other.go"), []byte("package other"), 0644))
+	require.NoError(t, os.Symlink(outside, filepath.Join(dir, "linked")))
+
+	message, notes := agent.expandMentions(context.Background(),
+		"@secrets.env @build @image.png @../"+filepath.Base(outside)+"/other.go @linked/other.go @.git/config")
+
+	assert.NotContains(t, message, "Referenced files")
+	assert.ElementsMatch(t, []string{
+		"Skipping @../" + filepath.Base(outside) + "/other.go: outside the workspace",
+		"Skipping @linked/other.go: outside the workspace",
+		"Skipping @.git/config: ignored by git",
+		"Skipping @secrets.env: ignored by git",
+		"Skipping @build: ignored by git",
+		"Skipping @image.png: binary file",
+	}, notes)
+}
+
+func TestExpandMentions_SizeLimits(t *testing.T) {
+	agent, dir := setupMentionsAgent(t)
+	large := strings.Repeat("x", mentionMaxFileSize+10)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt", "e.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(large), 0644))
+	}
+
+	message, notes := agent.expandMentions(context.Background(), "@a.txt @b.txt @c.txt @d.txt @e.txt")
+
+	assert.Contains(t, message, "[truncated: showing the first 64.0 KB of 64.0 KB, use read_file for the rest]")
+	assert.Equal(t, []string{
+		"Skipping @d.txt: attachments are limited to 256.0 KB per message",
+		"Skipping @e.txt: attachments are limited to 256.0 KB per message",
+		"Attached a.txt, b.txt, c.txt",
+	}, notes)
+}
+
+func TestCompleteInput(t *testing.T) {
+	agent, dir := setupMentionsAgent(t)
+	agent.commands = newCommandRegistry()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".env"), []byte("A=1"), 0644))
+
+	assert.Equal(t, []string{"/help", "/history"}, agent.completeInput("/h"))
+	assert.Equal(t, []string{"read @main.go"}, agent.completeInput("read @ma"))
+	assert.Equal(t, []string{"see @pkg/"}, agent.completeInput("see @p"))
+	assert.Equal(t, []string{"@pkg/parse.go", "@pkg/util/"}, agent.completeInput("@pkg/"))
+	assert.Equal(t, []string{"@.env", "@.gitignore"}, agent.completeInput("@."))
+	assert.Equal(t, []string{"@main.go", "@pkg/"}, agent.completeInput("@"))
+	assert.Nil(t, agent.completeInput("@../"))
+	assert.Nil(t, agent.completeInput("plain words"))
+}
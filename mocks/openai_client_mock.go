@@ -11,6 +11,7 @@ type MockOpenAIClient struct {
 	ChatCompletionResponses []openai.ChatCompletionResponse
 	ChatCompletionErrors    []error
 	CallCount               int
+	Requests                []openai.ChatCompletionRequest
 }
 
 func NewMockOpenAIClient() *MockOpenAIClient {
@@ -22,6 +23,7 @@ func NewMockOpenAIClient() *MockOpenAIClient {
 }
 
 func (m *MockOpenAIClient) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	m.Requests = append(m.Requests, request)
 	if m.CallCount >= len(m.ChatCompletionResponses) {
 		if m.CallCount < len(m.ChatCompletionErrors) {
 			err := m.ChatCompletionErrors[m.CallCount]
@@ -48,6 +50,7 @@ func (m *MockOpenAIClient) Reset() {
 	m.ChatCompletionResponses = make([]openai.ChatCompletionResponse, 0)
 	m.ChatCompletionErrors = make([]error, 0)
 	m.CallCount = 0
+	m.Requests = nil
 }
 
 // Helper function to create standard mock responses
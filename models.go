@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	openai "github.com/sashabaranov/go-openai"
+	"gopkg.in/yaml.v3"
+)
+
+// configFileName is the configuration file within a configuration directory
+const configFileName = "config.yaml"
+
+// Routing purposes a model can be assigned to
+const (
+	routeMain     = "main"     // the interactive conversation
+	routeSubAgent = "subagent" // run_agent and run_agents sub-agents
+	routeSummary  = "summary"  // commit messages and other summaries
+)
+
+// ProviderConfig describes how to reach a model backend
+type ProviderConfig struct {
+	Type           string `yaml:"type"` // openai, anthropic or ollama
+	Endpoint       string `yaml:"endpoint"`
+	APIKey         string `yaml:"api_key"`
+	APIKeyEnv      string `yaml:"api_key_env"` // environment variable holding the API key
+	ToolMode       string `yaml:"tool_mode"`
+	ThinkingBudget int    `yaml:"thinking_budget"`
+
+	recorder   *cassetteRecorder // records the exchanges of OpenAI-compatible providers; nil records nothing
+	httpClient *http.Client      // sends the API requests; nil uses the default client
+}
+
+// ModelConfig names a model on a provider and the models to try when it fails
+type ModelConfig struct {
+	Provider string   `yaml:"provider"`
+	Model    string   `yaml:"model"`
+	Fallback []string `yaml:"fallback"`
+}
+
+// Config is the contents of the configuration files
+type Config struct {
+	Providers map[string]ProviderConfig `yaml:"providers"`
+	Models    map[string]ModelConfig    `yaml:"models"`
+	Routing   map[string]string         `yaml:"routing"` // purpose to model name
+}
+
+// loadConfig reads config.yaml from the user's and then the project's configuration directory.
+// Project entries replace user entries of the same name. Missing files are skipped.
+func loadConfig(projectDir, userDir string) (Config, error) {
+	config := Config{
+		Providers: make(map[string]ProviderConfig),
+		Models:    make(map[string]ModelConfig),
+		Routing:   make(map[string]string),
+	}
+	for _, dir := range []string{userDir, projectDir} {
+		if dir == "" {
+			continue
+		}
+		path := filepath.Join(dir, configFileName)
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return Config{}, err
+		}
+
+		var layer Config
+		if err := yaml.Unmarshal(data, &layer); err != nil {
+			return Config{}, fmt.Errorf("%s: %w", path, err)
+		}
+		for name, provider := range layer.Providers {
+			config.Providers[name] = provider
+		}
+		for name, model := range layer.Models {
+			config.Models[name] = model
+		}
+		for purpose, model := range layer.Routing {
+			config.Routing[purpose] = model
+		}
+	}
+	return config, config.validate()
+}
+
+// validate checks that every reference in the configuration resolves
+func (c Config) validate() error {
+	for name, provider := range c.Providers {
+		switch provider.Type {
+		case providerOpenAI, providerAnthropic, providerOllama:
+		default:
+			return fmt.Errorf("provider %q: unknown type %q, expected %s, %s or %s", name, provider.Type, providerOpenAI, providerAnthropic, providerOllama)
+		}
+	}
+	for name, model := range c.Models {
+		if _, ok := c.Providers[model.Provider]; !ok {
+			return fmt.Errorf("model %q: unknown provider %q", name, model.Provider)
+		}
+		for _, fallback := range model.Fallback {
+			if _, ok := c.Models[fallback]; !ok {
+				return fmt.Errorf("model %q: unknown fallback model %q", name, fallback)
+			}
+		}
+	}
+	for purpose, model := range c.Routing {
+		if _, ok := c.Models[model]; !ok {
+			return fmt.Errorf("routing %q: unknown model %q", purpose, model)
+		}
+	}
+	return nil
+}
+
+// buildProvider creates the backend described by config
+func buildProvider(config ProviderConfig) (Provider, error) {
+	apiKey := config.APIKey
+	if config.APIKeyEnv != "" {
+		apiKey = os.Getenv(config.APIKeyEnv)
+	}
+
+	switch config.Type {
+	case providerOpenAI, providerOllama:
+		endpoint := config.Endpoint
+		if endpoint == "" && config.Type == providerOllama {
+			endpoint = defaultOllamaEndpoint
+		}
+		if endpoint == "" {
+			return nil, fmt.Errorf("an endpoint is required")
+		}
+		clientConfig := openai.DefaultConfig(apiKey)
+		clientConfig.BaseURL = endpoint
+		if config.httpClient != nil {
+			clientConfig.HTTPClient = config.httpClient
+		}
+		var client OpenAIClient = openai.NewClientWithConfig(clientConfig)
+		if config.recorder != nil {
+			client = config.recorder.wrap(client)
+		}
+		var provider Provider = NewOpenAIProvider(client)
+
+		toolMode := config.ToolMode
+		if toolMode == "" && config.Type == providerOllama {
+			toolMode = ToolModeAuto
+		}
+		if toolMode == "" || toolMode == ToolModeNative {
+			return provider, nil
+		}
+		return NewPromptedToolsProvider(provider, toolMode)
+	case providerAnthropic:
+		if apiKey == "" {
+			return nil, fmt.Errorf("an API key is required")
+		}
+		if config.recorder != nil {
+			return nil, fmt.Errorf("cassettes can only record OpenAI-compatible providers")
+		}
+		return NewAnthropicProvider(AnthropicConfig{
+			APIKey:         apiKey,
+			BaseURL:        config.Endpoint,
+			ThinkingBudget: config.ThinkingBudget,
+			Stream:         true,
+			HTTPClient:     config.httpClient,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown provider type %q, expected %s, %s or %s", config.Type, providerOpenAI, providerAnthropic, providerOllama)
+	}
+}
+
+// modelRouter resolves configured model names to providers, building each provider once
+type modelRouter struct {
+	config     Config
+	fallback   Provider // serves model names the configuration does not define; nil rejects them
+	recorder   *cassetteRecorder
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	providers map[string]Provider
+}
+
+func newModelRouter(config Config, fallback Provider) *modelRouter {
+	return &modelRouter{config: config, fallback: fallback, providers: make(map[string]Provider)}
+}
+
+// names returns the configured model names in sorted order
+func (r *modelRouter) names() []string {
+	return sortedKeys(r.config.Models)
+}
+
+// route returns the model configured for purpose, if any
+func (r *modelRouter) route(purpose string) string {
+	return r.config.Routing[purpose]
+}
+
+// resolve returns the provider serving the named model. Configured models are served by their
+// fallback chain; other names go to the fallback provider as a raw model name.
+func (r *modelRouter) resolve(name string) (Provider, error) {
+	if _, ok := r.config.Models[name]; !ok {
+		if r.fallback == nil {
+			return nil, fmt.Errorf("unknown model %q, available models: %s", name, strings.Join(r.names(), ", "))
+		}
+		return r.fallback, nil
+	}
+
+	var chain fallbackProvider
+	seen := make(map[string]bool)
+	var add func(name string) error
+	add = func(name string) error {
+		if seen[name] {
+			return nil
+		}
+		seen[name] = true
+		model := r.config.Models[name]
+		provider, err := r.provider(model.Provider)
+		if err != nil {
+			return fmt.Errorf("model %q: %w", name, err)
+		}
+		chain.candidates = append(chain.candidates, modelCandidate{name: name, provider: provider, model: model.Model})
+		for _, fallback := range model.Fallback {
+			if err := add(fallback); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := add(name); err != nil {
+		return nil, err
+	}
+	return &chain, nil
+}
+
+// provider returns the named provider, building it on first use
+func (r *modelRouter) provider(name string) (Provider, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if provider, ok := r.providers[name]; ok {
+		return provider, nil
+	}
+	config := r.config.Providers[name]
+	config.recorder = r.recorder
+	config.httpClient = r.httpClient
+	provider, err := buildProvider(config)
+	if err != nil {
+		return nil, fmt.Errorf("provider %q: %w", name, err)
+	}
+	r.providers[name] = provider
+	return provider, nil
+}
+
+// modelCandidate is one step of a fallback chain
+type modelCandidate struct {
+	name     string
+	provider Provider
+	model    string
+}
+
+// fallbackProvider tries each candidate in turn until one answers, so a failing or rate-limited
+// provider does not stop the conversation
+type fallbackProvider struct {
+	candidates []modelCandidate
+}
+
+func (f *fallbackProvider) Chat(ctx context.Context, request ChatRequest) (ChatResponse, error) {
+	var failures []string
+	for i, candidate := range f.candidates {
+		request.Model = candidate.model
+		resp, err := candidate.provider.Chat(ctx, request)
+		if err == nil {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return resp, err
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", candidate.name, err))
+		if i+1 < len(f.candidates) {
+			slog.Warn("model failed, falling back", "model", candidate.name, "fallback", f.candidates[i+1].name, "error", err)
+		}
+	}
+	if len(failures) == 1 {
+		return ChatResponse{}, fmt.Errorf("%s", failures[0])
+	}
+	return ChatResponse{}, fmt.Errorf("all models failed: %s", strings.Join(failures, "; "))
+}
+
+// useModel switches the agent to the named model, resolving it through the configured models
+func (a *Agent) useModel(name string) error {
+	if a.router == nil {
+		a.model = name
+		return nil
+	}
+	provider, err := a.router.resolve(name)
+	if err != nil {
+		return err
+	}
+	a.provider, a.model = provider, name
+	return nil
+}
+
+// routedModel returns the provider and model configured for purpose, defaulting to the agent's own
+func (a *Agent) routedModel(purpose string) (Provider, string) {
+	if a.router != nil {
+		if name := a.router.route(purpose); name != "" {
+			if provider, err := a.router.resolve(name); err == nil {
+				return provider, name
+			}
+		}
+	}
+	return a.provider, a.model
+}
+
+// modelCommand implements /model: without arguments it lists the models, otherwise it switches model
+func (a *Agent) modelCommand(args string) string {
+	name := strings.TrimSpace(args)
+	if name == "" {
+		var out strings.Builder
+		out.WriteString(fmt.Sprintf("Current model: %s\n", a.model))
+		if a.router != nil && len(a.router.config.Models) > 0 {
+			out.WriteString("Configured models:\n")
+			for _, model := range a.router.names() {
+				config := a.router.config.Models[model]
+				line := fmt.Sprintf("- %s: %s on %s", model, config.Model, config.Provider)
+				if len(config.Fallback) > 0 {
+					line += ", falls back to " + strings.Join(config.Fallback, ", ")
+				}
+				out.WriteString(line + "\n")
+			}
+			if len(a.router.config.Routing) > 0 {
+				out.WriteString("Routing:\n")
+				for _, purpose := range sortedKeys(a.router.config.Routing) {
+					out.WriteString(fmt.Sprintf("- %s: %s\n", purpose, a.router.config.Routing[purpose]))
+				}
+			}
+		}
+		return out.String()
+	}
+
+	if err := a.useModel(name); err != nil {
+		return fmt.Sprintf("Could not switch model: %v\n", err)
+	}
+	return fmt.Sprintf("Switched to model %s\n", name)
+}
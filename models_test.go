@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, dir, content string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, configFileName), []byte(content), 0644))
+}
+
+func TestLoadConfig_ProjectOverridesUser(t *testing.T) {
+	userDir := filepath.Join(t.TempDir(), "user")
+	projectDir := filepath.Join(t.TempDir(), "project")
+	writeConfig(t, userDir, `
+providers:
+  openrouter:
+    type: openai
+    endpoint: https://openrouter.ai/api/v1
+    api_key_env: OPENROUTER_KEY
+  local:
+    type: ollama
+models:
+  strong:
+    provider: openrouter
+    model: anthropic/claude-sonnet-4
+    fallback: [cheap]
+  cheap:
+    provider: openrouter
+    model: openai/gpt-4o-mini
+routing:
+  main: strong
+  subagent: cheap
+`)
+	writeConfig(t, projectDir, `
+models:
+  cheap:
+    provider: local
+    model: qwen3
+routing:
+  summary: cheap
+`)
+
+	config, err := loadConfig(projectDir, userDir)
+	require.NoError(t, err)
+
+	assert.Equal(t, ModelConfig{Provider: "local", Model: "qwen3"}, config.Models["cheap"])
+	assert.Equal(t, []string{"cheap"}, config.Models["strong"].Fallback)
+	assert.Equal(t, "OPENROUTER_KEY", config.Providers["openrouter"].APIKeyEnv)
+	assert.Equal(t, map[string]string{"main": "strong", "subagent": "cheap", "summary": "cheap"}, config.Routing)
+}
+
+func TestLoadConfig_MissingFiles(t *testing.T) {
+	config, err := loadConfig(filepath.Join(t.TempDir(), "none"), "")
+
+	require.NoError(t, err)
+	assert.Empty(t, config.Models)
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"unknown type \"grpc\"":              "providers:\n  p:\n    type: grpc\n",
+		"unknown provider \"missing\"":       "models:\n  m:\n    provider: missing\n",
+		"unknown fallback model \"missing\"": "providers:\n  p:\n    type: openai\nmodels:\n  m:\n    provider: p\n    fallback: [missing]\n",
+		"routing \"main\": unknown model":    "routing:\n  main: missing\n",
+	}
+	for want, content := range tests {
+		dir := t.TempDir()
+		writeConfig(t, dir, content)
+
+		_, err := loadConfig(dir, "")
+
+		assert.ErrorContains(t, err, want)
+	}
+}
+
+func TestBuildProvider(t *testing.T) {
+	provider, err := buildProvider(ProviderConfig{Type: providerOllama})
+	require.NoError(t, err)
+	assert.IsType(t, &PromptedToolsProvider{}, provider)
+
+	provider, err = buildProvider(ProviderConfig{Type: providerOpenAI, Endpoint: "http://localhost:1234/v1"})
+	require.NoError(t, err)
+	assert.IsType(t, &OpenAIProvider{}, provider)
+
+	_, err = buildProvider(ProviderConfig{Type: providerOpenAI})
+	assert.EqualError(t, err, "an endpoint is required")
+
+	t.Setenv("TEST_ANTHROPIC_KEY", "")
+	_, err = buildProvider(ProviderConfig{Type: providerAnthropic, APIKeyEnv: "TEST_ANTHROPIC_KEY"})
+	assert.EqualError(t, err, "an API key is required")
+}
+
+// newTestRouter returns a router over config whose providers are already built
+func newTestRouter(config Config, providers map[string]Provider) *modelRouter {
+	router := newModelRouter(config, nil)
+	router.providers = providers
+	return router
+}
+
+var routerTestConfig = Config{
+	Providers: map[string]ProviderConfig{"primary": {Type: providerOpenAI}, "backup": {Type: providerOpenAI}},
+	Models: map[string]ModelConfig{
+		"strong": {Provider: "primary", Model: "big-model", Fallback: []string{"backup"}},
+		"backup": {Provider: "backup", Model: "other-model", Fallback: []string{"strong"}},
+		"cheap":  {Provider: "backup", Model: "small-model"},
+	},
+	Routing: map[string]string{routeSubAgent: "cheap"},
+}
+
+func TestModelRouter_FallsBack(t *testing.T) {
+	primary := &scriptedProvider{replies: []func(ChatRequest) (ChatResponse, error){
+		func(ChatRequest) (ChatResponse, error) {
+			return ChatResponse{}, errors.New("status code: 429, rate limited")
+		},
+	}}
+	backup := &scriptedProvider{replies: []func(ChatRequest) (ChatResponse, error){replyWith("From backup.")}}
+	router := newTestRouter(routerTestConfig, map[string]Provider{"primary": primary, "backup": backup})
+
+	provider, err := router.resolve("strong")
+	require.NoError(t, err)
+	resp, err := provider.Chat(context.Background(), ChatRequest{Model: "strong"})
+
+	require.NoError(t, err)
+	assert.Equal(t, "From backup.", resp.Message.Content)
+	assert.Equal(t, "big-model", primary.requests[0].Model)
+	assert.Equal(t, "other-model", backup.requests[0].Model)
+}
+
+func TestModelRouter_AllFail(t *testing.T) {
+	failing := func(ChatRequest) (ChatResponse, error) { return ChatResponse{}, errors.New("unavailable") }
+	primary := &scriptedProvider{replies: []func(ChatRequest) (ChatResponse, error){failing}}
+	backup := &scriptedProvider{replies: []func(ChatRequest) (ChatResponse, error){failing}}
+	router := newTestRouter(routerTestConfig, map[string]Provider{"primary": primary, "backup": backup})
+
+	provider, err := router.resolve("backup")
+	require.NoError(t, err)
+	_, err = provider.Chat(context.Background(), ChatRequest{})
+
+	// The cycle back to backup is not retried
+	assert.EqualError(t, err, "all models failed: backup: unavailable; strong: unavailable")
+}
+
+func TestModelRouter_UnknownModel(t *testing.T) {
+	router := newTestRouter(routerTestConfig, map[string]Provider{})
+
+	_, err := router.resolve("gpt-5")
+	assert.EqualError(t, err, `unknown model "gpt-5", available models: backup, cheap, strong`)
+
+	fallback := &scriptedProvider{}
+	router.fallback = fallback
+	provider, err := router.resolve("gpt-5")
+	require.NoError(t, err)
+	assert.Same(t, fallback, provider)
+}
+
+func TestAgent_ModelCommand(t *testing.T) {
+	backup := &scriptedProvider{replies: []func(ChatRequest) (ChatResponse, error){replyWith("Hi.")}}
+	agent := NewAgent(nil, nil, "strong")
+	agent.router = newTestRouter(routerTestConfig, map[string]Provider{"primary": &scriptedProvider{}, "backup": backup})
+
+	listing := agent.modelCommand("")
+	assert.Contains(t, listing, "Current model: strong\n")
+	assert.Contains(t, listing, "- strong: big-model on primary, falls back to backup\n")
+	assert.Contains(t, listing, "Routing:\n- subagent: cheap\n")
+
+	assert.Equal(t, "Switched to model cheap\n", agent.modelCommand(" cheap "))
+	assert.Equal(t, "cheap", agent.model)
+	_, err := agent.createChatCompletion(context.Background(), []Message{{Role: RoleUser, Content: "Hello"}})
+	require.NoError(t, err)
+	assert.Equal(t, "small-model", backup.requests[0].Model)
+
+	assert.Contains(t, agent.modelCommand("missing"), `Could not switch model: unknown model "missing"`)
+	assert.Equal(t, "cheap", agent.model)
+}
+
+func TestAgent_SubAgentUsesRoutedModel(t *testing.T) {
+	primary := &scriptedProvider{}
+	cheap := &scriptedProvider{replies: []func(ChatRequest) (ChatResponse, error){replyWith("Done.")}}
+	agent := NewAgent(primary, nil, "strong")
+	agent.router = newTestRouter(routerTestConfig, map[string]Provider{"primary": primary, "backup": cheap})
+
+	run, err := agent.runSubAgent(context.Background(), RunAgentInput{Task: "Summarise the repo"}, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, run.err)
+	assert.Empty(t, primary.requests)
+	require.Len(t, cheap.requests, 1)
+	assert.Equal(t, "small-model", cheap.requests[0].Model)
+}
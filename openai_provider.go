@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// OpenAIClient interface for mocking
+type OpenAIClient interface {
+	CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+}
+
+// OpenAIProvider talks to OpenAI-compatible chat completion endpoints such as OpenRouter
+type OpenAIProvider struct {
+	client OpenAIClient
+}
+
+// NewOpenAIProvider creates a provider backed by an OpenAI-compatible client
+func NewOpenAIProvider(client OpenAIClient) *OpenAIProvider {
+	return &OpenAIProvider{client: client}
+}
+
+// Chat sends the conversation as a chat completion request
+func (p *OpenAIProvider) Chat(ctx context.Context, request ChatRequest) (ChatResponse, error) {
+	messages := make([]openai.ChatCompletionMessage, 0, len(request.Messages))
+	for _, msg := range request.Messages {
+		messages = append(messages, toOpenAIMessage(msg))
+	}
+
+	var tools []openai.Tool
+	for _, tool := range request.Tools {
+		tools = append(tools, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+
+	resp, err := p.client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model:    request.Model,
+		Messages: messages,
+		Tools:    tools,
+	})
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	if len(resp.Choices) == 0 {
+		return ChatResponse{}, errEmptyResponse
+	}
+
+	usage := Usage{PromptTokens: resp.Usage.PromptTokens, CompletionTokens: resp.Usage.CompletionTokens}
+	if resp.Usage.PromptTokensDetails != nil {
+		usage.CacheReadTokens = resp.Usage.PromptTokensDetails.CachedTokens
+	}
+	return ChatResponse{
+		Message:    fromOpenAIMessage(resp.Choices[0].Message),
+		Usage:      usage,
+		StopReason: string(resp.Choices[0].FinishReason),
+	}, nil
+}
+
+func toOpenAIMessage(msg Message) openai.ChatCompletionMessage {
+	result := openai.ChatCompletionMessage{
+		Role:       msg.Role,
+		Content:    msg.Content,
+		ToolCallID: msg.ToolCallID,
+	}
+	for _, toolCall := range msg.ToolCalls {
+		result.ToolCalls = append(result.ToolCalls, openai.ToolCall{
+			ID:   toolCall.ID,
+			Type: openai.ToolTypeFunction,
+			Function: openai.FunctionCall{
+				Name:      toolCall.Name,
+				Arguments: toolCall.Arguments,
+			},
+		})
+	}
+	return result
+}
+
+func fromOpenAIMessage(msg openai.ChatCompletionMessage) Message {
+	result := Message{
+		Role:       msg.Role,
+		Content:    msg.Content,
+		ToolCallID: msg.ToolCallID,
+	}
+	if msg.ReasoningContent != "" {
+		result.Thinking = []ThinkingBlock{{Text: msg.ReasoningContent}}
+	}
+	for _, toolCall := range msg.ToolCalls {
+		if toolCall.Type != "" && toolCall.Type != openai.ToolTypeFunction {
+			continue
+		}
+		result.ToolCalls = append(result.ToolCalls, fromOpenAIToolCall(toolCall))
+	}
+	return result
+}
+
+func fromOpenAIToolCall(toolCall openai.ToolCall) ToolCall {
+	return ToolCall{
+		ID:        toolCall.ID,
+		Name:      toolCall.Function.Name,
+		Arguments: toolCall.Function.Arguments,
+	}
+}
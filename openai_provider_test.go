@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"agent/mocks"
+)
+
+func TestOpenAIProvider_Chat(t *testing.T) {
+	mockClient := mocks.NewMockOpenAIClient()
+	response := mocks.CreateMockResponse("", []openai.ToolCall{mocks.CreateMockToolCall("call-1", "read_file", `{"path": "a.go"}`)})
+	response.Choices[0].Message.ReasoningContent = "Need to look at a.go"
+	response.Usage = openai.Usage{PromptTokens: 30, CompletionTokens: 5, PromptTokensDetails: &openai.PromptTokensDetails{CachedTokens: 20}}
+	mockClient.AddResponse(response)
+	provider := NewOpenAIProvider(mockClient)
+
+	resp, err := provider.Chat(context.Background(), ChatRequest{
+		Model: "test-model",
+		Messages: []Message{
+			{Role: RoleUser, Content: "Read a.go"},
+			{Role: RoleAssistant, Thinking: []ThinkingBlock{{Text: "earlier"}}, ToolCalls: []ToolCall{{ID: "old", Name: "list_dir", Arguments: `{"path": "."}`}}},
+			{Role: RoleTool, ToolCallID: "old", Content: "a.go", IsError: true},
+		},
+		Tools: []ToolDefinition{{Name: "read_file", Description: "Read a file", Parameters: map[string]any{"type": "object"}}},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, Message{
+		Role:      RoleAssistant,
+		Thinking:  []ThinkingBlock{{Text: "Need to look at a.go"}},
+		ToolCalls: []ToolCall{{ID: "call-1", Name: "read_file", Arguments: `{"path": "a.go"}`}},
+	}, resp.Message)
+	assert.Equal(t, Usage{PromptTokens: 30, CompletionTokens: 5, CacheReadTokens: 20}, resp.Usage)
+
+	require.Len(t, mockClient.Requests, 1)
+	request := mockClient.Requests[0]
+	assert.Equal(t, "test-model", request.Model)
+	assert.Equal(t, "read_file", request.Tools[0].Function.Name)
+	assert.Equal(t, openai.ToolTypeFunction, request.Messages[1].ToolCalls[0].Type)
+	assert.Equal(t, "list_dir", request.Messages[1].ToolCalls[0].Function.Name)
+	assert.Empty(t, request.Messages[1].ReasoningContent)
+	assert.Equal(t, "old", request.Messages[2].ToolCallID)
+}
+
+func TestOpenAIProvider_NoChoices(t *testing.T) {
+	mockClient := mocks.NewMockOpenAIClient()
+	mockClient.AddResponse(openai.ChatCompletionResponse{})
+
+	_, err := NewOpenAIProvider(mockClient).Chat(context.Background(), ChatRequest{Model: "test-model"})
+
+	assert.ErrorIs(t, err, errEmptyResponse)
+}
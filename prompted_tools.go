@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Tool modes select how tools are offered to OpenAI-compatible models
+const (
+	ToolModeNative   = "native"   // tools are sent in the request's tools field
+	ToolModePrompted = "prompted" // tools are described in the system prompt and calls parsed from the reply text
+	ToolModeAuto     = "auto"     // native until the server rejects tools, then prompted
+)
+
+// promptedToolInstructions explains the prompted tool protocol to the model
+const promptedToolInstructions = "You can call tools to help with the task. To call a tool, reply with one or more blocks in exactly this format, then stop and wait for the results:\n\n" +
+	"```tool_call\n{\"name\": \"<tool name>\", \"arguments\": {<arguments as JSON>}}\n```\n\n" +
+	"The results are sent back to you in the next message. When you do not need a tool, answer normally without any tool_call block.\n\nAvailable tools:"
+
+var (
+	// Only the formats of the protocol count as calls, so JSON examples in an answer stay text
+	fencedToolCallPattern = regexp.MustCompile("(?s)```tool_call[ \t]*\n(.*?)```")
+	xmlToolCallPattern    = regexp.MustCompile(`(?s)<tool_call>\s*(.*?)\s*</tool_call>`)
+	blankLinesPattern     = regexp.MustCompile(`\n{3,}`)
+)
+
+// PromptedToolsProvider lets models without native tool support use tools by describing them in the
+// system prompt and parsing calls out of the reply text, so the agent sees ordinary tool calls
+type PromptedToolsProvider struct {
+	provider Provider
+	mode     string
+
+	mu         sync.Mutex
+	prompted   bool // auto mode has fallen back to the prompted protocol
+	nextCallID int
+}
+
+// NewPromptedToolsProvider wraps provider with the given tool mode
+func NewPromptedToolsProvider(provider Provider, mode string) (*PromptedToolsProvider, error) {
+	switch mode {
+	case ToolModeNative, ToolModePrompted, ToolModeAuto:
+	default:
+		return nil, fmt.Errorf("unknown tool mode %q, expected %s, %s or %s", mode, ToolModeAuto, ToolModeNative, ToolModePrompted)
+	}
+	return &PromptedToolsProvider{provider: provider, mode: mode}, nil
+}
+
+// Chat sends the request natively or with the prompted tool protocol, depending on the mode
+func (p *PromptedToolsProvider) Chat(ctx context.Context, request ChatRequest) (ChatResponse, error) {
+	if len(request.Tools) == 0 || p.mode == ToolModeNative {
+		return p.provider.Chat(ctx, request)
+	}
+	if p.usePrompted() {
+		return p.chatPrompted(ctx, request)
+	}
+
+	resp, err := p.provider.Chat(ctx, request)
+	if err != nil {
+		if !isToolsUnsupportedError(err) {
+			return resp, err
+		}
+		p.mu.Lock()
+		p.prompted = true
+		p.mu.Unlock()
+		return p.chatPrompted(ctx, request)
+	}
+
+	// Some models accept tools but still write their calls as text
+	if len(resp.Message.ToolCalls) == 0 {
+		resp.Message = p.extractToolCalls(resp.Message, request.Tools)
+	}
+	return resp, nil
+}
+
+func (p *PromptedToolsProvider) usePrompted() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.mode == ToolModePrompted || p.prompted
+}
+
+// chatPrompted sends the request without native tools, describing them in the system prompt instead
+func (p *PromptedToolsProvider) chatPrompted(ctx context.Context, request ChatRequest) (ChatResponse, error) {
+	prompted := ChatRequest{
+		Model:    request.Model,
+		Messages: promptedMessages(request.Messages, describeTools(request.Tools)),
+	}
+	resp, err := p.provider.Chat(ctx, prompted)
+	if err != nil {
+		return resp, err
+	}
+	resp.Message = p.extractToolCalls(resp.Message, request.Tools)
+	return resp, nil
+}
+
+// describeTools renders the prompted protocol instructions and every tool's schema
+func describeTools(tools []ToolDefinition) string {
+	var out strings.Builder
+	out.WriteString(promptedToolInstructions)
+	for _, tool := range tools {
+		schema, _ := json.Marshal(tool.Parameters)
+		out.WriteString(fmt.Sprintf("\n\n## %s\n%s\nArguments JSON schema: %s", tool.Name, tool.Description, schema))
+	}
+	return out.String()
+}
+
+// promptedMessages rewrites a conversation for a model without tool support: the tool description joins
+// the system prompt, earlier calls are written out in the protocol format, and tool results become user messages
+func promptedMessages(messages []Message, toolPrompt string) []Message {
+	var result []Message
+	toolNames := make(map[string]string)
+	afterToolResult := false
+
+	if len(messages) > 0 && messages[0].Role == RoleSystem {
+		result = append(result, Message{Role: RoleSystem, Content: messages[0].Content + "\n\n" + toolPrompt})
+		messages = messages[1:]
+	} else {
+		result = append(result, Message{Role: RoleSystem, Content: toolPrompt})
+	}
+
+	for _, msg := range messages {
+		wasToolResult := afterToolResult
+		afterToolResult = msg.Role == RoleTool
+		switch msg.Role {
+		case RoleAssistant:
+			content := msg.Content
+			for _, toolCall := range msg.ToolCalls {
+				toolNames[toolCall.ID] = toolCall.Name
+				content = strings.TrimSpace(content + "\n\n" + formatPromptedToolCall(toolCall))
+			}
+			result = append(result, Message{Role: RoleAssistant, Content: content})
+		case RoleTool:
+			label := "Tool result"
+			if msg.IsError {
+				label = "Tool error"
+			}
+			content := fmt.Sprintf("%s (%s):\n%s", label, toolNames[msg.ToolCallID], msg.Content)
+			// Results of one batch of calls are sent together as a single user message
+			if wasToolResult {
+				result[len(result)-1].Content += "\n\n" + content
+			} else {
+				result = append(result, Message{Role: RoleUser, Content: content})
+			}
+		default:
+			result = append(result, Message{Role: msg.Role, Content: msg.Content})
+		}
+	}
+	return result
+}
+
+// formatPromptedToolCall writes a tool call in the prompted protocol format
+func formatPromptedToolCall(toolCall ToolCall) string {
+	arguments := json.RawMessage(toolCall.Arguments)
+	if !json.Valid(arguments) {
+		arguments = json.RawMessage("{}")
+	}
+	call, _ := json.Marshal(struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}{toolCall.Name, arguments})
+	return "```tool_call\n" + string(call) + "\n```"
+}
+
+// extractToolCalls moves tool calls written in the reply text into the message's ToolCalls. Calls may be
+// ```tool_call blocks or <tool_call> XML elements; blocks naming unknown tools are left as text.
+func (p *PromptedToolsProvider) extractToolCalls(msg Message, tools []ToolDefinition) Message {
+	known := make(map[string]bool, len(tools))
+	for _, tool := range tools {
+		known[tool.Name] = true
+	}
+
+	content := msg.Content
+	for _, pattern := range []*regexp.Regexp{xmlToolCallPattern, fencedToolCallPattern} {
+		content = pattern.ReplaceAllStringFunc(content, func(block string) string {
+			toolCall, ok := parsePromptedToolCall(pattern.FindStringSubmatch(block)[1], known)
+			if !ok {
+				return block
+			}
+			toolCall.ID = p.newCallID()
+			msg.ToolCalls = append(msg.ToolCalls, toolCall)
+			return ""
+		})
+	}
+	msg.Content = strings.TrimSpace(blankLinesPattern.ReplaceAllString(content, "\n\n"))
+	return msg
+}
+
+// parsePromptedToolCall decodes {"name": ..., "arguments": {...}}, also accepting "parameters" and
+// arguments encoded as a JSON string
+func parsePromptedToolCall(body string, known map[string]bool) (ToolCall, bool) {
+	var call struct {
+		Name       string          `json:"name"`
+		Arguments  json.RawMessage `json:"arguments"`
+		Parameters json.RawMessage `json:"parameters"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(body)), &call); err != nil || !known[call.Name] {
+		return ToolCall{}, false
+	}
+
+	arguments := call.Arguments
+	if len(arguments) == 0 {
+		arguments = call.Parameters
+	}
+	var encoded string
+	if err := json.Unmarshal(arguments, &encoded); err == nil {
+		arguments = json.RawMessage(encoded)
+	}
+	if len(arguments) == 0 || !json.Valid(arguments) {
+		arguments = json.RawMessage("{}")
+	}
+	return ToolCall{Name: call.Name, Arguments: string(arguments)}, true
+}
+
+func (p *PromptedToolsProvider) newCallID() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nextCallID++
+	return fmt.Sprintf("prompted_call_%d", p.nextCallID)
+}
+
+// isToolsUnsupportedError reports whether a server rejected a request because the model cannot use tools,
+// as Ollama does for models without tool support and llama.cpp does without --jinja
+func isToolsUnsupportedError(err error) bool {
+	message := strings.ToLower(err.Error())
+	if !strings.Contains(message, "tool") {
+		return false
+	}
+	for _, hint := range []string{"does not support", "not supported", "unsupported", "jinja"} {
+		if strings.Contains(message, hint) {
+			return true
+		}
+	}
+	return false
+}
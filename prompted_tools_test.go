@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"agent/mocks"
+)
+
+// scriptedProvider answers requests in order and records them
+type scriptedProvider struct {
+	requests []ChatRequest
+	replies  []func(request ChatRequest) (ChatResponse, error)
+}
+
+func (p *scriptedProvider) Chat(ctx context.Context, request ChatRequest) (ChatResponse, error) {
+	p.requests = append(p.requests, request)
+	reply := p.replies[0]
+	p.replies = p.replies[1:]
+	return reply(request)
+}
+
+func replyWith(content string) func(ChatRequest) (ChatResponse, error) {
+	return func(ChatRequest) (ChatResponse, error) {
+		return ChatResponse{Message: Message{Role: RoleAssistant, Content: content}}, nil
+	}
+}
+
+var promptedTestTools = []ToolDefinition{
+	{Name: "read_file", Description: "Read a file", Parameters: map[string]any{"type": "object"}},
+	{Name: "list_dir", Description: "List a directory", Parameters: map[string]any{"type": "object"}},
+}
+
+func TestPromptedToolsProvider_ExtractToolCalls(t *testing.T) {
+	provider, err := NewPromptedToolsProvider(&scriptedProvider{}, ToolModePrompted)
+	require.NoError(t, err)
+
+	msg := provider.extractToolCalls(Message{Role: RoleAssistant, Content: "Let me look.\n\n" +
+		"```tool_call\n{\"name\": \"read_file\", \"arguments\": {\"path\": \"a.go\"}}\n```\n" +
+		"<tool_call>\n{\"name\": \"list_dir\", \"parameters\": \"{\\\"path\\\": \\\".\\\"}\"}\n</tool_call>\n" +
+		"```json\n{\"name\": \"rm_rf\", \"arguments\": {}}\n```"}, promptedTestTools)
+
+	assert.Equal(t, []ToolCall{
+		{ID: "prompted_call_1", Name: "list_dir", Arguments: `{"path": "."}`},
+		{ID: "prompted_call_2", Name: "read_file", Arguments: `{"path": "a.go"}`},
+	}, msg.ToolCalls)
+	assert.Equal(t, "Let me look.\n\n```json\n{\"name\": \"rm_rf\", \"arguments\": {}}\n```", msg.Content)
+}
+
+func TestPromptedMessages(t *testing.T) {
+	messages := promptedMessages([]Message{
+		{Role: RoleSystem, Content: "Be brief."},
+		{Role: RoleUser, Content: "Read a.go and b.go"},
+		{Role: RoleAssistant, Content: "Reading.", ToolCalls: []ToolCall{
+			{ID: "c1", Name: "read_file", Arguments: `{"path":"a.go"}`},
+			{ID: "c2", Name: "read_file", Arguments: `{"path":"b.go"}`},
+		}},
+		{Role: RoleTool, ToolCallID: "c1", Content: "package a"},
+		{Role: RoleTool, ToolCallID: "c2", Content: "no such file", IsError: true},
+		{Role: RoleUser, Content: "Thanks"},
+	}, "TOOLS")
+
+	assert.Equal(t, []Message{
+		{Role: RoleSystem, Content: "Be brief.\n\nTOOLS"},
+		{Role: RoleUser, Content: "Read a.go and b.go"},
+		{Role: RoleAssistant, Content: "Reading.\n\n```tool_call\n{\"name\":\"read_file\",\"arguments\":{\"path\":\"a.go\"}}\n```\n\n```tool_call\n{\"name\":\"read_file\",\"arguments\":{\"path\":\"b.go\"}}\n```"},
+		{Role: RoleUser, Content: "Tool result (read_file):\npackage a\n\nTool error (read_file):\nno such file"},
+		{Role: RoleUser, Content: "Thanks"},
+	}, messages)
+}
+
+func TestPromptedToolsProvider_AutoFallsBack(t *testing.T) {
+	inner := &scriptedProvider{replies: []func(ChatRequest) (ChatResponse, error){
+		func(ChatRequest) (ChatResponse, error) {
+			return ChatResponse{}, errors.New(`error, status code: 400, message: registry.ollama.ai/library/gemma3:latest does not support tools`)
+		},
+		replyWith("```tool_call\n{\"name\": \"read_file\", \"arguments\": {\"path\": \"a.go\"}}\n```"),
+		replyWith("Done."),
+	}}
+	provider, err := NewPromptedToolsProvider(inner, ToolModeAuto)
+	require.NoError(t, err)
+	request := ChatRequest{Model: "gemma3", Messages: []Message{{Role: RoleUser, Content: "Read a.go"}}, Tools: promptedTestTools}
+
+	resp, err := provider.Chat(context.Background(), request)
+	require.NoError(t, err)
+	assert.Equal(t, []ToolCall{{ID: "prompted_call_1", Name: "read_file", Arguments: `{"path": "a.go"}`}}, resp.Message.ToolCalls)
+
+	require.Len(t, inner.requests, 2)
+	assert.Len(t, inner.requests[0].Tools, 2)
+	assert.Empty(t, inner.requests[1].Tools)
+	assert.Equal(t, RoleSystem, inner.requests[1].Messages[0].Role)
+	assert.Contains(t, inner.requests[1].Messages[0].Content, "## read_file\nRead a file")
+
+	// Once fallen back, later requests go straight to the prompted protocol
+	resp, err = provider.Chat(context.Background(), request)
+	require.NoError(t, err)
+	assert.Equal(t, "Done.", resp.Message.Content)
+	require.Len(t, inner.requests, 3)
+	assert.Empty(t, inner.requests[2].Tools)
+}
+
+func TestPromptedToolsProvider_AutoKeepsJSONExamplesAsText(t *testing.T) {
+	answer := "Call it like this:\n\n```json\n{\"name\": \"read_file\", \"arguments\": {\"path\": \"a.go\"}}\n```\n\n" +
+		"```\n{\"name\": \"list_dir\", \"arguments\": {}}\n```"
+	inner := &scriptedProvider{replies: []func(ChatRequest) (ChatResponse, error){replyWith(answer)}}
+	provider, err := NewPromptedToolsProvider(inner, ToolModeAuto)
+	require.NoError(t, err)
+
+	resp, err := provider.Chat(context.Background(), ChatRequest{Messages: []Message{{Role: RoleUser, Content: "How do I read a file?"}}, Tools: promptedTestTools})
+
+	require.NoError(t, err)
+	assert.Empty(t, resp.Message.ToolCalls)
+	assert.Equal(t, answer, resp.Message.Content)
+}
+
+func TestPromptedToolsProvider_AutoKeepsOtherErrors(t *testing.T) {
+	inner := &scriptedProvider{replies: []func(ChatRequest) (ChatResponse, error){
+		func(ChatRequest) (ChatResponse, error) { return ChatResponse{}, errors.New("connection refused") },
+	}}
+	provider, err := NewPromptedToolsProvider(inner, ToolModeAuto)
+	require.NoError(t, err)
+
+	_, err = provider.Chat(context.Background(), ChatRequest{Messages: []Message{{Role: RoleUser, Content: "hi"}}, Tools: promptedTestTools})
+
+	assert.EqualError(t, err, "connection refused")
+	assert.Len(t, inner.requests, 1)
+}
+
+func TestIsToolsUnsupportedError(t *testing.T) {
+	assert.True(t, isToolsUnsupportedError(errors.New("registry.ollama.ai/library/phi:latest does not support tools")))
+	assert.True(t, isToolsUnsupportedError(errors.New("tools param requires --jinja flag")))
+	assert.False(t, isToolsUnsupportedError(errors.New("model not found")))
+	assert.False(t, isToolsUnsupportedError(errors.New("streaming is not supported")))
+}
+
+func TestNewPromptedToolsProvider_InvalidMode(t *testing.T) {
+	_, err := NewPromptedToolsProvider(&scriptedProvider{}, "telepathy")
+
+	assert.ErrorContains(t, err, `unknown tool mode "telepathy"`)
+}
+
+func TestPromptedToolsProvider_DrivesAgentConversation(t *testing.T) {
+	mockClient := mocks.NewMockOpenAIClient()
+	mockClient.AddResponse(mocks.CreateMockResponse("I'll read it.\n<tool_call>{\"name\": \"read_file\", \"arguments\": {\"path\": \"testdata/sample.txt\"}}</tool_call>", nil))
+	mockClient.AddResponse(mocks.CreateMockResponse("It is a sample file.", nil))
+	provider, err := NewPromptedToolsProvider(NewOpenAIProvider(mockClient), ToolModePrompted)
+	require.NoError(t, err)
+	agent := NewAgent(provider, nil, "local-model")
+
+	messages, err := agent.DriveConversation(context.Background(), []Message{{Role: RoleUser, Content: "What is in the sample?"}}, nil, nil)
+	require.NoError(t, err)
+
+	require.Len(t, messages, 4)
+	assert.Equal(t, "I'll read it.", messages[1].Content)
+	assert.Equal(t, "prompted_call_1", messages[2].ToolCallID)
+	assert.Contains(t, messages[2].Content, "This is a sample file for testing.")
+	assert.Equal(t, "It is a sample file.", messages[3].Content)
+
+	require.Len(t, mockClient.Requests, 2)
+	assert.Empty(t, mockClient.Requests[1].Tools)
+	last := mockClient.Requests[1].Messages[len(mockClient.Requests[1].Messages)-1]
+	assert.Equal(t, RoleUser, last.Role)
+	assert.Contains(t, last.Content, "Tool result (read_file):\nThis is a sample file for testing.")
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// Message roles of the provider-neutral conversation model
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleTool      = "tool"
+)
+
+// Message is one entry of a conversation, independent of any provider's wire format
+type Message struct {
+	Role       string          `json:"role"`
+	Content    string          `json:"content,omitempty"`
+	Thinking   []ThinkingBlock `json:"thinking,omitempty"` // reasoning the model produced before answering
+	ToolCalls  []ToolCall      `json:"tool_calls,omitempty"`
+	ToolCallID string          `json:"tool_call_id,omitempty"` // the call a tool message answers
+	IsError    bool            `json:"is_error,omitempty"`     // whether a tool message reports a failure
+}
+
+// ThinkingBlock is a piece of model reasoning. Providers that require reasoning to be sent back
+// verify it with Signature; redacted reasoning carries only its encrypted Data.
+type ThinkingBlock struct {
+	Text      string `json:"text,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Data      string `json:"data,omitempty"`
+}
+
+// ToolCall is a request from the model to run a tool
+type ToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"` // JSON-encoded arguments
+}
+
+// ToolDefinition describes a tool the model may call
+type ToolDefinition struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Parameters  map[string]any `json:"parameters"` // JSON schema of the arguments
+}
+
+// ChatRequest asks a provider for the next assistant message
+type ChatRequest struct {
+	Model    string
+	Messages []Message
+	Tools    []ToolDefinition
+}
+
+// ChatResponse is a provider's reply to a ChatRequest
+type ChatResponse struct {
+	Message    Message
+	Usage      Usage
+	StopReason string
+}
+
+// Usage reports the tokens a request consumed. Cached prompt tokens are included in PromptTokens.
+type Usage struct {
+	PromptTokens        int
+	CompletionTokens    int
+	CacheReadTokens     int
+	CacheCreationTokens int
+}
+
+// Provider is a chat model backend
+type Provider interface {
+	Chat(ctx context.Context, request ChatRequest) (ChatResponse, error)
+}
+
+// errEmptyResponse is returned when a provider's reply contains no message
+var errEmptyResponse = errors.New("provider returned no message")
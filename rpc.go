@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// rpcProtocolVersion is increased when the stdio protocol changes incompatibly
+const rpcProtocolVersion = 1
+
+// maxRPCMessageSize bounds one protocol line, which can carry whole editor buffers
+const maxRPCMessageSize = 16 << 20
+
+// JSON-RPC 2.0 error codes. The codes from -32000 are this protocol's own.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcTurnFailed     = -32000 // the prompt's turn stopped with an error
+	rpcBusy           = -32001 // a prompt is already running
+	rpcNotInitialized = -32002 // initialize has not been called
+	rpcCancelled      = -32800 // the prompt was cancelled, as in LSP
+)
+
+// rpcRequest is a JSON-RPC request, or a notification when it has no ID
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// rpcInitializeParams are the parameters of initialize
+type rpcInitializeParams struct {
+	ClientName  string `json:"client_name"`  // the editor's name, for the agent's log
+	Model       string `json:"model"`        // model to use; defaults to the agent's
+	AutoApprove bool   `json:"auto_approve"` // run tools that change the workspace without asking
+}
+
+type rpcInitializeResult struct {
+	ProtocolVersion int    `json:"protocol_version"`
+	SessionID       string `json:"session_id"`
+	Model           string `json:"model"`
+	WorkDir         string `json:"work_dir"`
+}
+
+// rpcPromptParams are the parameters of prompt
+type rpcPromptParams struct {
+	Text    string          `json:"text"`
+	Context []editorContext `json:"context"`
+}
+
+type rpcPromptResult struct {
+	Content string   `json:"content"`          // the last assistant message of the turn
+	Notes   []string `json:"notes,omitempty"`  // what was attached to the prompt or skipped
+	Commit  string   `json:"commit,omitempty"` // the auto-commit of the turn's changes
+}
+
+// rpcApproveParams are the parameters of approve
+type rpcApproveParams struct {
+	ApprovalID string `json:"approval_id"`
+	Approved   bool   `json:"approved"`
+}
+
+// editorContext is an editor buffer shared with a prompt: its unsaved text, the selection in it,
+// or both
+type editorContext struct {
+	Path      string           `json:"path"`
+	Content   string           `json:"content,omitempty"`
+	Selection *editorSelection `json:"selection,omitempty"`
+}
+
+// editorSelection is a selected range of lines, counting from 1
+type editorSelection struct {
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+	Text      string `json:"text"`
+}
+
+// rpcServer drives the agent for an editor over newline-delimited JSON-RPC 2.0. Prompts run in the
+// background, so cancel and approve requests are answered while a turn is running.
+type rpcServer struct {
+	agent       *Agent
+	ctx         context.Context
+	writeMu     sync.Mutex // serializes writes and event numbering
+	out         *json.Encoder
+	nextEvent   int
+	mu          sync.Mutex
+	initialized bool
+	messages    []Message          // only the running prompt changes the conversation
+	cancel      context.CancelFunc // cancels the running prompt; nil when idle
+	approvals   approvalQueue
+	prompts     sync.WaitGroup
+}
+
+func newRPCServer(ctx context.Context, agent *Agent, out io.Writer) *rpcServer {
+	return &rpcServer{agent: agent, ctx: ctx, out: json.NewEncoder(out)}
+}
+
+// serve answers the requests read from in until it ends or a shutdown request arrives
+func (s *rpcServer) serve(in io.Reader) error {
+	defer s.stop()
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64<<10), maxRPCMessageSize)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var req rpcRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			s.respond(json.RawMessage("null"), nil, &rpcError{Code: rpcParseError, Message: fmt.Sprintf("parse error: %v", err)})
+			continue
+		}
+		if req.JSONRPC != "2.0" || req.Method == "" {
+			s.respond(req.ID, nil, &rpcError{Code: rpcInvalidRequest, Message: `invalid request: expected "jsonrpc": "2.0" and a method`})
+			continue
+		}
+		if req.Method == "shutdown" {
+			s.stop()
+			s.respond(req.ID, nil, nil)
+			return nil
+		}
+		s.handle(req)
+	}
+	return scanner.Err()
+}
+
+// stop cancels the running prompt and waits for its response to be written
+func (s *rpcServer) stop() {
+	s.mu.Lock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+	s.prompts.Wait()
+}
+
+func (s *rpcServer) handle(req rpcRequest) {
+	s.mu.Lock()
+	initialized := s.initialized
+	s.mu.Unlock()
+	if !initialized && req.Method != "initialize" {
+		s.respond(req.ID, nil, &rpcError{Code: rpcNotInitialized, Message: "call initialize first"})
+		return
+	}
+
+	var result any
+	var err error
+	switch req.Method {
+	case "initialize":
+		result, err = s.initialize(req.Params)
+	case "prompt":
+		s.prompt(req) // answered when the turn ends
+		return
+	case "cancel":
+		err = s.cancelPrompt()
+	case "approve":
+		err = s.approve(req.Params)
+	default:
+		err = &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("unknown method %q", req.Method)}
+	}
+	s.respond(req.ID, result, err)
+}
+
+func (s *rpcServer) initialize(raw json.RawMessage) (any, error) {
+	var params rpcInitializeParams
+	if err := decodeParams(raw, &params); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.initialized {
+		return nil, &rpcError{Code: rpcInvalidRequest, Message: "already initialized"}
+	}
+	if params.Model != "" {
+		if err := s.agent.useModel(params.Model); err != nil {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+		}
+	}
+	s.agent.events = s.notifyEvent
+	if !params.AutoApprove {
+		s.agent.approve = s.requestApproval
+	}
+	s.initialized = true
+	s.agent.logger().Info("editor connected", "client", params.ClientName)
+
+	workDir, err := os.Getwd()
+	if s.agent.workDir != "" {
+		workDir, err = s.agent.workDir, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rpcInitializeResult{ProtocolVersion: rpcProtocolVersion, SessionID: s.agent.sessionID, Model: s.agent.model, WorkDir: workDir}, nil
+}
+
+// prompt starts a turn in the background and answers the request when it ends
+func (s *rpcServer) prompt(req rpcRequest) {
+	var params rpcPromptParams
+	if err := decodeParams(req.Params, &params); err != nil {
+		s.respond(req.ID, nil, err)
+		return
+	}
+	if strings.TrimSpace(params.Text) == "" {
+		s.respond(req.ID, nil, &rpcError{Code: rpcInvalidParams, Message: "text is required"})
+		return
+	}
+
+	s.mu.Lock()
+	if s.cancel != nil {
+		s.mu.Unlock()
+		s.respond(req.ID, nil, &rpcError{Code: rpcBusy, Message: "a prompt is already running"})
+		return
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.cancel = cancel
+	s.prompts.Add(1)
+	s.mu.Unlock()
+
+	go func() {
+		defer s.prompts.Done()
+		result, err := s.runPrompt(ctx, params)
+		s.mu.Lock()
+		cancel()
+		s.cancel = nil
+		s.mu.Unlock()
+		s.respond(req.ID, result, err)
+	}()
+}
+
+// runPrompt drives a turn like the REPL does: attach the referenced files and editor buffers, drive
+// the conversation until the model is idle, then auto-commit if enabled
+func (s *rpcServer) runPrompt(ctx context.Context, params rpcPromptParams) (rpcPromptResult, error) {
+	text, notes := s.agent.expandMentions(ctx, params.Text)
+	attached, contextNotes := formatEditorContext(params.Context)
+	result := rpcPromptResult{Notes: append(notes, contextNotes...)}
+
+	s.agent.budget.reset()
+	messages, err := s.agent.DriveConversation(ctx, append(s.messages, Message{Role: RoleUser, Content: text + attached}), nil, nil)
+	s.messages = messages
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == RoleAssistant && messages[i].Content != "" {
+			result.Content = messages[i].Content
+			break
+		}
+	}
+
+	if s.agent.autoCommit {
+		hash, commitErr := s.agent.commitTurn(s.ctx, messages)
+		if commitErr != nil {
+			s.agent.emit(agentEvent{Type: eventError, Content: fmt.Sprintf("Auto-commit failed: %v", commitErr)})
+		}
+		result.Commit = hash
+	}
+	if err != nil {
+		s.agent.emit(agentEvent{Type: eventError, Content: err.Error()})
+	}
+	s.agent.emit(agentEvent{Type: eventTurnEnd})
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return result, &rpcError{Code: rpcCancelled, Message: "prompt cancelled"}
+	case err != nil:
+		return result, &rpcError{Code: rpcTurnFailed, Message: err.Error()}
+	}
+	return result, nil
+}
+
+func (s *rpcServer) cancelPrompt() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel == nil {
+		return &rpcError{Code: rpcInvalidRequest, Message: "no prompt is running"}
+	}
+	s.cancel()
+	return nil
+}
+
+func (s *rpcServer) approve(raw json.RawMessage) error {
+	var params rpcApproveParams
+	if err := decodeParams(raw, &params); err != nil {
+		return err
+	}
+	if !s.approvals.answer(params.ApprovalID, params.Approved) {
+		return &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("no pending approval %q", params.ApprovalID)}
+	}
+	return nil
+}
+
+// requestApproval asks the editor to approve a tool call and waits for its approve request
+func (s *rpcServer) requestApproval(ctx context.Context, toolCall ToolCall, diff string) (bool, error) {
+	return s.approvals.wait(ctx, func(id string) {
+		s.notifyEvent(agentEvent{Type: eventApprovalRequest, ApprovalID: id, ToolCall: &toolCall, Diff: diff})
+	})
+}
+
+// notifyEvent sends an agent event to the editor as an event notification
+func (s *rpcServer) notifyEvent(event agentEvent) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.nextEvent++
+	event.ID = s.nextEvent
+	s.out.Encode(rpcNotification{JSONRPC: "2.0", Method: "event", Params: event})
+}
+
+// respond answers a request; notifications get no answer
+func (s *rpcServer) respond(id json.RawMessage, result any, err error) {
+	if len(id) == 0 {
+		return
+	}
+	resp := rpcResponse{JSONRPC: "2.0", ID: id}
+	if err != nil {
+		var rpcErr *rpcError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &rpcError{Code: rpcInternalError, Message: err.Error()}
+		}
+		resp.Error = rpcErr
+	} else if resp.Result, err = json.Marshal(result); err != nil {
+		resp.Error = &rpcError{Code: rpcInternalError, Message: err.Error()}
+	}
+	s.write(resp)
+}
+
+// write sends one message per line
+func (s *rpcServer) write(message any) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.out.Encode(message)
+}
+
+// decodeParams decodes request parameters; missing parameters leave params at their defaults
+func decodeParams(raw json.RawMessage, params any) error {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(raw, params); err != nil {
+		return &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("invalid params: %v", err)}
+	}
+	return nil
+}
+
+// formatEditorContext renders the buffers and selections an editor shared with a prompt, with the
+// same size limits as @mentions. The returned notes tell what was skipped or truncated.
+func formatEditorContext(buffers []editorContext) (string, []string) {
+	var attached strings.Builder
+	var notes []string
+	total := 0
+	for _, buffer := range buffers {
+		if buffer.Path == "" {
+			notes = append(notes, "Skipping an editor buffer without a path")
+			continue
+		}
+		var blocks strings.Builder
+		if buffer.Content != "" {
+			content := buffer.Content
+			if len(content) > mentionMaxFileSize {
+				content = truncateBytes(content, mentionMaxFileSize) + fmt.Sprintf("\n[truncated: showing the first %s of %s, use read_file for the rest]\n", formatSize(mentionMaxFileSize), formatSize(int64(len(buffer.Content))))
+				notes = append(notes, fmt.Sprintf("Truncated the buffer of %s", buffer.Path))
+			}
+			writeContextBlock(&blocks, fmt.Sprintf("buffer path=%q", buffer.Path), "buffer", content)
+		}
+		if sel := buffer.Selection; sel != nil && sel.Text != "" {
+			writeContextBlock(&blocks, fmt.Sprintf("selection path=%q lines=\"%d-%d\"", buffer.Path, sel.StartLine, sel.EndLine), "selection", sel.Text)
+		}
+		if total+blocks.Len() > mentionMaxTotalSize {
+			notes = append(notes, fmt.Sprintf("Skipping the buffer of %s: attachments are limited to %s per message", buffer.Path, formatSize(mentionMaxTotalSize)))
+			continue
+		}
+		total += blocks.Len()
+		attached.WriteString(blocks.String())
+	}
+	if attached.Len() == 0 {
+		return "", notes
+	}
+	return "\n\nOpen in the editor:" + attached.String(), notes
+}
+
+func writeContextBlock(b *strings.Builder, open, close, content string) {
+	fmt.Fprintf(b, "\n\n<%s>\n%s", open, content)
+	if !strings.HasSuffix(content, "\n") {
+		b.WriteByte('\n')
+	}
+	fmt.Fprintf(b, "</%s>", close)
+}
+
+// runStdioRPC serves the JSON-RPC protocol on stdin and out until stdin closes
+func runStdioRPC(agent *Agent, out io.Writer) error {
+	defer agent.closeWorktree()
+	return newRPCServer(context.Background(), agent, out).serve(os.Stdin)
+}
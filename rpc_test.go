@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rpcTestMessage is a response or notification read from the server
+type rpcTestMessage struct {
+	ID     int             `json:"id"`
+	Method string          `json:"method"`
+	Params agentEvent      `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+type rpcTestClient struct {
+	t         *testing.T
+	in        *io.PipeWriter
+	out       *bufio.Scanner
+	nextID    int
+	responses map[int]rpcTestMessage // responses read while waiting for another one
+}
+
+func startRPCServer(t *testing.T, provider Provider) (*rpcTestClient, string) {
+	agent := NewAgent(provider, nil, "test-model")
+	agent.workDir = t.TempDir()
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- newRPCServer(context.Background(), agent, outW).serve(inR)
+		outW.Close()
+	}()
+	t.Cleanup(func() {
+		inW.Close()
+		go io.Copy(io.Discard, outR) // let a running prompt write its response
+		require.NoError(t, <-done)
+	})
+	return &rpcTestClient{t: t, in: inW, out: bufio.NewScanner(outR), responses: make(map[int]rpcTestMessage)}, agent.workDir
+}
+
+func (c *rpcTestClient) send(method string, params any) int {
+	c.nextID++
+	line, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": c.nextID, "method": method, "params": params})
+	require.NoError(c.t, err)
+	c.write(string(line))
+	return c.nextID
+}
+
+func (c *rpcTestClient) write(line string) {
+	_, err := io.WriteString(c.in, line+"\n")
+	require.NoError(c.t, err)
+}
+
+func (c *rpcTestClient) read() rpcTestMessage {
+	require.True(c.t, c.out.Scan(), "the server closed its output")
+	var message rpcTestMessage
+	require.NoError(c.t, json.Unmarshal(c.out.Bytes(), &message), c.out.Text())
+	return message
+}
+
+// readUntilResponse reads the event notifications sent before the response to request id
+func (c *rpcTestClient) readUntilResponse(id int) ([]agentEvent, rpcTestMessage) {
+	var events []agentEvent
+	for {
+		if message, ok := c.responses[id]; ok {
+			delete(c.responses, id)
+			return events, message
+		}
+		message := c.read()
+		if message.Method == "event" {
+			events = append(events, message.Params)
+		} else {
+			c.responses[message.ID] = message
+		}
+	}
+}
+
+func (c *rpcTestClient) initialize(params map[string]any) {
+	_, resp := c.readUntilResponse(c.send("initialize", params))
+	require.Nil(c.t, resp.Error)
+}
+
+func TestRPC_Protocol(t *testing.T) {
+	client, workDir := startRPCServer(t, &scriptedProvider{})
+
+	_, resp := client.readUntilResponse(client.send("prompt", map[string]string{"text": "hi"}))
+	assert.Equal(t, rpcNotInitialized, resp.Error.Code)
+
+	_, resp = client.readUntilResponse(client.send("initialize", map[string]any{"client_name": "test"}))
+	require.Nil(t, resp.Error)
+	var result rpcInitializeResult
+	require.NoError(t, json.Unmarshal(resp.Result, &result))
+	assert.Equal(t, rpcProtocolVersion, result.ProtocolVersion)
+	assert.Equal(t, "test-model", result.Model)
+	assert.Equal(t, workDir, result.WorkDir)
+
+	_, resp = client.readUntilResponse(client.send("initialize", nil))
+	assert.Equal(t, rpcInvalidRequest, resp.Error.Code)
+	_, resp = client.readUntilResponse(client.send("explode", nil))
+	assert.Equal(t, rpcMethodNotFound, resp.Error.Code)
+	_, resp = client.readUntilResponse(client.send("prompt", map[string]string{"text": " "}))
+	assert.Equal(t, rpcInvalidParams, resp.Error.Code)
+	_, resp = client.readUntilResponse(client.send("cancel", nil))
+	assert.Equal(t, rpcInvalidRequest, resp.Error.Code, "nothing to cancel")
+
+	client.write(`{"jsonrpc": "2.0", "id": 1, "method": `)
+	resp = client.read()
+	assert.Equal(t, rpcParseError, resp.Error.Code)
+	client.write(`{"id": 7, "method": "cancel"}`)
+	resp = client.read()
+	assert.Equal(t, 7, resp.ID)
+	assert.Equal(t, rpcInvalidRequest, resp.Error.Code)
+
+	// Notifications are not answered
+	client.write(`{"jsonrpc": "2.0", "method": "cancel"}`)
+	_, resp = client.readUntilResponse(client.send("shutdown", nil))
+	assert.Nil(t, resp.Error)
+}
+
+func TestRPC_PromptWithEditorContext(t *testing.T) {
+	provider := &lockedProvider{provider: &scriptedProvider{replies: []func(ChatRequest) (ChatResponse, error){
+		replyWithToolCall("list_dir", `{"path":"."}`),
+		replyWith("Looks fine."),
+	}}}
+	client, workDir := startRPCServer(t, provider)
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "notes.txt"), []byte("remember\n"), 0644))
+	client.initialize(nil)
+
+	events, resp := client.readUntilResponse(client.send("prompt", map[string]any{
+		"text": "Review @notes.txt and my selection",
+		"context": []editorContext{{
+			Path:      "main.go",
+			Content:   "package main\n\nfunc main() {}\n",
+			Selection: &editorSelection{StartLine: 3, EndLine: 3, Text: "func main() {}"},
+		}},
+	}))
+
+	require.Nil(t, resp.Error)
+	var result rpcPromptResult
+	require.NoError(t, json.Unmarshal(resp.Result, &result))
+	assert.Equal(t, rpcPromptResult{Content: "Looks fine.", Notes: []string{"Attached notes.txt"}}, result)
+
+	require.Len(t, events, 4)
+	assert.Equal(t, agentEvent{ID: 1, Type: eventToolCall, ToolCall: &ToolCall{ID: "call-1", Name: "list_dir", Arguments: `{"path":"."}`}}, events[0])
+	assert.Equal(t, agentEvent{ID: 2, Type: eventToolResult, ToolCallID: "call-1", Content: "notes.txt\n"}, events[1])
+	assert.Equal(t, agentEvent{ID: 3, Type: eventAssistantDelta, Content: "Looks fine."}, events[2])
+	assert.Equal(t, agentEvent{ID: 4, Type: eventTurnEnd}, events[3])
+
+	sent := provider.provider.requests[0].Messages
+	assert.Equal(t, "Review @notes.txt and my selection\n\nReferenced files:\n\n<file path=\"notes.txt\">\nremember\n</file>"+
+		"\n\nOpen in the editor:\n\n<buffer path=\"main.go\">\npackage main\n\nfunc main() {}\n</buffer>"+
+		"\n\n<selection path=\"main.go\" lines=\"3-3\">\nfunc main() {}\n</selection>", sent[len(sent)-1].Content)
+}
+
+func TestRPC_Approve(t *testing.T) {
+	provider := &lockedProvider{provider: &scriptedProvider{replies: []func(ChatRequest) (ChatResponse, error){
+		replyWithToolCall("write_to_file", `{"path":"a.txt","content":"hello\n"}`),
+		replyWith("Written."),
+	}}}
+	client, workDir := startRPCServer(t, provider)
+	client.initialize(nil)
+
+	id := client.send("prompt", map[string]string{"text": "write a.txt"})
+	var request agentEvent
+	for request.Type != eventApprovalRequest {
+		request = client.read().Params
+	}
+	assert.Equal(t, "--- /dev/null\n+++ b/a.txt\n@@ -0,0 +1,1 @@\n+hello\n", request.Diff)
+	assert.NoFileExists(t, filepath.Join(workDir, "a.txt"))
+
+	events, resp := client.readUntilResponse(client.send("approve", rpcApproveParams{ApprovalID: request.ApprovalID, Approved: true}))
+	require.Nil(t, resp.Error)
+	more, resp := client.readUntilResponse(id)
+	require.Nil(t, resp.Error)
+	events = append(events, more...)
+	assert.Equal(t, eventToolResult, events[0].Type)
+	assert.False(t, events[0].IsError)
+	assert.FileExists(t, filepath.Join(workDir, "a.txt"))
+
+	_, resp = client.readUntilResponse(client.send("approve", rpcApproveParams{ApprovalID: request.ApprovalID, Approved: true}))
+	assert.Equal(t, rpcInvalidParams, resp.Error.Code, "an approval is answered once")
+}
+
+func TestRPC_Cancel(t *testing.T) {
+	client, _ := startRPCServer(t, blockingProvider{})
+	client.initialize(map[string]any{"auto_approve": true})
+
+	id := client.send("prompt", map[string]string{"text": "wait"})
+	_, resp := client.readUntilResponse(client.send("prompt", map[string]string{"text": "again"}))
+	assert.Equal(t, rpcBusy, resp.Error.Code)
+
+	_, resp = client.readUntilResponse(client.send("cancel", nil))
+	require.Nil(t, resp.Error)
+	events, resp := client.readUntilResponse(id)
+	assert.Equal(t, rpcCancelled, resp.Error.Code)
+	assert.Equal(t, []agentEvent{{ID: 1, Type: eventError, Content: "context canceled"}, {ID: 2, Type: eventTurnEnd}}, events)
+}
+
+func TestFormatEditorContext_Limits(t *testing.T) {
+	big := strings.Repeat("x", 2*mentionMaxFileSize)
+
+	attached, notes := formatEditorContext([]editorContext{{Content: "lost"}, {Path: "big.txt", Content: big}, {Path: "empty.txt"}})
+
+	assert.Equal(t, []string{"Skipping an editor buffer without a path", "Truncated the buffer of big.txt"}, notes)
+	assert.Contains(t, attached, "[truncated: showing the first 64.0 KB of 128.0 KB, use read_file for the rest]")
+	assert.NotContains(t, attached, "empty.txt", "buffers without content or selection add nothing")
+
+	attached, _ = formatEditorContext([]editorContext{{Path: "wide.txt", Content: strings.Repeat("x", mentionMaxFileSize-1) + "€€"}})
+	assert.True(t, utf8.ValidString(attached))
+	assert.Contains(t, attached, strings.Repeat("x", mentionMaxFileSize-1)+"\n[truncated:", "the character crossing the limit is dropped whole")
+}
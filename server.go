@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultServeAddr keeps the API on the local machine unless --addr says otherwise
+	defaultServeAddr = "127.0.0.1:8080"
+	// serverTokenEnv holds the bearer token clients must present
+	serverTokenEnv = "AGENT_SERVER_TOKEN"
+	// sseKeepAlive is how often an idle event stream sends a comment, so proxies keep it open
+	sseKeepAlive = 15 * time.Second
+)
+
+// workspaceNamePattern restricts workspace names to a single safe path element
+var workspaceNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// apiServer serves the agent over HTTP. Each session is a conversation with its own agent and
+// workspace directory; its events are streamed to clients with Server-Sent Events.
+type apiServer struct {
+	template   *Agent // configuration new session agents copy
+	token      string
+	workspaces string          // directory holding the session workspaces
+	ctx        context.Context // cancelled on shutdown, stopping running turns
+	mu         sync.Mutex
+	sessions   map[string]*apiSession
+}
+
+// apiSession is one conversation driven through the API
+type apiSession struct {
+	id        string
+	workspace string
+	agent     *Agent
+	mu        sync.Mutex
+	messages  []Message
+	cancel    context.CancelFunc // cancels the running turn; nil when idle
+	events    []agentEvent
+	changed   chan struct{} // closed and replaced whenever an event is added
+	closed    chan struct{} // closed when the session is deleted
+	approvals approvalQueue
+}
+
+// createSessionRequest is the body of POST /sessions
+type createSessionRequest struct {
+	Workspace   string `json:"workspace"`    // workspace directory name; defaults to the session ID
+	Model       string `json:"model"`        // model to use; defaults to the server's
+	AutoApprove bool   `json:"auto_approve"` // run tools that change the workspace without asking
+}
+
+// sessionInfo describes a session in API responses
+type sessionInfo struct {
+	ID        string `json:"id"`
+	Workspace string `json:"workspace"`
+	Model     string `json:"model"`
+	Busy      bool   `json:"busy"`
+}
+
+func newAPIServer(ctx context.Context, template *Agent, token, workspaces string) *apiServer {
+	return &apiServer{template: template, token: token, workspaces: workspaces, ctx: ctx, sessions: make(map[string]*apiSession)}
+}
+
+// handler routes the API, requiring the bearer token on every request, and serves the web UI
+func (s *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /sessions", s.handleCreateSession)
+	mux.HandleFunc("GET /sessions", s.handleListSessions)
+	mux.HandleFunc("GET /sessions/{id}", s.withSession(s.handleGetSession))
+	mux.HandleFunc("DELETE /sessions/{id}", s.withSession(s.handleDeleteSession))
+	mux.HandleFunc("POST /sessions/{id}/messages", s.withSession(s.handlePostMessage))
+	mux.HandleFunc("GET /sessions/{id}/events", s.withSession(s.handleEvents))
+	mux.HandleFunc("POST /sessions/{id}/approvals/{approval}", s.withSession(s.handleApproval))
+	mux.HandleFunc("POST /sessions/{id}/cancel", s.withSession(s.handleCancel))
+	mux.HandleFunc("GET /sessions/{id}/transcript", s.withSession(s.handleTranscript))
+
+	root := http.NewServeMux()
+	handleWebUI(root)
+	root.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAPIError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+	return root
+}
+
+// withSession looks up the session named in the path
+func (s *apiServer) withSession(handle func(http.ResponseWriter, *http.Request, *apiSession)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		session, ok := s.sessions[r.PathValue("id")]
+		s.mu.Unlock()
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, "unknown session %q", r.PathValue("id"))
+			return
+		}
+		handle(w, r, session)
+	}
+}
+
+func (s *apiServer) handleCreateSession(w http.ResponseWriter, r *http.Request) {
+	var req createSessionRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid request body: %v", err)
+			return
+		}
+	}
+
+	id := newSessionID()
+	if req.Workspace == "" {
+		req.Workspace = id
+	}
+	if !workspaceNamePattern.MatchString(req.Workspace) {
+		writeAPIError(w, http.StatusBadRequest, "invalid workspace name %q", req.Workspace)
+		return
+	}
+	workDir := filepath.Join(s.workspaces, req.Workspace)
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "creating workspace: %v", err)
+		return
+	}
+
+	agent, err := s.template.sessionAgent(workDir)
+	if err == nil && req.Model != "" {
+		err = agent.useModel(req.Model)
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	session := &apiSession{
+		id:        id,
+		workspace: req.Workspace,
+		agent:     agent,
+		changed:   make(chan struct{}),
+		closed:    make(chan struct{}),
+	}
+	agent.events = session.publish
+	if !req.AutoApprove {
+		agent.approve = session.requestApproval
+	}
+
+	s.mu.Lock()
+	s.sessions[id] = session
+	s.mu.Unlock()
+	writeJSON(w, http.StatusCreated, session.info())
+}
+
+func (s *apiServer) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	infos := make([]sessionInfo, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		infos = append(infos, session.info())
+	}
+	s.mu.Unlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	writeJSON(w, http.StatusOK, infos)
+}
+
+func (s *apiServer) handleGetSession(w http.ResponseWriter, r *http.Request, session *apiSession) {
+	writeJSON(w, http.StatusOK, session.info())
+}
+
+// handleDeleteSession cancels the session's turn and forgets it; its workspace is kept
+func (s *apiServer) handleDeleteSession(w http.ResponseWriter, r *http.Request, session *apiSession) {
+	s.mu.Lock()
+	_, ok := s.sessions[session.id]
+	delete(s.sessions, session.id)
+	s.mu.Unlock()
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "unknown session %q", session.id)
+		return
+	}
+
+	session.mu.Lock()
+	if session.cancel != nil {
+		session.cancel()
+	}
+	close(session.closed)
+	session.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePostMessage starts a turn with the user's message. The turn runs in the background and
+// reports its progress on the event stream.
+func (s *apiServer) handlePostMessage(w http.ResponseWriter, r *http.Request, session *apiSession) {
+	var req struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid request body: %v", err)
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		writeAPIError(w, http.StatusBadRequest, "content is required")
+		return
+	}
+
+	session.mu.Lock()
+	if session.cancel != nil {
+		session.mu.Unlock()
+		writeAPIError(w, http.StatusConflict, "a turn is already running")
+		return
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	session.cancel = cancel
+	messages := append(session.messages, Message{Role: RoleUser, Content: req.Content})
+	session.mu.Unlock()
+
+	session.publish(agentEvent{Type: eventUserMessage, Content: req.Content})
+	go session.runTurn(ctx, messages)
+	writeJSON(w, http.StatusAccepted, session.info())
+}
+
+// handleEvents streams the session's events. Clients resume after the last event they saw with
+// the Last-Event-ID header or the after query parameter.
+func (s *apiServer) handleEvents(w http.ResponseWriter, r *http.Request, session *apiSession) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	after := r.Header.Get("Last-Event-ID")
+	if after == "" {
+		after = r.URL.Query().Get("after")
+	}
+	next := 0
+	if after != "" {
+		n, err := strconv.Atoi(after)
+		if err != nil || n < 0 {
+			writeAPIError(w, http.StatusBadRequest, "invalid event ID %q", after)
+			return
+		}
+		next = n
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		events, changed := session.eventsAfter(next)
+		for _, event := range events {
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+			next = event.ID
+		}
+		flusher.Flush()
+
+		select {
+		case <-changed:
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-session.closed:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *apiServer) handleApproval(w http.ResponseWriter, r *http.Request, session *apiSession) {
+	var req struct {
+		Approved bool `json:"approved"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid request body: %v", err)
+		return
+	}
+
+	if !session.approvals.answer(r.PathValue("approval"), req.Approved) {
+		writeAPIError(w, http.StatusNotFound, "no pending approval %q", r.PathValue("approval"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *apiServer) handleCancel(w http.ResponseWriter, r *http.Request, session *apiSession) {
+	session.mu.Lock()
+	cancel := session.cancel
+	session.mu.Unlock()
+	if cancel == nil {
+		writeAPIError(w, http.StatusConflict, "no turn is running")
+		return
+	}
+	cancel()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleTranscript returns the messages of the completed turns
+func (s *apiServer) handleTranscript(w http.ResponseWriter, r *http.Request, session *apiSession) {
+	session.mu.Lock()
+	messages := append([]Message{}, session.messages...)
+	session.mu.Unlock()
+	writeJSON(w, http.StatusOK, messages)
+}
+
+// runTurn drives the conversation until the model is idle, then records the transcript
+func (session *apiSession) runTurn(ctx context.Context, messages []Message) {
+	session.agent.budget.reset()
+	messages, err := session.agent.DriveConversation(ctx, messages, nil, nil)
+
+	session.mu.Lock()
+	session.cancel()
+	session.cancel = nil
+	session.messages = messages
+	session.mu.Unlock()
+
+	if err != nil {
+		session.publish(agentEvent{Type: eventError, Content: err.Error()})
+	}
+	session.publish(agentEvent{Type: eventTurnEnd})
+}
+
+// publish adds an event to the session's log and wakes its streams
+func (session *apiSession) publish(event agentEvent) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	event.ID = len(session.events) + 1
+	session.events = append(session.events, event)
+	close(session.changed)
+	session.changed = make(chan struct{})
+}
+
+// eventsAfter returns the events after the given ID and a channel closed when more arrive
+func (session *apiSession) eventsAfter(id int) ([]agentEvent, <-chan struct{}) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if id > len(session.events) {
+		id = len(session.events)
+	}
+	return append([]agentEvent{}, session.events[id:]...), session.changed
+}
+
+// requestApproval asks the client to approve a tool call and waits for the answer
+func (session *apiSession) requestApproval(ctx context.Context, toolCall ToolCall, diff string) (bool, error) {
+	return session.approvals.wait(ctx, func(id string) {
+		session.publish(agentEvent{Type: eventApprovalRequest, ApprovalID: id, ToolCall: &toolCall, Diff: diff})
+	})
+}
+
+func (session *apiSession) info() sessionInfo {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return sessionInfo{ID: session.id, Workspace: session.workspace, Model: session.agent.model, Busy: session.cancel != nil}
+}
+
+// sessionAgent returns a new agent configured like a that works in workDir
+func (a *Agent) sessionAgent(workDir string) (*Agent, error) {
+	agent := &Agent{
+		provider:          a.provider,
+		toolHandlers:      make(map[string]ToolHandler),
+		model:             a.model,
+		workDir:           workDir,
+		changes:           newChangeTracker(),
+		sessionID:         newSessionID(),
+		isolateSubAgents:  a.isolateSubAgents,
+		maxDepth:          a.maxDepth,
+		maxParallelAgents: a.maxParallelAgents,
+		agentTypes:        a.agentTypes,
+		router:            a.router,
+		allowedTools:      a.allowedTools,
+	}
+	if a.budget != nil {
+		agent.budget = newAgentBudget(a.budget.maxTokens, a.budget.maxIterations)
+	}
+	agent.transcripts = newTranscriptStore(sessionTranscriptDir(agent.sessionID))
+	agent.setupTools()
+	if err := agent.restrictTools(a.allowedTools); err != nil {
+		return nil, err
+	}
+	return agent, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, format string, args ...any) {
+	writeJSON(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+// serve runs the HTTP API until interrupted: agent serve [--addr ADDR] [--token TOKEN] [--workspaces DIR]
+func serve(template *Agent, cwd string, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := flags.String("addr", defaultServeAddr, "Address to listen on")
+	token := flags.String("token", "", "Bearer token clients must send (overrides "+serverTokenEnv+"; generated when neither is set)")
+	workspaces := flags.String("workspaces", filepath.Join(projectConfigDir(cwd), "workspaces"), "Directory holding the session workspaces")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %q", strings.Join(flags.Args(), " "))
+	}
+
+	if *token == "" {
+		*token = os.Getenv(serverTokenEnv)
+	}
+	uiURL := "http://" + *addr + webUIPath
+	if *token == "" {
+		random := make([]byte, 24)
+		if _, err := rand.Read(random); err != nil {
+			return err
+		}
+		*token = hex.EncodeToString(random)
+		fmt.Printf("API token: %s\n", *token)
+		// The fragment is never sent to the server; the page reads the token from it
+		uiURL += "#token=" + *token
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{
+		Addr:        *addr,
+		Handler:     newAPIServer(ctx, template, *token, *workspaces).handler(),
+		BaseContext: func(net.Listener) context.Context { return ctx }, // ends event streams on shutdown
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("Serving the agent API on http://%s with workspaces in %s\n", *addr, *workspaces)
+	fmt.Printf("Web UI: %s\n", uiURL)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
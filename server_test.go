@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testServerToken = "secret-token"
+
+// lockedProvider serializes a scripted provider, since turns run on their own goroutines
+type lockedProvider struct {
+	mu       sync.Mutex
+	provider *scriptedProvider
+}
+
+func (p *lockedProvider) Chat(ctx context.Context, request ChatRequest) (ChatResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.provider.Chat(ctx, request)
+}
+
+// blockingProvider answers only when its turn is cancelled
+type blockingProvider struct{}
+
+func (blockingProvider) Chat(ctx context.Context, request ChatRequest) (ChatResponse, error) {
+	<-ctx.Done()
+	return ChatResponse{}, ctx.Err()
+}
+
+func replyWithToolCall(name, arguments string) func(ChatRequest) (ChatResponse, error) {
+	return func(ChatRequest) (ChatResponse, error) {
+		return ChatResponse{Message: Message{Role: RoleAssistant, ToolCalls: []ToolCall{{ID: "call-1", Name: name, Arguments: arguments}}}}, nil
+	}
+}
+
+func newTestAPIServer(t *testing.T, provider Provider) (*httptest.Server, string) {
+	workspaces := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	server := httptest.NewServer(newAPIServer(ctx, NewAgent(provider, nil, "test-model"), testServerToken, workspaces).handler())
+	t.Cleanup(func() {
+		cancel()
+		server.Close()
+	})
+	return server, workspaces
+}
+
+func apiRequest(t *testing.T, server *httptest.Server, method, path, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+testServerToken)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func createTestSession(t *testing.T, server *httptest.Server, body string) sessionInfo {
+	t.Helper()
+	resp := apiRequest(t, server, http.MethodPost, "/sessions", body)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var info sessionInfo
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&info))
+	return info
+}
+
+// readEvents reads the session's event stream until an event of type until arrives
+func readEvents(t *testing.T, stream *bufio.Reader, until string) []agentEvent {
+	t.Helper()
+	var events []agentEvent
+	for {
+		line, err := stream.ReadString('\n')
+		require.NoError(t, err)
+		data, ok := strings.CutPrefix(strings.TrimSpace(line), "data: ")
+		if !ok {
+			continue
+		}
+		var event agentEvent
+		require.NoError(t, json.Unmarshal([]byte(data), &event))
+		events = append(events, event)
+		if event.Type == until {
+			return events
+		}
+	}
+}
+
+func openEvents(t *testing.T, server *httptest.Server, id string) *bufio.Reader {
+	resp := apiRequest(t, server, http.MethodGet, "/sessions/"+id+"/events", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	return bufio.NewReader(resp.Body)
+}
+
+func TestAPIServer_RequiresToken(t *testing.T) {
+	server, _ := newTestAPIServer(t, &scriptedProvider{})
+
+	for _, header := range []string{"", "Bearer wrong", testServerToken} {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/sessions", nil)
+		require.NoError(t, err)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, header)
+		assert.Equal(t, "Bearer", resp.Header.Get("WWW-Authenticate"))
+	}
+}
+
+func TestAPIServer_Turn(t *testing.T) {
+	provider := &lockedProvider{provider: &scriptedProvider{replies: []func(ChatRequest) (ChatResponse, error){
+		replyWithToolCall("list_dir", `{"path":"."}`),
+		replyWith("The workspace has notes.txt."),
+	}}}
+	server, workspaces := newTestAPIServer(t, provider)
+	session := createTestSession(t, server, `{"workspace":"demo"}`)
+	require.NoError(t, os.WriteFile(filepath.Join(workspaces, "demo", "notes.txt"), []byte("hi"), 0644))
+	stream := openEvents(t, server, session.ID)
+
+	resp := apiRequest(t, server, http.MethodPost, "/sessions/"+session.ID+"/messages", `{"content":"What is here?"}`)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	events := readEvents(t, stream, eventTurnEnd)
+
+	require.Len(t, events, 5)
+	assert.Equal(t, agentEvent{ID: 1, Type: eventUserMessage, Content: "What is here?"}, events[0])
+	assert.Equal(t, agentEvent{ID: 2, Type: eventToolCall, ToolCall: &ToolCall{ID: "call-1", Name: "list_dir", Arguments: `{"path":"."}`}}, events[1])
+	assert.Equal(t, agentEvent{ID: 3, Type: eventToolResult, ToolCallID: "call-1", Content: "notes.txt\n"}, events[2])
+	assert.Equal(t, agentEvent{ID: 4, Type: eventAssistantDelta, Content: "The workspace has notes.txt."}, events[3])
+	assert.Equal(t, eventTurnEnd, events[4].Type)
+
+	resp = apiRequest(t, server, http.MethodGet, "/sessions/"+session.ID+"/transcript", "")
+	var transcript []Message
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&transcript))
+	require.Len(t, transcript, 4)
+	assert.Equal(t, "What is here?", transcript[0].Content)
+	assert.Equal(t, "The workspace has notes.txt.", transcript[3].Content)
+
+	// A reconnecting client resumes after the last event it saw
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/sessions/"+session.ID+"/events", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+testServerToken)
+	req.Header.Set("Last-Event-ID", "4")
+	resumed, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resumed.Body.Close()
+	assert.Equal(t, []agentEvent{{ID: 5, Type: eventTurnEnd}}, readEvents(t, bufio.NewReader(resumed.Body), eventTurnEnd))
+}
+
+func TestAPIServer_ToolsConfinedToWorkspace(t *testing.T) {
+	var secret string
+	provider := &lockedProvider{provider: &scriptedProvider{replies: []func(ChatRequest) (ChatResponse, error){
+		replyWithToolCall("read_file", `{"path":"../secret.txt"}`),
+		func(request ChatRequest) (ChatResponse, error) {
+			return replyWithToolCall("read_file", `{"path":"`+secret+`"}`)(request)
+		},
+		replyWith("Could not read it."),
+	}}}
+	server, workspaces := newTestAPIServer(t, provider)
+	secret = filepath.Join(workspaces, "secret.txt")
+	require.NoError(t, os.WriteFile(secret, []byte("top secret"), 0644))
+	session := createTestSession(t, server, `{"workspace":"demo","auto_approve":true}`)
+	stream := openEvents(t, server, session.ID)
+
+	apiRequest(t, server, http.MethodPost, "/sessions/"+session.ID+"/messages", `{"content":"Read the secret"}`)
+	events := readEvents(t, stream, eventTurnEnd)
+
+	var results []agentEvent
+	for _, event := range events {
+		if event.Type == eventToolResult {
+			results = append(results, event)
+		}
+	}
+	require.Len(t, results, 2)
+	for _, result := range results {
+		assert.True(t, result.IsError)
+		assert.Contains(t, result.Content, "is outside the workspace")
+		assert.NotContains(t, result.Content, "top secret")
+	}
+}
+
+func TestAPIServer_Approvals(t *testing.T) {
+	provider := &lockedProvider{provider: &scriptedProvider{replies: []func(ChatRequest) (ChatResponse, error){
+		replyWithToolCall("write_to_file", `{"path":"a.txt","content":"approved"}`),
+		replyWith("Written."),
+		replyWithToolCall("write_to_file", `{"path":"a.txt","content":"denied"}`),
+		replyWith("Not written."),
+	}}}
+	server, workspaces := newTestAPIServer(t, provider)
+	session := createTestSession(t, server, "")
+	stream := openEvents(t, server, session.ID)
+
+	diffs := map[bool]string{
+		true:  "--- /dev/null\n+++ b/a.txt\n@@ -0,0 +1,1 @@\n+approved\n\\ No newline at end of file\n",
+		false: "--- a/a.txt\n+++ b/a.txt\n@@ -1,1 +1,1 @@\n-approved\n\\ No newline at end of file\n+denied\n\\ No newline at end of file\n",
+	}
+	for _, approved := range []bool{true, false} {
+		apiRequest(t, server, http.MethodPost, "/sessions/"+session.ID+"/messages", `{"content":"write"}`)
+		events := readEvents(t, stream, eventApprovalRequest)
+		call, request := events[len(events)-2], events[len(events)-1]
+		assert.Equal(t, "write_to_file", request.ToolCall.Name)
+		assert.Equal(t, diffs[approved], request.Diff)
+		assert.Equal(t, diffs[approved], call.Diff, "the tool call event previews the change too")
+
+		body, _ := json.Marshal(map[string]bool{"approved": approved})
+		resp := apiRequest(t, server, http.MethodPost, "/sessions/"+session.ID+"/approvals/"+request.ApprovalID, string(body))
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		events = readEvents(t, stream, eventTurnEnd)
+		assert.Equal(t, !approved, events[0].IsError, "tool result of an approved=%v call", approved)
+	}
+
+	content, err := os.ReadFile(filepath.Join(workspaces, session.Workspace, "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "approved", string(content), "the denied write left the file unchanged")
+
+	resp := apiRequest(t, server, http.MethodPost, "/sessions/"+session.ID+"/approvals/1", `{"approved":true}`)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestAPIServer_CancelTurn(t *testing.T) {
+	server, _ := newTestAPIServer(t, blockingProvider{})
+	session := createTestSession(t, server, `{"auto_approve":true}`)
+	stream := openEvents(t, server, session.ID)
+
+	resp := apiRequest(t, server, http.MethodPost, "/sessions/"+session.ID+"/cancel", "")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	apiRequest(t, server, http.MethodPost, "/sessions/"+session.ID+"/messages", `{"content":"wait"}`)
+	resp = apiRequest(t, server, http.MethodPost, "/sessions/"+session.ID+"/messages", `{"content":"again"}`)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp = apiRequest(t, server, http.MethodPost, "/sessions/"+session.ID+"/cancel", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	events := readEvents(t, stream, eventTurnEnd)
+
+	assert.Equal(t, []agentEvent{
+		{ID: 1, Type: eventUserMessage, Content: "wait"},
+		{ID: 2, Type: eventError, Content: "context canceled"},
+		{ID: 3, Type: eventTurnEnd},
+	}, events)
+}
+
+func TestAPIServer_Sessions(t *testing.T) {
+	server, workspaces := newTestAPIServer(t, &scriptedProvider{})
+
+	resp := apiRequest(t, server, http.MethodPost, "/sessions", `{"workspace":"../escape"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = apiRequest(t, server, http.MethodPost, "/sessions", `{"model":"missing"}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "without configured models any model name is passed to the provider")
+
+	session := createTestSession(t, server, `{"workspace":"shared"}`)
+	assert.DirExists(t, filepath.Join(workspaces, "shared"))
+	assert.Equal(t, "test-model", session.Model)
+
+	resp = apiRequest(t, server, http.MethodGet, "/sessions", "")
+	var sessions []sessionInfo
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&sessions))
+	assert.Len(t, sessions, 2)
+
+	resp = apiRequest(t, server, http.MethodDelete, "/sessions/"+session.ID, "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp = apiRequest(t, server, http.MethodGet, "/sessions/"+session.ID, "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.DirExists(t, filepath.Join(workspaces, "shared"), "deleting a session keeps its workspace")
+}
+
+func TestAPIServer_WebUI(t *testing.T) {
+	server, _ := newTestAPIServer(t, &scriptedProvider{})
+
+	// The page is served without the token, which it asks for instead
+	resp, err := http.Get(server.URL + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, webUIPath, resp.Request.URL.Path, "the root redirects to the UI")
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/html")
+	page, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(page), `<script src="app.js">`)
+
+	for _, asset := range []string{"app.js", "style.css"} {
+		resp, err := http.Get(server.URL + webUIPath + asset)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, asset)
+	}
+	resp, err = http.Get(server.URL + "/sessions")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "the API still needs the token")
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// settingKind is the type of a setting's value
+type settingKind int
+
+const (
+	settingString settingKind = iota
+	settingInt
+	settingBool
+	settingList // comma-separated on the command line and in environment variables
+)
+
+// settingDefinition describes a setting and the places it can be set
+type settingDefinition struct {
+	key   string // dotted key in config.yaml
+	kind  settingKind
+	def   string // default value
+	flag  string // command-line flag, if any
+	env   string // environment variable, if any
+	usage string
+}
+
+// settingDefinitions lists every setting. Values are resolved with the precedence
+// flags > environment > project config > user config > defaults.
+var settingDefinitions = []settingDefinition{
+	{key: "model", def: DEFAULT_MODEL, flag: "model", env: "LLM_MODEL", usage: "AI model to use, a model name or a model defined in config.yaml"},
+	{key: "provider", def: providerOpenAI, flag: "provider", env: "LLM_PROVIDER", usage: "Model backend, openai, anthropic or ollama"},
+	{key: "endpoint", env: "LLM_ENDPOINT", usage: "Base URL of the OpenAI-compatible API"},
+	{key: "tool_mode", flag: "tool-mode", env: "LLM_TOOL_MODE", usage: "How tools are offered to OpenAI-compatible models: native, prompted or auto"},
+	{key: "thinking_budget", kind: settingInt, def: "0", flag: "thinking-budget", usage: "Tokens for extended thinking with the anthropic provider (0 disables thinking)"},
+	{key: "limits.max_agent_depth", kind: settingInt, def: strconv.Itoa(defaultMaxAgentDepth), flag: "max-agent-depth", usage: "Maximum nesting depth of run_agent sub-agents"},
+	{key: "limits.max_parallel_agents", kind: settingInt, def: strconv.Itoa(defaultMaxParallelAgents), flag: "max-parallel-agents", usage: "Maximum number of run_agents sub-agents running at once"},
+	{key: "limits.max_turn_tokens", kind: settingInt, def: "0", flag: "max-turn-tokens", usage: "Token budget shared by the agent and its sub-agents per turn (0 for unlimited)"},
+	{key: "limits.max_turn_iterations", kind: settingInt, def: "0", flag: "max-turn-iterations", usage: "Model call budget shared by the agent and its sub-agents per turn (0 for unlimited)"},
+	{key: "permissions.auto_commit", kind: settingBool, def: "false", flag: "auto-commit", usage: "Commit files modified by the agent to git after each turn"},
+	{key: "permissions.worktree", kind: settingBool, def: "false", flag: "worktree", usage: "Run the session in its own git worktree and branch"},
+	{key: "permissions.subagent_worktrees", kind: settingBool, def: "false", flag: "subagent-worktrees", usage: "Run each sub-agent in its own git worktree and branch"},
+	{key: "tools.enabled", kind: settingList, flag: "tools", usage: "Comma-separated tools the agent and its sub-agents may use (empty for all)"},
+	{key: "output.color", kind: settingBool, def: "true", flag: "color", usage: "Use colors in terminal output"},
+	{key: "output.markdown", kind: settingBool, def: "true", flag: "markdown", usage: "Render the assistant's markdown in the terminal"},
+	{key: "log.level", def: "warn", flag: "log-level", env: "LLM_LOG_LEVEL", usage: "Minimum level of diagnostic log messages: debug, info, warn or error"},
+	{key: "log.file", flag: "log-file", env: "LLM_LOG_FILE", usage: "File to write diagnostic log messages to instead of stderr"},
+	{key: "log.debug", kind: settingBool, def: "false", flag: "debug", usage: "Log the raw request and response of every model call, with secrets redacted, at debug level"},
+}
+
+// configSections are config.yaml sections that are not settings
+var configSections = map[string]bool{"providers": true, "models": true, "routing": true, "profiles": true}
+
+// settingsLayer is the settings from one source
+type settingsLayer struct {
+	source string
+	values map[string]string
+}
+
+// settingValue is a resolved setting and where it came from
+type settingValue struct {
+	value  string
+	source string
+}
+
+// Settings are the effective settings of a session
+type Settings struct {
+	profile string
+	values  map[string]settingValue
+}
+
+// loadSettingsLayers reads the settings in the user's and then the project's config.yaml,
+// each followed by its section for the named profile
+func loadSettingsLayers(projectDir, userDir, profile string) ([]settingsLayer, error) {
+	var layers []settingsLayer
+	profileFound := false
+	for _, dir := range []struct{ name, path string }{{"user", userDir}, {"project", projectDir}} {
+		if dir.path == "" {
+			continue
+		}
+		path := filepath.Join(dir.path, configFileName)
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var file map[string]any
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		values, err := flattenSettings(file, "")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		layers = append(layers, settingsLayer{source: fmt.Sprintf("%s config %s", dir.name, path), values: values})
+
+		if profile == "" {
+			continue
+		}
+		profiles, _ := file["profiles"].(map[string]any)
+		section, ok := profiles[profile].(map[string]any)
+		if !ok {
+			continue
+		}
+		profileFound = true
+		values, err = flattenSettings(section, "")
+		if err != nil {
+			return nil, fmt.Errorf("%s: profile %q: %w", path, profile, err)
+		}
+		layers = append(layers, settingsLayer{source: fmt.Sprintf("%s config %s, profile %s", dir.name, path, profile), values: values})
+	}
+	if profile != "" && !profileFound {
+		return nil, fmt.Errorf("unknown profile %q", profile)
+	}
+	return layers, nil
+}
+
+// flattenSettings turns nested YAML sections into dotted setting keys
+func flattenSettings(section map[string]any, prefix string) (map[string]string, error) {
+	values := make(map[string]string)
+	for name, value := range section {
+		key := prefix + name
+		if prefix == "" && configSections[name] {
+			continue
+		}
+		switch value := value.(type) {
+		case map[string]any:
+			nested, err := flattenSettings(value, key+".")
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range nested {
+				values[k] = v
+			}
+			continue
+		case []any:
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		case nil:
+			continue
+		default:
+			values[key] = fmt.Sprint(value)
+		}
+		if _, ok := findSetting(key); !ok {
+			return nil, fmt.Errorf("unknown setting %q", key)
+		}
+	}
+	return values, nil
+}
+
+func findSetting(key string) (settingDefinition, bool) {
+	for _, definition := range settingDefinitions {
+		if definition.key == key {
+			return definition, true
+		}
+	}
+	return settingDefinition{}, false
+}
+
+// registerSettingFlags defines a command-line flag for every setting that has one
+func registerSettingFlags(flags *flag.FlagSet) {
+	for _, definition := range settingDefinitions {
+		if definition.flag == "" {
+			continue
+		}
+		usage := definition.usage
+		if definition.env != "" {
+			usage += fmt.Sprintf(" (overrides %s env var)", definition.env)
+		}
+		switch definition.kind {
+		case settingBool:
+			def, _ := strconv.ParseBool(definition.def)
+			flags.Bool(definition.flag, def, usage)
+		case settingInt:
+			def, _ := strconv.Atoi(definition.def)
+			flags.Int(definition.flag, def, usage)
+		default:
+			flags.String(definition.flag, definition.def, usage)
+		}
+	}
+}
+
+// setFlags returns the values of the flags given on the command line
+func setFlags(flags *flag.FlagSet) map[string]string {
+	values := make(map[string]string)
+	flags.Visit(func(f *flag.Flag) {
+		values[f.Name] = f.Value.String()
+	})
+	return values
+}
+
+// resolveSettings merges the defaults, config layers (lowest precedence first), environment and
+// command-line flags, recording where each value came from
+func resolveSettings(profile string, layers []settingsLayer, getenv func(string) string, flags map[string]string) (*Settings, error) {
+	settings := &Settings{profile: profile, values: make(map[string]settingValue)}
+	for _, definition := range settingDefinitions {
+		resolved := settingValue{value: definition.def, source: "default"}
+		for _, layer := range layers {
+			if value, ok := layer.values[definition.key]; ok {
+				resolved = settingValue{value: value, source: layer.source}
+			}
+		}
+		if definition.env != "" {
+			if value := getenv(definition.env); value != "" {
+				resolved = settingValue{value: value, source: "environment variable " + definition.env}
+			}
+		}
+		if value, ok := flags[definition.flag]; ok && definition.flag != "" {
+			resolved = settingValue{value: value, source: "flag --" + definition.flag}
+		}
+
+		if err := definition.validate(resolved.value); err != nil {
+			return nil, fmt.Errorf("%s from %s: %w", definition.key, resolved.source, err)
+		}
+		settings.values[definition.key] = resolved
+	}
+	return settings, nil
+}
+
+func (d settingDefinition) validate(value string) error {
+	switch d.kind {
+	case settingInt:
+		if _, err := strconv.Atoi(value); err != nil {
+			return fmt.Errorf("%q is not a whole number", value)
+		}
+	case settingBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+	}
+	return nil
+}
+
+// String returns a setting's value
+func (s *Settings) String(key string) string {
+	return s.values[key].value
+}
+
+// Int returns a numeric setting's value
+func (s *Settings) Int(key string) int {
+	value, _ := strconv.Atoi(s.values[key].value)
+	return value
+}
+
+// Bool returns a boolean setting's value
+func (s *Settings) Bool(key string) bool {
+	value, _ := strconv.ParseBool(s.values[key].value)
+	return value
+}
+
+// List returns a list setting's items
+func (s *Settings) List(key string) []string {
+	var items []string
+	for _, item := range strings.Split(s.values[key].value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Source describes where a setting's value came from
+func (s *Settings) Source(key string) string {
+	return s.values[key].source
+}
+
+// format lists every setting with its effective value and source, as printed by config show
+func (s *Settings) format() string {
+	var out strings.Builder
+	if s.profile != "" {
+		out.WriteString(fmt.Sprintf("Profile: %s\n", s.profile))
+	}
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		out.WriteString(fmt.Sprintf("%s = %q  # %s\n", key, s.values[key].value, s.values[key].source))
+	}
+	return out.String()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func noEnv(string) string { return "" }
+
+func TestResolveSettings_Precedence(t *testing.T) {
+	layers := []settingsLayer{
+		{source: "user config", values: map[string]string{"model": "user-model", "limits.max_turn_tokens": "1000", "endpoint": "http://user"}},
+		{source: "project config", values: map[string]string{"model": "project-model", "limits.max_turn_tokens": "2000"}},
+	}
+	env := map[string]string{"LLM_MODEL": "env-model"}
+
+	settings, err := resolveSettings("", layers, func(name string) string { return env[name] }, map[string]string{"max-turn-tokens": "3000"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "env-model", settings.String("model"))
+	assert.Equal(t, "environment variable LLM_MODEL", settings.Source("model"))
+	assert.Equal(t, 3000, settings.Int("limits.max_turn_tokens"))
+	assert.Equal(t, "flag --max-turn-tokens", settings.Source("limits.max_turn_tokens"))
+	assert.Equal(t, "http://user", settings.String("endpoint"))
+	assert.Equal(t, "user config", settings.Source("endpoint"))
+	assert.Equal(t, defaultMaxAgentDepth, settings.Int("limits.max_agent_depth"))
+	assert.Equal(t, "default", settings.Source("limits.max_agent_depth"))
+
+	// The flag beats the environment, and without either the project layer wins
+	settings, err = resolveSettings("", layers, func(name string) string { return env[name] }, map[string]string{"model": "flag-model"})
+	require.NoError(t, err)
+	assert.Equal(t, "flag-model", settings.String("model"))
+	settings, err = resolveSettings("", layers, noEnv, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "project-model", settings.String("model"))
+	settings, err = resolveSettings("", nil, noEnv, nil)
+	require.NoError(t, err)
+	assert.Equal(t, DEFAULT_MODEL, settings.String("model"))
+}
+
+func TestResolveSettings_InvalidValue(t *testing.T) {
+	layers := []settingsLayer{{source: "project config", values: map[string]string{"permissions.auto_commit": "sometimes"}}}
+
+	_, err := resolveSettings("", layers, noEnv, nil)
+
+	assert.EqualError(t, err, `permissions.auto_commit from project config: "sometimes" is not true or false`)
+}
+
+func TestLoadSettingsLayers_Profiles(t *testing.T) {
+	userDir := t.TempDir()
+	projectDir := t.TempDir()
+	writeConfig(t, userDir, `
+model: strong
+output:
+  color: false
+profiles:
+  work:
+    model: work-model
+    limits:
+      max_turn_iterations: 20
+`)
+	writeConfig(t, projectDir, `
+providers:
+  local:
+    type: ollama
+limits:
+  max_turn_iterations: 5
+tools:
+  enabled: [read_file, list_dir]
+`)
+
+	layers, err := loadSettingsLayers(projectDir, userDir, "work")
+	require.NoError(t, err)
+	settings, err := resolveSettings("work", layers, noEnv, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, "work-model", settings.String("model"))
+	assert.Equal(t, "user config "+filepath.Join(userDir, configFileName)+", profile work", settings.Source("model"))
+	assert.Equal(t, 5, settings.Int("limits.max_turn_iterations"))
+	assert.False(t, settings.Bool("output.color"))
+	assert.Equal(t, []string{"read_file", "list_dir"}, settings.List("tools.enabled"))
+	assert.Contains(t, settings.format(), "Profile: work\n")
+	assert.Contains(t, settings.format(), `limits.max_turn_iterations = "5"  # project config `)
+
+	_, err = loadSettingsLayers(projectDir, userDir, "home")
+	assert.EqualError(t, err, `unknown profile "home"`)
+}
+
+func TestLoadSettingsLayers_UnknownSetting(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig(t, dir, "limits:\n  max_turns: 3\n")
+
+	_, err := loadSettingsLayers(dir, "", "")
+
+	assert.ErrorContains(t, err, `unknown setting "limits.max_turns"`)
+}
+
+func TestSettingFlags(t *testing.T) {
+	flags := flag.NewFlagSet("agent", flag.ContinueOnError)
+	registerSettingFlags(flags)
+	require.NoError(t, flags.Parse([]string{"--auto-commit", "--max-parallel-agents", "2", "--tools", "read_file,git_diff"}))
+
+	settings, err := resolveSettings("", nil, noEnv, setFlags(flags))
+	require.NoError(t, err)
+
+	assert.True(t, settings.Bool("permissions.auto_commit"))
+	assert.Equal(t, 2, settings.Int("limits.max_parallel_agents"))
+	assert.Equal(t, []string{"read_file", "git_diff"}, settings.List("tools.enabled"))
+	assert.Equal(t, "default", settings.Source("model"))
+}
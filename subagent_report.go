@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// readTools and modifyingTools classify tool calls for the sub-agent summary, mapping each tool
+// to the argument fields holding the paths it touches
+var (
+	readTools = map[string][]string{
+		"read_file": {"path"},
+		"git_show":  {"path"},
+		"git_blame": {"path"},
+	}
+	modifyingTools = map[string][]string{
+		"write_to_file": {"path"},
+		"move_file":     {"source", "destination"},
+		"copy_file":     {"destination"},
+		"delete_file":   {"path"},
+		"make_dir":      {"path"},
+	}
+)
+
+// transcriptStore saves conversations to disk for later inspection
+type transcriptStore struct {
+	dir string
+}
+
+func newTranscriptStore(dir string) *transcriptStore {
+	return &transcriptStore{dir: dir}
+}
+
+// sessionTranscriptDir returns where a session's transcripts are stored. Without a user config
+// directory they go to the temporary directory, never to the workspace where they could be committed.
+func sessionTranscriptDir(sessionID string) string {
+	base := userConfigDir()
+	if base == "" {
+		base = filepath.Join(os.TempDir(), "agent")
+	}
+	return filepath.Join(base, "sessions", sessionID)
+}
+
+// save writes messages as JSON under name and returns the file's path
+func (s *transcriptStore) save(name string, messages []Message) (string, error) {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(messages, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(s.dir, name+".json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// subAgentReport summarizes a finished sub-agent conversation
+type subAgentReport struct {
+	FinalMessage  string
+	FilesRead     []string
+	FilesModified []string
+	ToolCounts    map[string]int
+}
+
+// idSet is a set of identifiers that is safe for concurrent use. The zero value is empty and ready to use.
+type idSet struct {
+	mu  sync.Mutex
+	ids map[string]bool
+}
+
+func (s *idSet) add(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ids == nil {
+		s.ids = make(map[string]bool)
+	}
+	s.ids[id] = true
+}
+
+func (s *idSet) has(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ids[id]
+}
+
+// buildSubAgentReport extracts the final assistant message and what the sub-agent did from its transcript.
+// Files touched by tool calls that failed are left out of the summary.
+func buildSubAgentReport(messages []Message, failed *idSet) subAgentReport {
+	report := subAgentReport{ToolCounts: make(map[string]int)}
+	read := make(map[string]bool)
+	modified := make(map[string]bool)
+
+	for _, msg := range messages {
+		if msg.Role != RoleAssistant {
+			continue
+		}
+		if strings.TrimSpace(msg.Content) != "" {
+			report.FinalMessage = strings.TrimSpace(msg.Content)
+		}
+		for _, toolCall := range msg.ToolCalls {
+			name := toolCall.Name
+			report.ToolCounts[name]++
+			if failed.has(toolCall.ID) {
+				continue
+			}
+			for _, path := range toolCallPaths(toolCall, readTools[name]) {
+				read[path] = true
+			}
+			for _, path := range toolCallPaths(toolCall, modifyingTools[name]) {
+				modified[path] = true
+			}
+		}
+	}
+
+	report.FilesRead = sortedKeys(read)
+	report.FilesModified = sortedKeys(modified)
+	return report
+}
+
+// String renders the report for the parent agent
+func (r subAgentReport) String() string {
+	var out strings.Builder
+	if r.FinalMessage != "" {
+		out.WriteString(r.FinalMessage + "\n")
+	} else {
+		out.WriteString("The sub-agent finished without a final message.\n")
+	}
+
+	out.WriteString("\nSummary:\n")
+	if len(r.FilesRead) > 0 {
+		out.WriteString("- Files read: " + strings.Join(r.FilesRead, ", ") + "\n")
+	}
+	if len(r.FilesModified) > 0 {
+		out.WriteString("- Files modified: " + strings.Join(r.FilesModified, ", ") + "\n")
+	}
+
+	if len(r.ToolCounts) == 0 {
+		out.WriteString("- Tool calls: none\n")
+	} else {
+		var counts []string
+		for _, name := range sortedKeys(r.ToolCounts) {
+			counts = append(counts, fmt.Sprintf("%s x%d", name, r.ToolCounts[name]))
+		}
+		out.WriteString("- Tool calls: " + strings.Join(counts, ", ") + "\n")
+	}
+	return out.String()
+}
+
+// toolCallPaths returns the string values of the given argument fields of a tool call
+func toolCallPaths(toolCall ToolCall, fields []string) []string {
+	if len(fields) == 0 {
+		return nil
+	}
+	var args map[string]any
+	if err := json.Unmarshal([]byte(toolCall.Arguments), &args); err != nil {
+		return nil
+	}
+
+	var paths []string
+	for _, field := range fields {
+		if path, ok := args[field].(string); ok && path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"agent/mocks"
+)
+
+func TestBuildSubAgentReport(t *testing.T) {
+	messages := []Message{
+		{Role: RoleUser, Content: "refactor"},
+		{Role: RoleAssistant, Content: "Reading first", ToolCalls: []ToolCall{
+			{ID: "c1", Name: "read_file", Arguments: `{"path": "a.go"}`},
+			{ID: "c2", Name: "read_file", Arguments: `{"path": "b.go"}`},
+		}},
+		{Role: RoleTool, Content: "package a", ToolCallID: "c1"},
+		{Role: RoleTool, Content: "Error reading file", ToolCallID: "c2"},
+		{Role: RoleAssistant, ToolCalls: []ToolCall{
+			{ID: "c3", Name: "move_file", Arguments: `{"source": "a.go", "destination": "pkg/a.go"}`},
+		}},
+		{Role: RoleTool, Content: "File moved successfully.", ToolCallID: "c3"},
+		{Role: RoleAssistant, Content: "Moved a.go into pkg."},
+	}
+	var failed idSet
+	failed.add("c2")
+
+	report := buildSubAgentReport(messages, &failed)
+
+	assert.Equal(t, "Moved a.go into pkg.", report.FinalMessage)
+	assert.Equal(t, []string{"a.go"}, report.FilesRead)
+	assert.Equal(t, []string{"a.go", "pkg/a.go"}, report.FilesModified)
+	assert.Equal(t, map[string]int{"read_file": 2, "move_file": 1}, report.ToolCounts)
+
+	rendered := report.String()
+	assert.True(t, strings.HasPrefix(rendered, "Moved a.go into pkg.\n"))
+	assert.Contains(t, rendered, "- Files read: a.go\n")
+	assert.Contains(t, rendered, "- Files modified: a.go, pkg/a.go\n")
+	assert.Contains(t, rendered, "- Tool calls: move_file x1, read_file x2\n")
+	assert.NotContains(t, rendered, "package a")
+}
+
+func TestBuildSubAgentReport_NoFinalMessage(t *testing.T) {
+	report := buildSubAgentReport([]Message{{Role: RoleUser, Content: "task"}}, &idSet{})
+
+	rendered := report.String()
+	assert.Contains(t, rendered, "The sub-agent finished without a final message.")
+	assert.Contains(t, rendered, "- Tool calls: none")
+}
+
+func TestTranscriptStore_Save(t *testing.T) {
+	store := newTranscriptStore(filepath.Join(t.TempDir(), "session"))
+	messages := []Message{{Role: RoleUser, Content: "hello"}}
+
+	path, err := store.save("subagent-1", messages)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var saved []Message
+	require.NoError(t, json.Unmarshal(data, &saved))
+	assert.Equal(t, messages, saved)
+}
+
+func TestSessionTranscriptDir_WithoutUserConfigDir(t *testing.T) {
+	t.Setenv("HOME", "")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("AppData", "")
+
+	dir := sessionTranscriptDir("abc")
+
+	assert.True(t, filepath.IsAbs(dir), dir)
+	assert.Equal(t, filepath.Join(os.TempDir(), "agent", "sessions", "abc"), dir)
+}
+
+func TestHandleRunAgent_SavesFullTranscript(t *testing.T) {
+	mockClient := mocks.NewMockOpenAIClient()
+	agent := NewAgent(NewOpenAIProvider(mockClient), nil, "test-model")
+	agent.transcripts = newTranscriptStore(t.TempDir())
+
+	readCall := mocks.CreateMockToolCall("sub-1", "read_file", `{"path": "testdata/sample.txt"}`)
+	mockClient.AddResponse(mocks.CreateMockResponse("", []openai.ToolCall{readCall}))
+	mockClient.AddResponse(mocks.CreateMockResponse("The sample has three lines.", nil))
+
+	response := agent.handleRunAgent(context.Background(), newTestToolCall("run-1", "run_agent", RunAgentInput{Task: "summarize sample"}))
+
+	assert.Contains(t, response.Content, "The sample has three lines.")
+	assert.Contains(t, response.Content, "- Files read: testdata/sample.txt")
+	assert.NotContains(t, response.Content, "This is a sample file for testing.")
+
+	_, path, found := strings.Cut(response.Content, "- Full transcript: ")
+	require.True(t, found)
+	data, err := os.ReadFile(strings.TrimSpace(path))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "This is a sample file for testing.")
+}
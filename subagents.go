@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// defaultMaxParallelAgents is how many run_agents sub-agents run at once when no limit is configured
+const defaultMaxParallelAgents = 4
+
+type RunAgentsInput struct {
+	Tasks []RunAgentInput `json:"tasks" jsonschema_description:"The independent tasks to run, one sub-agent each"`
+}
+
+// subAgentRun is the outcome of running a sub-agent to completion
+type subAgentRun struct {
+	output   string // task header, final report and usage
+	report   subAgentReport
+	worktree *worktree // the sub-agent's isolated worktree, still to be resolved
+	err      error     // error that ended the sub-agent's conversation early
+}
+
+// fileClaims detects concurrent sub-agents modifying the same file. The first sub-agent to modify a
+// path owns it for the rest of the batch; other sub-agents are refused.
+type fileClaims struct {
+	mu        sync.Mutex
+	owners    map[string]int
+	conflicts []string
+}
+
+func newFileClaims() *fileClaims {
+	return &fileClaims{owners: make(map[string]int)}
+}
+
+// claim records that the sub-agent numbered owner is modifying path
+func (c *fileClaims) claim(owner int, path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if current, ok := c.owners[path]; ok && current != owner {
+		c.conflicts = append(c.conflicts, fmt.Sprintf("task %d was refused %s, already modified by task %d", owner, path, current))
+		return fmt.Errorf("%s is being modified by another sub-agent (task %d)", path, current)
+	}
+	c.owners[path] = owner
+	return nil
+}
+
+// claimPaths claims the paths a mutating tool is about to modify, failing if a concurrently running
+// sub-agent has already modified one of them. Agents outside a run_agents batch claim nothing.
+func (a *Agent) claimPaths(paths ...string) error {
+	if a.claims == nil {
+		return nil
+	}
+	for _, path := range paths {
+		abs, err := filepath.Abs(a.resolvePath(path))
+		if err != nil {
+			return err
+		}
+		if err := a.claims.claim(a.claimOwner, abs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runSubAgent runs a task in a new child agent and reports its outcome. Errors are returned only
+// when the sub-agent could not be started.
+func (a *Agent) runSubAgent(ctx context.Context, input RunAgentInput, configure func(*Agent)) (*subAgentRun, error) {
+	if a.depth+1 > a.maxAgentDepth() {
+		return nil, fmt.Errorf("maximum sub-agent depth of %d reached, perform the task directly instead", a.maxAgentDepth())
+	}
+
+	// Create a new agent instance using the model routed to sub-agents, by default the parent's
+	provider, model := a.routedModel(routeSubAgent)
+	newAgent := &Agent{
+		provider:          provider,
+		inputManager:      nil, // No input manager needed for programmatic execution
+		toolHandlers:      make(map[string]ToolHandler),
+		model:             model,
+		workDir:           a.workDir,
+		changes:           a.changes, // sub-agent edits are committed with the parent's turn
+		sessionID:         a.sessionID,
+		depth:             a.depth + 1,
+		maxDepth:          a.maxDepth,
+		maxParallelAgents: a.maxParallelAgents,
+		budget:            a.budget, // the whole agent tree draws from one budget
+		agentTypes:        a.agentTypes,
+		transcripts:       a.transcripts,
+		router:            a.router,
+		allowedTools:      a.allowedTools,
+		approve:           a.approve, // sub-agent edits need the same approval
+		claims:            a.claims,  // nested sub-agents of a run_agents task write as that task
+		claimOwner:        a.claimOwner,
+	}
+	newAgent.setupTools()
+	if err := newAgent.restrictTools(a.allowedTools); err != nil {
+		return nil, fmt.Errorf("restricting tools: %v", err)
+	}
+
+	// Create initial conversation with the task
+	messages := []Message{
+		{
+			Role:    RoleUser,
+			Content: input.Task,
+		},
+	}
+
+	if input.AgentType != "" {
+		agentType, ok := a.availableAgentTypes()[input.AgentType]
+		if !ok {
+			return nil, fmt.Errorf("unknown agent type %q, available types: %s", input.AgentType, strings.Join(agentTypeNames(a.availableAgentTypes()), ", "))
+		}
+		if err := newAgent.restrictTools(agentType.Tools); err != nil {
+			return nil, fmt.Errorf("invalid agent type %q: %v", agentType.Name, err)
+		}
+		if agentType.Model != "" {
+			if err := newAgent.useModel(agentType.Model); err != nil {
+				return nil, fmt.Errorf("invalid agent type %q: %v", agentType.Name, err)
+			}
+		}
+		if agentType.SystemPrompt != "" {
+			messages = append([]Message{{Role: RoleSystem, Content: agentType.SystemPrompt}}, messages...)
+		}
+	}
+
+	run := &subAgentRun{}
+	if a.isolateSubAgents {
+		wt, err := createWorktree(ctx, a.workDir, "sub-"+newSessionID())
+		if err != nil {
+			return nil, fmt.Errorf("creating worktree: %v", err)
+		}
+		run.worktree = wt
+		newAgent.workDir = wt.path
+		newAgent.changes = newChangeTracker() // edits land on the worktree branch instead
+	}
+	if configure != nil {
+		configure(newAgent)
+	}
+
+	var output strings.Builder
+	output.WriteString(fmt.Sprintf("Agent task: %s\n\n", input.Task))
+	if input.AgentType != "" {
+		output.WriteString(fmt.Sprintf("Agent type: %s\n\n", input.AgentType))
+	}
+
+	// Only the sub-agent's final report goes back to the parent; the full transcript is saved separately
+	transcript, err := newAgent.DriveConversation(ctx, messages, nil, nil)
+	run.err = err
+	run.report = buildSubAgentReport(transcript, &newAgent.failedToolCalls)
+	output.WriteString(run.report.String())
+
+	subUsage := newAgent.totalUsage()
+	a.addUsage(subUsage)
+	output.WriteString(fmt.Sprintf("- Usage: %s\n", subUsage))
+
+	if a.transcripts != nil {
+		if path, saveErr := a.transcripts.save("subagent-"+newSessionID(), transcript); saveErr != nil {
+			output.WriteString(fmt.Sprintf("- Transcript could not be saved: %v\n", saveErr))
+		} else {
+			output.WriteString(fmt.Sprintf("- Full transcript: %s\n", path))
+		}
+	}
+
+	run.output = output.String()
+	return run, nil
+}
+
+func (a *Agent) createRunAgentsTool() ToolDefinition {
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"tasks": map[string]any{
+				"type":        "array",
+				"description": "The independent tasks to run, one sub-agent each",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"task": map[string]any{
+							"type":        "string",
+							"description": "Description of the task for the agent to perform",
+						},
+						"agent_type": map[string]any{
+							"type":        "string",
+							"description": "Optional agent type selecting the sub-agent's persona, tools and model",
+							"enum":        agentTypeNames(a.availableAgentTypes()),
+						},
+					},
+					"required": []string{"task"},
+				},
+			},
+		},
+		"required": []string{"tasks"},
+	}
+
+	return ToolDefinition{
+		Name:        "run_agents",
+		Description: "Run several independent sub-agents concurrently and return their reports in task order. Use this for investigations or edits that do not depend on each other; sub-agents may not modify the same file.",
+		Parameters:  schema,
+	}
+}
+
+func (a *Agent) handleRunAgents(ctx context.Context, toolCall ToolCall) Message {
+	var input RunAgentsInput
+	if err := json.Unmarshal([]byte(toolCall.Arguments), &input); err != nil {
+		return a.createErrorResponse(toolCall.ID, fmt.Sprintf("Invalid arguments: %v", err))
+	}
+	if len(input.Tasks) == 0 {
+		return a.createErrorResponse(toolCall.ID, "Invalid arguments: at least one task is required")
+	}
+
+	limit := a.maxParallelAgents
+	if limit <= 0 {
+		limit = defaultMaxParallelAgents
+	}
+	a.logger().Info("running sub-agents", "tasks", len(input.Tasks), "parallel", limit)
+
+	claims := newFileClaims()
+	runs := make([]*subAgentRun, len(input.Tasks))
+	startErrs := make([]error, len(input.Tasks))
+	semaphore := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	var progressMu sync.Mutex
+	finished := 0
+
+	for i, task := range input.Tasks {
+		wg.Add(1)
+		go func(i int, task RunAgentInput) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			runs[i], startErrs[i] = a.runSubAgent(ctx, task, func(child *Agent) {
+				child.claims = claims
+				child.claimOwner = i + 1
+			})
+
+			progressMu.Lock()
+			finished++
+			a.logger().Info("sub-agent finished", "finished", finished, "tasks", len(input.Tasks), "task", i+1, "summary", firstLine(task.Task))
+			progressMu.Unlock()
+		}(i, task)
+	}
+	wg.Wait()
+
+	// Worktrees are resolved one at a time, in task order, so any prompts do not interleave
+	var output strings.Builder
+	var failures int
+	for i, run := range runs {
+		output.WriteString(fmt.Sprintf("=== Task %d ===\n", i+1))
+		if startErrs[i] != nil {
+			failures++
+			output.WriteString(fmt.Sprintf("Error in agent execution: %v\n\n", startErrs[i]))
+			continue
+		}
+		output.WriteString(run.output)
+		if run.worktree != nil {
+			output.WriteString(a.finishSubAgentWorktree(run.worktree, input.Tasks[i].Task, run.err == nil) + "\n")
+		}
+		if run.err != nil {
+			failures++
+			output.WriteString(fmt.Sprintf("Error in agent execution: %v\n", run.err))
+		}
+		output.WriteString("\n")
+	}
+
+	if len(claims.conflicts) > 0 {
+		output.WriteString("File conflicts:\n")
+		for _, conflict := range claims.conflicts {
+			output.WriteString("- " + conflict + "\n")
+		}
+	}
+	output.WriteString(fmt.Sprintf("%d of %d sub-agents completed successfully.\n", len(input.Tasks)-failures, len(input.Tasks)))
+
+	return Message{
+		Role:       RoleTool,
+		Content:    output.String(),
+		ToolCallID: toolCall.ID,
+	}
+}
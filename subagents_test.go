@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"agent/mocks"
+)
+
+// funcProvider is a provider safe for concurrent use that answers each request with respond
+type funcProvider struct {
+	respond func(request ChatRequest) Message
+}
+
+func (p *funcProvider) Chat(ctx context.Context, request ChatRequest) (ChatResponse, error) {
+	return ChatResponse{Message: p.respond(request)}, nil
+}
+
+// writeThenFinish scripts sub-agents whose task is "write <path>": they write the file, then report
+func writeThenFinish(request ChatRequest) Message {
+	task := request.Messages[0].Content
+	path := strings.TrimPrefix(task, "write ")
+	if len(request.Messages) == 1 {
+		return Message{Role: RoleAssistant, ToolCalls: []ToolCall{
+			{ID: "call-" + task, Name: "write_to_file", Arguments: fmt.Sprintf(`{"path": %q, "content": %q}`, path, task)},
+		}}
+	}
+	return Message{Role: RoleAssistant, Content: "Finished: " + request.Messages[len(request.Messages)-1].Content}
+}
+
+func TestFileClaims_Conflict(t *testing.T) {
+	claims := newFileClaims()
+
+	require.NoError(t, claims.claim(1, "/repo/a.go"))
+	require.NoError(t, claims.claim(1, "/repo/a.go"))
+	require.NoError(t, claims.claim(2, "/repo/b.go"))
+
+	err := claims.claim(2, "/repo/a.go")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "task 1")
+	assert.Equal(t, []string{"task 2 was refused /repo/a.go, already modified by task 1"}, claims.conflicts)
+}
+
+func TestHandleRunAgents_ReportsInTaskOrder(t *testing.T) {
+	dir := t.TempDir()
+	agent := NewAgent(&funcProvider{respond: writeThenFinish}, nil, "test-model")
+	agent.workDir = dir
+	log := captureLog(t)
+
+	toolCall := newTestToolCall("batch", "run_agents", RunAgentsInput{Tasks: []RunAgentInput{
+		{Task: "write one.txt"},
+		{Task: "write two.txt"},
+		{Task: "write three.txt"},
+	}})
+	result := agent.handleRunAgents(context.Background(), toolCall)
+
+	assert.Equal(t, "batch", result.ToolCallID)
+	one := strings.Index(result.Content, "=== Task 1 ===\nAgent task: write one.txt")
+	two := strings.Index(result.Content, "=== Task 2 ===\nAgent task: write two.txt")
+	three := strings.Index(result.Content, "=== Task 3 ===\nAgent task: write three.txt")
+	require.True(t, one >= 0 && two > one && three > two, result.Content)
+	assert.Contains(t, result.Content, "- Files modified: two.txt")
+	assert.Contains(t, result.Content, "3 of 3 sub-agents completed successfully.")
+	assert.Equal(t, 6, agent.totalUsage().Iterations)
+	assert.Equal(t, 1, strings.Count(log.String(), `"msg":"running sub-agents"`))
+	assert.Equal(t, 3, strings.Count(log.String(), `"msg":"sub-agent finished"`), "progress is logged, not printed")
+
+	for _, name := range []string{"one.txt", "two.txt", "three.txt"} {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		assert.Equal(t, "write "+name, string(content))
+	}
+}
+
+func TestHandleRunAgents_RefusesConflictingWrites(t *testing.T) {
+	dir := t.TempDir()
+	agent := NewAgent(&funcProvider{respond: writeThenFinish}, nil, "test-model")
+	agent.workDir = dir
+
+	// Both tasks write shared.txt, so whichever sub-agent gets there second is refused
+	toolCall := newTestToolCall("batch", "run_agents", RunAgentsInput{Tasks: []RunAgentInput{
+		{Task: "write shared.txt"},
+		{Task: "write ./shared.txt"},
+	}})
+	result := agent.handleRunAgents(context.Background(), toolCall)
+
+	assert.Contains(t, result.Content, "File conflicts:\n- task ")
+	assert.Contains(t, result.Content, "is being modified by another sub-agent")
+	assert.Equal(t, 1, strings.Count(result.Content, "- Files modified:"), result.Content)
+}
+
+func TestHandleRunAgents_RefusesConflictingNestedWrites(t *testing.T) {
+	dir := t.TempDir()
+	// "delegate <path>" sub-agents hand the write to a sub-agent of their own
+	agent := NewAgent(&funcProvider{respond: func(request ChatRequest) Message {
+		task := request.Messages[0].Content
+		path, delegating := strings.CutPrefix(task, "delegate ")
+		if !delegating {
+			return writeThenFinish(request)
+		}
+		if len(request.Messages) == 1 {
+			return Message{Role: RoleAssistant, ToolCalls: []ToolCall{
+				{ID: "call-" + task, Name: "run_agent", Arguments: fmt.Sprintf(`{"task": "write %s"}`, path)},
+			}}
+		}
+		return Message{Role: RoleAssistant, Content: "Delegated."}
+	}}, nil, "test-model")
+	agent.workDir = dir
+
+	result := agent.handleRunAgents(context.Background(), newTestToolCall("batch", "run_agents", RunAgentsInput{Tasks: []RunAgentInput{
+		{Task: "write shared.txt"},
+		{Task: "delegate shared.txt"},
+	}}))
+
+	// Whichever writer comes second is refused, the grandchild as well as a direct sub-agent
+	assert.Contains(t, result.Content, "File conflicts:\n- task ", result.Content)
+}
+
+func TestHandleRunAgents_LimitsConcurrency(t *testing.T) {
+	var running, peak atomic.Int32
+	provider := &funcProvider{respond: func(request ChatRequest) Message {
+		now := running.Add(1)
+		defer running.Add(-1)
+		for {
+			old := peak.Load()
+			if now <= old || peak.CompareAndSwap(old, now) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		return Message{Role: RoleAssistant, Content: "done"}
+	}}
+	agent := NewAgent(provider, nil, "test-model")
+	agent.maxParallelAgents = 2
+
+	var tasks []RunAgentInput
+	for i := 0; i < 6; i++ {
+		tasks = append(tasks, RunAgentInput{Task: fmt.Sprintf("task %d", i)})
+	}
+	result := agent.handleRunAgents(context.Background(), newTestToolCall("batch", "run_agents", RunAgentsInput{Tasks: tasks}))
+
+	assert.Contains(t, result.Content, "6 of 6 sub-agents completed successfully.")
+	assert.Equal(t, int32(2), peak.Load())
+}
+
+func TestHandleRunAgents_StartErrors(t *testing.T) {
+	var mu sync.Mutex
+	var calls int
+	provider := &funcProvider{respond: func(request ChatRequest) Message {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		return Message{Role: RoleAssistant, Content: "done"}
+	}}
+	agent := NewAgent(provider, nil, "test-model")
+
+	result := agent.handleRunAgents(context.Background(), newTestToolCall("batch", "run_agents", RunAgentsInput{Tasks: []RunAgentInput{
+		{Task: "look around", AgentType: "explorer"},
+		{Task: "do something", AgentType: "nonexistent"},
+	}}))
+
+	assert.Contains(t, result.Content, "=== Task 2 ===\nError in agent execution: unknown agent type \"nonexistent\"")
+	assert.Contains(t, result.Content, "1 of 2 sub-agents completed successfully.")
+	assert.Equal(t, 1, calls)
+}
+
+func TestHandleRunAgents_NoTasks(t *testing.T) {
+	agent := NewAgent(NewOpenAIProvider(mocks.NewMockOpenAIClient()), nil, "test-model")
+
+	result := agent.handleRunAgents(context.Background(), newTestToolCall("batch", "run_agents", RunAgentsInput{}))
+
+	assert.Contains(t, result.Content, "at least one task is required")
+}
+
+func TestRunSubAgent_InheritsAllowedTools(t *testing.T) {
+	var tools []ToolDefinition
+	agent := NewAgent(&funcProvider{respond: func(request ChatRequest) Message {
+		tools = request.Tools
+		return Message{Role: RoleAssistant, Content: "Done."}
+	}}, nil, "test-model")
+	agent.allowedTools = []string{"read_file", "run_agent"}
+	require.NoError(t, agent.restrictTools(agent.allowedTools))
+
+	_, err := agent.runSubAgent(context.Background(), RunAgentInput{Task: "look around"}, nil)
+	require.NoError(t, err)
+
+	require.Len(t, tools, 2)
+	assert.Equal(t, "read_file", tools[0].Name)
+	assert.Equal(t, "run_agent", tools[1].Name)
+}
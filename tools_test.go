@@ -1,12 +1,12 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"os"
 	"path/filepath"
 	"testing"
 
-	"github.com/sashabaranov/go-openai"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -31,18 +31,15 @@ func TestHandleReadFile_Success(t *testing.T) {
 
 	// Create tool call
 	args, _ := json.Marshal(ReadFileInput{Path: testFile})
-	toolCall := openai.ToolCall{
-		ID:   "test-call-1",
-		Type: "function",
-		Function: openai.FunctionCall{
-			Name:      "read_file",
-			Arguments: string(args),
-		},
+	toolCall := ToolCall{
+		ID:        "test-call-1",
+		Name:      "read_file",
+		Arguments: string(args),
 	}
 
-	response := agent.handleReadFile(toolCall)
+	response := agent.handleReadFile(context.Background(), toolCall)
 
-	assert.Equal(t, openai.ChatMessageRoleTool, response.Role)
+	assert.Equal(t, RoleTool, response.Role)
 	assert.Equal(t, testContent, response.Content)
 	assert.Equal(t, "test-call-1", response.ToolCallID)
 }
@@ -52,18 +49,15 @@ func TestHandleReadFile_FileNotFound(t *testing.T) {
 	agent.setupTools()
 
 	args, _ := json.Marshal(ReadFileInput{Path: "nonexistent.txt"})
-	toolCall := openai.ToolCall{
-		ID:   "test-call-2",
-		Type: "function",
-		Function: openai.FunctionCall{
-			Name:      "read_file",
-			Arguments: string(args),
-		},
+	toolCall := ToolCall{
+		ID:        "test-call-2",
+		Name:      "read_file",
+		Arguments: string(args),
 	}
 
-	response := agent.handleReadFile(toolCall)
+	response := agent.handleReadFile(context.Background(), toolCall)
 
-	assert.Equal(t, openai.ChatMessageRoleTool, response.Role)
+	assert.Equal(t, RoleTool, response.Role)
 	assert.Contains(t, response.Content, "Error reading file")
 	assert.Equal(t, "test-call-2", response.ToolCallID)
 }
@@ -72,18 +66,15 @@ func TestHandleReadFile_InvalidJSON(t *testing.T) {
 	agent := setupTestAgent()
 	agent.setupTools()
 
-	toolCall := openai.ToolCall{
-		ID:   "test-call-3",
-		Type: "function",
-		Function: openai.FunctionCall{
-			Name:      "read_file",
-			Arguments: "invalid json",
-		},
+	toolCall := ToolCall{
+		ID:        "test-call-3",
+		Name:      "read_file",
+		Arguments: "invalid json",
 	}
 
-	response := agent.handleReadFile(toolCall)
+	response := agent.handleReadFile(context.Background(), toolCall)
 
-	assert.Equal(t, openai.ChatMessageRoleTool, response.Role)
+	assert.Equal(t, RoleTool, response.Role)
 	assert.Contains(t, response.Content, "Invalid arguments")
 	assert.Equal(t, "test-call-3", response.ToolCallID)
 }
@@ -93,18 +84,15 @@ func TestHandleListDir_Success(t *testing.T) {
 	agent.setupTools()
 
 	args, _ := json.Marshal(ListDirInput{Path: "testdata", Recursive: false})
-	toolCall := openai.ToolCall{
-		ID:   "test-call-4",
-		Type: "function",
-		Function: openai.FunctionCall{
-			Name:      "list_dir",
-			Arguments: string(args),
-		},
+	toolCall := ToolCall{
+		ID:        "test-call-4",
+		Name:      "list_dir",
+		Arguments: string(args),
 	}
 
-	response := agent.handleListDir(toolCall)
+	response := agent.handleListDir(context.Background(), toolCall)
 
-	assert.Equal(t, openai.ChatMessageRoleTool, response.Role)
+	assert.Equal(t, RoleTool, response.Role)
 	assert.Contains(t, response.Content, "sample.txt")
 	assert.Contains(t, response.Content, "empty_file.txt")
 	assert.Equal(t, "test-call-4", response.ToolCallID)
@@ -115,18 +103,15 @@ func TestHandleListDir_Recursive(t *testing.T) {
 	agent.setupTools()
 
 	args, _ := json.Marshal(ListDirInput{Path: "testdata", Recursive: true})
-	toolCall := openai.ToolCall{
-		ID:   "test-call-5",
-		Type: "function",
-		Function: openai.FunctionCall{
-			Name:      "list_dir",
-			Arguments: string(args),
-		},
+	toolCall := ToolCall{
+		ID:        "test-call-5",
+		Name:      "list_dir",
+		Arguments: string(args),
 	}
 
-	response := agent.handleListDir(toolCall)
+	response := agent.handleListDir(context.Background(), toolCall)
 
-	assert.Equal(t, openai.ChatMessageRoleTool, response.Role)
+	assert.Equal(t, RoleTool, response.Role)
 	assert.Contains(t, response.Content, "test_dir")
 	assert.Contains(t, response.Content, "test_dir/nested_file.txt")
 	assert.Equal(t, "test-call-5", response.ToolCallID)
@@ -137,18 +122,15 @@ func TestHandleListDir_DirectoryNotFound(t *testing.T) {
 	agent.setupTools()
 
 	args, _ := json.Marshal(ListDirInput{Path: "nonexistent_dir", Recursive: false})
-	toolCall := openai.ToolCall{
-		ID:   "test-call-6",
-		Type: "function",
-		Function: openai.FunctionCall{
-			Name:      "list_dir",
-			Arguments: string(args),
-		},
+	toolCall := ToolCall{
+		ID:        "test-call-6",
+		Name:      "list_dir",
+		Arguments: string(args),
 	}
 
-	response := agent.handleListDir(toolCall)
+	response := agent.handleListDir(context.Background(), toolCall)
 
-	assert.Equal(t, openai.ChatMessageRoleTool, response.Role)
+	assert.Equal(t, RoleTool, response.Role)
 	assert.Contains(t, response.Content, "Error reading directory")
 	assert.Equal(t, "test-call-6", response.ToolCallID)
 }
@@ -162,18 +144,15 @@ func TestHandleWriteFile_Success(t *testing.T) {
 	defer os.Remove(testFile)
 
 	args, _ := json.Marshal(WriteFileInput{Path: testFile, Content: testContent})
-	toolCall := openai.ToolCall{
-		ID:   "test-call-7",
-		Type: "function",
-		Function: openai.FunctionCall{
-			Name:      "write_to_file",
-			Arguments: string(args),
-		},
+	toolCall := ToolCall{
+		ID:        "test-call-7",
+		Name:      "write_to_file",
+		Arguments: string(args),
 	}
 
-	response := agent.handleWriteFile(toolCall)
+	response := agent.handleWriteFile(context.Background(), toolCall)
 
-	assert.Equal(t, openai.ChatMessageRoleTool, response.Role)
+	assert.Equal(t, RoleTool, response.Role)
 	assert.Equal(t, "File written successfully.", response.Content)
 	assert.Equal(t, "test-call-7", response.ToolCallID)
 
@@ -193,18 +172,15 @@ func TestHandleWriteFile_CreateDirectory(t *testing.T) {
 	defer os.RemoveAll(testDir)
 
 	args, _ := json.Marshal(WriteFileInput{Path: testFile, Content: testContent})
-	toolCall := openai.ToolCall{
-		ID:   "test-call-8",
-		Type: "function",
-		Function: openai.FunctionCall{
-			Name:      "write_to_file",
-			Arguments: string(args),
-		},
+	toolCall := ToolCall{
+		ID:        "test-call-8",
+		Name:      "write_to_file",
+		Arguments: string(args),
 	}
 
-	response := agent.handleWriteFile(toolCall)
+	response := agent.handleWriteFile(context.Background(), toolCall)
 
-	assert.Equal(t, openai.ChatMessageRoleTool, response.Role)
+	assert.Equal(t, RoleTool, response.Role)
 	assert.Equal(t, "File written successfully.", response.Content)
 	assert.Equal(t, "test-call-8", response.ToolCallID)
 
@@ -228,26 +204,20 @@ func TestProcessToolCalls_MultipleTools(t *testing.T) {
 	readArgs, _ := json.Marshal(ReadFileInput{Path: testFile})
 	listArgs, _ := json.Marshal(ListDirInput{Path: "testdata", Recursive: false})
 
-	toolCalls := []openai.ToolCall{
+	toolCalls := []ToolCall{
 		{
-			ID:   "call-1",
-			Type: "function",
-			Function: openai.FunctionCall{
-				Name:      "read_file",
-				Arguments: string(readArgs),
-			},
+			ID:        "call-1",
+			Name:      "read_file",
+			Arguments: string(readArgs),
 		},
 		{
-			ID:   "call-2",
-			Type: "function",
-			Function: openai.FunctionCall{
-				Name:      "list_dir",
-				Arguments: string(listArgs),
-			},
+			ID:        "call-2",
+			Name:      "list_dir",
+			Arguments: string(listArgs),
 		},
 	}
 
-	responses := agent.processToolCalls(toolCalls)
+	responses := agent.processToolCalls(context.Background(), toolCalls)
 
 	require.Len(t, responses, 2)
 	assert.Equal(t, testContent, responses[0].Content)
@@ -258,20 +228,17 @@ func TestProcessToolCalls_UnknownTool(t *testing.T) {
 	agent := setupTestAgent()
 	agent.setupTools()
 
-	toolCalls := []openai.ToolCall{
+	toolCalls := []ToolCall{
 		{
-			ID:   "call-unknown",
-			Type: "function",
-			Function: openai.FunctionCall{
-				Name:      "unknown_tool",
-				Arguments: "{}",
-			},
+			ID:        "call-unknown",
+			Name:      "unknown_tool",
+			Arguments: "{}",
 		},
 	}
 
-	responses := agent.processToolCalls(toolCalls)
+	responses := agent.processToolCalls(context.Background(), toolCalls)
 
 	require.Len(t, responses, 1)
 	assert.Contains(t, responses[0].Content, "Unknown tool")
 	assert.Equal(t, "call-unknown", responses[0].ToolCallID)
-}
\ No newline at end of file
+}
@@ -0,0 +1,330 @@
+'use strict';
+
+// The web UI drives the agent through the HTTP API. The token comes from the URL fragment printed
+// by `agent serve`, or is asked for once and kept in localStorage.
+const tokenKey = 'agent-token';
+let token = localStorage.getItem(tokenKey) || '';
+
+// current is the open session: its ID, the abort controller of its event stream, its tool cards by
+// tool call ID, its pending approvals by approval ID and the assistant bubble being written
+let current = null;
+
+const $ = id => document.getElementById(id);
+
+// el builds an element; strings become text nodes, so content is never parsed as HTML
+function el(tag, attrs = {}, ...children) {
+  const node = document.createElement(tag);
+  for (const [key, value] of Object.entries(attrs)) {
+    if (key === 'class') node.className = value;
+    else if (key.startsWith('on')) node.addEventListener(key.slice(2), value);
+    else node.setAttribute(key, value);
+  }
+  node.append(...children.filter(child => child !== null && child !== undefined));
+  return node;
+}
+
+function takeTokenFromURL() {
+  const params = new URLSearchParams(location.hash.slice(1));
+  if (params.get('token')) {
+    token = params.get('token');
+    localStorage.setItem(tokenKey, token);
+    history.replaceState(null, '', location.pathname);
+  }
+}
+
+function askForToken() {
+  if (!$('login').open) $('login').showModal();
+}
+
+async function api(method, path, body) {
+  const resp = await fetch(path, {
+    method,
+    headers: {'Authorization': 'Bearer ' + token, 'Content-Type': 'application/json'},
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  if (resp.status === 401) {
+    askForToken();
+    throw new Error('missing or invalid API token');
+  }
+  if (!resp.ok) {
+    const err = await resp.json().catch(() => ({}));
+    throw new Error(err.error || resp.statusText);
+  }
+  return resp.status === 204 ? null : resp.json();
+}
+
+// Sessions
+
+async function refreshSessions() {
+  const sessions = await api('GET', '/sessions');
+  $('sessions').replaceChildren(...sessions.map(session => el('li', {class: session.id === current?.id ? 'active' : ''},
+    el('button', {class: 'session', type: 'button', onclick: () => openSession(session)},
+      el('strong', {}, session.workspace),
+      el('small', {}, session.model + (session.busy ? ' · working' : ''))),
+    el('button', {class: 'delete', type: 'button', title: 'Delete session', onclick: () => deleteSession(session)}, '×'))));
+}
+
+function openSession(session) {
+  current?.abort.abort();
+  current = {id: session.id, abort: new AbortController(), cards: new Map(), approvals: new Map(), assistant: null};
+  $('empty').hidden = true;
+  $('chat').hidden = false;
+  $('session-title').textContent = `${session.workspace} · ${session.model}`;
+  $('messages').replaceChildren();
+  setBusy(false);
+  refreshSessions().catch(() => {});
+  streamEvents(current);
+}
+
+async function deleteSession(session) {
+  if (!confirm(`Delete the session in ${session.workspace}? Its workspace is kept.`)) return;
+  await api('DELETE', `/sessions/${session.id}`);
+  if (current?.id === session.id) {
+    current.abort.abort();
+    current = null;
+    $('chat').hidden = true;
+    $('empty').hidden = false;
+  }
+  await refreshSessions();
+}
+
+// Events
+
+// streamEvents replays the session's events and follows new ones. EventSource cannot send the
+// Authorization header, so the stream is read with fetch and reconnects after the last event seen.
+async function streamEvents(session) {
+  let after = 0;
+  while (!session.abort.signal.aborted) {
+    try {
+      const resp = await fetch(`/sessions/${session.id}/events?after=${after}`, {
+        headers: {'Authorization': 'Bearer ' + token},
+        signal: session.abort.signal,
+      });
+      if (resp.status === 401) return askForToken();
+      if (resp.status === 404) return addMessage('error', 'The session was deleted.');
+      const reader = resp.body.pipeThrough(new TextDecoderStream()).getReader();
+      let buffer = '';
+      for (;;) {
+        const {value, done} = await reader.read();
+        if (done) break;
+        buffer += value;
+        let end;
+        while ((end = buffer.indexOf('\n\n')) >= 0) {
+          const block = buffer.slice(0, end);
+          buffer = buffer.slice(end + 2);
+          const data = block.split('\n').filter(line => line.startsWith('data: ')).map(line => line.slice(6)).join('\n');
+          if (!data) continue; // keep-alive comment
+          const event = JSON.parse(data);
+          after = event.id;
+          handleEvent(session, event);
+        }
+      }
+    } catch (err) {
+      if (session.abort.signal.aborted) return;
+    }
+    await new Promise(resolve => setTimeout(resolve, 2000));
+  }
+}
+
+function handleEvent(session, event) {
+  if (session !== current) return;
+  const messages = $('messages');
+  const atBottom = messages.scrollHeight - messages.scrollTop - messages.clientHeight < 40;
+
+  switch (event.type) {
+  case 'user_message':
+    addMessage('user', event.content);
+    setBusy(true);
+    break;
+  case 'assistant_delta':
+    if (!session.assistant) session.assistant = addMessage('assistant', '');
+    session.assistant.textContent += event.content;
+    break;
+  case 'tool_call':
+    session.assistant = null;
+    addToolCard(session, event.tool_call, event.diff);
+    break;
+  case 'tool_result':
+    completeToolCard(session, event);
+    break;
+  case 'approval_request':
+    addApproval(session, event);
+    break;
+  case 'error':
+    addMessage('error', event.content);
+    break;
+  case 'turn_end':
+    session.assistant = null;
+    for (const approval of session.approvals.values()) approval.replaceChildren('Expired');
+    session.approvals.clear();
+    setBusy(false);
+    refreshSessions().catch(() => {});
+    break;
+  }
+  if (atBottom) messages.scrollTop = messages.scrollHeight;
+}
+
+function addMessage(kind, text) {
+  const message = el('div', {class: 'message ' + kind}, text);
+  $('messages').append(message);
+  return message;
+}
+
+function setBusy(busy) {
+  $('status').textContent = busy ? 'Working…' : '';
+  $('cancel').hidden = !busy;
+  $('composer').querySelector('button').disabled = busy;
+}
+
+// Tool calls
+
+function parseArguments(text) {
+  try {
+    return JSON.parse(text);
+  } catch {
+    return null;
+  }
+}
+
+// summarizeArguments returns the first string argument, usually the path or command
+function summarizeArguments(text) {
+  const args = parseArguments(text);
+  const first = args && Object.values(args).find(value => typeof value === 'string');
+  if (!first) return '';
+  const line = first.split('\n')[0];
+  return line.length > 80 ? line.slice(0, 79) + '…' : line;
+}
+
+function renderDiff(diff) {
+  return el('pre', {class: 'diff'}, ...diff.split('\n').map(line => {
+    let kind = '';
+    if (line.startsWith('+++') || line.startsWith('---')) kind = 'file';
+    else if (line.startsWith('@@')) kind = 'hunk';
+    else if (line.startsWith('+')) kind = 'add';
+    else if (line.startsWith('-')) kind = 'del';
+    return el('span', {class: kind}, line + '\n');
+  }));
+}
+
+function addToolCard(session, call, diff) {
+  const args = parseArguments(call.arguments);
+  const state = el('span', {class: 'tool-state'}, 'running');
+  const result = el('pre', {class: 'result'});
+  const card = el('details', {class: 'tool'},
+    el('summary', {}, el('code', {}, call.name), ' ', el('span', {class: 'tool-summary'}, summarizeArguments(call.arguments)), state),
+    el('h4', {}, 'Arguments'),
+    el('pre', {}, args ? JSON.stringify(args, null, 2) : call.arguments),
+    diff ? el('h4', {}, 'Changes') : null,
+    diff ? renderDiff(diff) : null,
+    el('h4', {}, 'Result'),
+    result);
+  card.open = Boolean(diff);
+  session.cards.set(call.id, {card, state, result});
+  $('messages').append(card);
+}
+
+function completeToolCard(session, event) {
+  for (const [id, approval] of session.approvals) {
+    if (approval.dataset.toolCall === event.tool_call_id) {
+      approval.replaceChildren('Answered');
+      session.approvals.delete(id);
+    }
+  }
+  const entry = session.cards.get(event.tool_call_id);
+  if (!entry) return;
+  entry.state.textContent = event.is_error ? 'failed' : 'done';
+  entry.card.classList.toggle('failed', Boolean(event.is_error));
+  entry.result.textContent = event.content;
+}
+
+function addApproval(session, event) {
+  const call = event.tool_call;
+  const buttons = el('div', {class: 'buttons'});
+  buttons.dataset.toolCall = call.id;
+  const answer = async approved => {
+    session.approvals.delete(event.approval_id);
+    buttons.replaceChildren(approved ? 'Approved' : 'Denied');
+    try {
+      await api('POST', `/sessions/${session.id}/approvals/${event.approval_id}`, {approved});
+    } catch (err) {
+      buttons.replaceChildren('Could not answer: ' + err.message);
+    }
+  };
+  buttons.append(
+    el('button', {class: 'approve', type: 'button', onclick: () => answer(true)}, 'Approve'),
+    el('button', {class: 'deny', type: 'button', onclick: () => answer(false)}, 'Deny'));
+  session.approvals.set(event.approval_id, buttons);
+
+  const box = el('div', {class: 'approval'},
+    el('p', {}, 'Allow ', el('code', {}, call.name), ' ', summarizeArguments(call.arguments), '?'),
+    buttons);
+  // Sub-agent calls have no card of their own, so their approval carries the diff
+  const entry = session.cards.get(call.id);
+  if (entry) {
+    entry.card.open = true;
+    entry.card.append(box);
+  } else {
+    if (event.diff) box.insertBefore(renderDiff(event.diff), buttons);
+    $('messages').append(box);
+  }
+}
+
+// Wiring
+
+$('new-session').addEventListener('click', () => {
+  $('new-session-form').hidden = !$('new-session-form').hidden;
+});
+
+$('new-session-form').addEventListener('submit', async event => {
+  event.preventDefault();
+  const form = event.target;
+  try {
+    const session = await api('POST', '/sessions', {
+      workspace: form.workspace.value.trim(),
+      model: form.model.value.trim(),
+      auto_approve: form.auto_approve.checked,
+    });
+    form.reset();
+    form.hidden = true;
+    openSession(session);
+  } catch (err) {
+    alert('Could not create the session: ' + err.message);
+  }
+});
+
+$('composer').addEventListener('submit', async event => {
+  event.preventDefault();
+  const content = event.target.content;
+  if (!current || !content.value.trim()) return;
+  try {
+    await api('POST', `/sessions/${current.id}/messages`, {content: content.value});
+    content.value = '';
+  } catch (err) {
+    addMessage('error', err.message);
+  }
+});
+
+$('composer').content.addEventListener('keydown', event => {
+  if (event.key === 'Enter' && !event.shiftKey && !event.isComposing) {
+    event.preventDefault();
+    $('composer').requestSubmit();
+  }
+});
+
+$('cancel').addEventListener('click', () => {
+  if (current) api('POST', `/sessions/${current.id}/cancel`).catch(err => addMessage('error', err.message));
+});
+
+$('login-form').addEventListener('submit', () => {
+  token = $('login-form').token.value.trim();
+  localStorage.setItem(tokenKey, token);
+  refreshSessions().catch(() => {});
+});
+
+takeTokenFromURL();
+if (token) refreshSessions().catch(() => {});
+else askForToken();
+// Pick up sessions created by other clients
+setInterval(() => {
+  if (token && !$('login').open) refreshSessions().catch(() => {});
+}, 10000);
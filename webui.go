@@ -0,0 +1,26 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// webUIPath is where the server mounts the browser client
+const webUIPath = "/ui/"
+
+// webFiles is the single-page browser client for the HTTP API
+//
+//go:embed web
+var webFiles embed.FS
+
+// handleWebUI serves the web UI without authentication. The page holds no secrets: it asks for the
+// API token and sends it with every request it makes.
+func handleWebUI(mux *http.ServeMux) {
+	files, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	mux.Handle("GET "+webUIPath, http.StripPrefix(webUIPath, http.FileServerFS(files)))
+	mux.Handle("GET /{$}", http.RedirectHandler(webUIPath, http.StatusFound))
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// worktreeBranchPrefix namespaces the branches created for isolated agents
+const worktreeBranchPrefix = "agent/"
+
+// worktreeMu serializes worktree creation, which takes locks in the shared repository that
+// concurrently starting sub-agents would otherwise contend for
+var worktreeMu sync.Mutex
+
+// worktree is a dedicated git worktree and branch that an agent's file tools operate in,
+// keeping its edits away from the user's working tree until they are merged. Operations after
+// creation deliberately ignore cancellation so a cancelled turn never leaves a half-merged tree.
+type worktree struct {
+	parentDir string // working tree the worktree was created from and merges back into
+	path      string
+	branch    string
+	base      string // commit the branch was created from
+}
+
+// createWorktree adds a new worktree on a fresh branch named after name, based on the HEAD of parentDir
+func createWorktree(ctx context.Context, parentDir, name string) (*worktree, error) {
+	base, err := runGitIn(ctx, parentDir, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("resolving HEAD: %w", err)
+	}
+
+	tmp, err := os.MkdirTemp("", "agent-worktree-")
+	if err != nil {
+		return nil, err
+	}
+
+	wt := &worktree{
+		parentDir: parentDir,
+		path:      filepath.Join(tmp, "tree"),
+		branch:    worktreeBranchPrefix + name,
+		base:      strings.TrimSpace(base),
+	}
+	worktreeMu.Lock()
+	_, err = runGitIn(ctx, parentDir, "worktree", "add", "-q", "-b", wt.branch, wt.path, wt.base)
+	worktreeMu.Unlock()
+	if err != nil {
+		os.RemoveAll(tmp)
+		return nil, fmt.Errorf("creating worktree: %w", err)
+	}
+	return wt, nil
+}
+
+// commitAll commits every change in the worktree to its branch, reporting whether there was anything to commit
+func (w *worktree) commitAll(message string) (bool, error) {
+	if _, err := runGitIn(context.Background(), w.path, "add", "-A"); err != nil {
+		return false, fmt.Errorf("staging changes: %w", err)
+	}
+	status, err := runGitIn(context.Background(), w.path, "status", "--porcelain")
+	if err != nil {
+		return false, err
+	}
+	if strings.TrimSpace(status) == "" {
+		return false, nil
+	}
+	if _, err := runGitIn(context.Background(), w.path, "commit", "-q", "-m", message); err != nil {
+		return false, fmt.Errorf("committing changes: %w", err)
+	}
+	return true, nil
+}
+
+// hasCommits reports whether the branch has moved past its base
+func (w *worktree) hasCommits() (bool, error) {
+	count, err := runGitIn(context.Background(), w.path, "rev-list", "--count", w.base+"..HEAD")
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(count) != "0", nil
+}
+
+// diff returns the changes committed on the branch since its base
+func (w *worktree) diff(stat bool) (string, error) {
+	args := []string{"diff", "--no-color"}
+	if stat {
+		args = append(args, "--stat")
+	}
+	args = append(args, w.base, w.branch)
+	return runGitIn(context.Background(), w.parentDir, args...)
+}
+
+// merge merges the branch into the parent working tree and removes the worktree and branch
+func (w *worktree) merge() error {
+	if _, err := runGitIn(context.Background(), w.parentDir, "merge", "-q", "--no-edit", w.branch); err != nil {
+		return fmt.Errorf("merging %s: %w", w.branch, err)
+	}
+	return w.discard()
+}
+
+// discard removes the worktree and deletes its branch
+func (w *worktree) discard() error {
+	if err := w.remove(); err != nil {
+		return err
+	}
+	if _, err := runGitIn(context.Background(), w.parentDir, "branch", "-q", "-D", w.branch); err != nil {
+		return fmt.Errorf("deleting branch: %w", err)
+	}
+	return nil
+}
+
+// remove deletes the worktree directory but keeps its branch
+func (w *worktree) remove() error {
+	if _, err := runGitIn(context.Background(), w.parentDir, "worktree", "remove", "--force", w.path); err != nil {
+		return fmt.Errorf("removing worktree: %w", err)
+	}
+	os.RemoveAll(filepath.Dir(w.path))
+	return nil
+}
+
+// finishWorktree asks the user what to do with a worktree's branch once its agent has finished,
+// looping so the diff can be reviewed before deciding. It returns a description of the outcome.
+// When readLine is nil, or input ends, the branch is kept for later review.
+func finishWorktree(wt *worktree, readLine func(prompt string) (string, bool)) string {
+	changed, err := wt.hasCommits()
+	if err != nil {
+		return fmt.Sprintf("Could not inspect worktree branch %s: %v", wt.branch, err)
+	}
+	if !changed {
+		if err := wt.discard(); err != nil {
+			return fmt.Sprintf("No changes were made, but removing the worktree failed: %v", err)
+		}
+		return "No changes were made; worktree discarded."
+	}
+
+	stat, _ := wt.diff(true)
+	if readLine == nil {
+		return keepWorktreeBranch(wt, stat)
+	}
+
+	for {
+		fmt.Printf("\nChanges on branch %s:\n%s", wt.branch, stat)
+		choice, ok := readLine("[m]erge, show [d]iff, dis[c]ard or [k]eep the branch? ")
+		if !ok {
+			fmt.Println()
+			return keepWorktreeBranch(wt, stat)
+		}
+
+		switch strings.ToLower(strings.TrimSpace(choice)) {
+		case "m", "merge":
+			if err := wt.merge(); err != nil {
+				return fmt.Sprintf("Merge failed, changes kept on branch %s: %v", wt.branch, err)
+			}
+			return fmt.Sprintf("Merged branch %s:\n%s", wt.branch, stat)
+		case "d", "diff":
+			diff, err := wt.diff(false)
+			if err != nil {
+				fmt.Printf("Error showing diff: %v\n", err)
+				continue
+			}
+			fmt.Print(diff)
+		case "c", "discard":
+			if err := wt.discard(); err != nil {
+				return fmt.Sprintf("Discarding worktree failed: %v", err)
+			}
+			return "Changes discarded."
+		case "k", "keep":
+			return keepWorktreeBranch(wt, stat)
+		}
+	}
+}
+
+// finishSubAgentWorktree commits a sub-agent's work to its branch and resolves the worktree, asking
+// the user when running interactively. Failed sub-agents always keep their branch for inspection.
+func (a *Agent) finishSubAgentWorktree(wt *worktree, task string, succeeded bool) string {
+	if _, err := wt.commitAll("Sub-agent: " + firstLine(task)); err != nil {
+		return fmt.Sprintf("Could not commit sub-agent changes in %s: %v", wt.path, err)
+	}
+
+	var readLine func(prompt string) (string, bool)
+	if a.inputManager != nil && succeeded {
+		readLine = a.inputManager.ReadLine
+	}
+	return finishWorktree(wt, readLine)
+}
+
+// closeWorktree commits outstanding session changes and resolves the session worktree on exit
+func (a *Agent) closeWorktree() {
+	if a.worktree == nil {
+		return
+	}
+	if _, err := a.worktree.commitAll("Agent session " + a.sessionID); err != nil {
+		fmt.Printf("Could not commit session changes in %s: %v\n", a.worktree.path, err)
+		return
+	}
+
+	var readLine func(prompt string) (string, bool)
+	if a.inputManager != nil {
+		readLine = a.inputManager.ReadLine
+	}
+	fmt.Println(finishWorktree(a.worktree, readLine))
+	a.worktree = nil
+}
+
+// firstLine returns the first line of s
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return line
+}
+
+// keepWorktreeBranch removes the worktree directory while keeping its branch for later review
+func keepWorktreeBranch(wt *worktree, stat string) string {
+	if err := wt.remove(); err != nil {
+		return fmt.Sprintf("Changes kept in worktree %s on branch %s:\n%s", wt.path, wt.branch, stat)
+	}
+	return fmt.Sprintf("Changes kept on branch %s:\n%s", wt.branch, stat)
+}
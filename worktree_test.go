@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"agent/mocks"
+)
+
+// scriptedInput returns a readLine function that replays the given answers and then reports end of input
+func scriptedInput(answers ...string) func(prompt string) (string, bool) {
+	return func(prompt string) (string, bool) {
+		if len(answers) == 0 {
+			return "", false
+		}
+		answer := answers[0]
+		answers = answers[1:]
+		return answer, true
+	}
+}
+
+func createTestWorktree(t *testing.T) (*worktree, string) {
+	dir := initTestRepo(t)
+	wt, err := createWorktree(context.Background(), dir, "test")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(filepath.Dir(wt.path)) })
+	return wt, dir
+}
+
+func TestCreateWorktree(t *testing.T) {
+	wt, dir := createTestWorktree(t)
+
+	assert.Equal(t, "agent/test", wt.branch)
+	assert.FileExists(t, filepath.Join(wt.path, "main.go"))
+	assert.Contains(t, gitInDir(t, dir, "branch", "--list"), "agent/test")
+}
+
+func TestFinishWorktree_NoChangesDiscards(t *testing.T) {
+	wt, dir := createTestWorktree(t)
+
+	outcome := finishWorktree(wt, scriptedInput())
+
+	assert.Equal(t, "No changes were made; worktree discarded.", outcome)
+	assert.NoDirExists(t, wt.path)
+	assert.NotContains(t, gitInDir(t, dir, "branch", "--list"), "agent/test")
+}
+
+func TestFinishWorktree_DiffThenMerge(t *testing.T) {
+	wt, dir := createTestWorktree(t)
+	require.NoError(t, os.WriteFile(filepath.Join(wt.path, "feature.go"), []byte("package main\n"), 0644))
+	committed, err := wt.commitAll("Add feature")
+	require.NoError(t, err)
+	require.True(t, committed)
+
+	outcome := finishWorktree(wt, scriptedInput("d", "m"))
+
+	assert.Contains(t, outcome, "Merged branch agent/test")
+	assert.Contains(t, outcome, "feature.go")
+	assert.FileExists(t, filepath.Join(dir, "feature.go"))
+	assert.NotContains(t, gitInDir(t, dir, "branch", "--list"), "agent/test")
+}
+
+func TestFinishWorktree_Discard(t *testing.T) {
+	wt, dir := createTestWorktree(t)
+	require.NoError(t, os.WriteFile(filepath.Join(wt.path, "feature.go"), []byte("package main\n"), 0644))
+	_, err := wt.commitAll("Add feature")
+	require.NoError(t, err)
+
+	outcome := finishWorktree(wt, scriptedInput("c"))
+
+	assert.Equal(t, "Changes discarded.", outcome)
+	assert.NoFileExists(t, filepath.Join(dir, "feature.go"))
+	assert.NotContains(t, gitInDir(t, dir, "branch", "--list"), "agent/test")
+}
+
+func TestFinishWorktree_NonInteractiveKeepsBranch(t *testing.T) {
+	wt, dir := createTestWorktree(t)
+	require.NoError(t, os.WriteFile(filepath.Join(wt.path, "feature.go"), []byte("package main\n"), 0644))
+	_, err := wt.commitAll("Add feature")
+	require.NoError(t, err)
+
+	outcome := finishWorktree(wt, nil)
+
+	assert.Contains(t, outcome, "Changes kept on branch agent/test")
+	assert.NoDirExists(t, wt.path)
+	assert.Contains(t, gitInDir(t, dir, "branch", "--list"), "agent/test")
+	assert.Contains(t, gitInDir(t, dir, "show", "--name-only", "--format=", "agent/test"), "feature.go")
+}
+
+func TestHandleRunAgent_IsolatedWorktree(t *testing.T) {
+	dir := initTestRepo(t)
+	mockClient := mocks.NewMockOpenAIClient()
+	agent := NewAgent(NewOpenAIProvider(mockClient), nil, "test-model")
+	agent.workDir = dir
+	agent.isolateSubAgents = true
+
+	writeCall := mocks.CreateMockToolCall("sub-call", "write_to_file", `{"path": "isolated.txt", "content": "from sub-agent"}`)
+	mockClient.AddResponse(mocks.CreateMockResponse("", []openai.ToolCall{writeCall}))
+	mockClient.AddResponse(mocks.CreateMockResponse("done", nil))
+
+	response := agent.handleRunAgent(context.Background(), newTestToolCall("run-1", "run_agent", RunAgentInput{Task: "write a file"}))
+
+	assert.Contains(t, response.Content, "Changes kept on branch agent/sub-")
+	assert.NoFileExists(t, filepath.Join(dir, "isolated.txt"))
+	assert.Empty(t, agent.changes.drain())
+
+	branches := gitInDir(t, dir, "branch", "--list", "agent/sub-*", "--format=%(refname:short)")
+	branch := strings.TrimSpace(branches)
+	require.NotEmpty(t, branch)
+	assert.Equal(t, "from sub-agent", gitInDir(t, dir, "show", branch+":isolated.txt"))
+}
+
+func TestResolvePath(t *testing.T) {
+	agent := setupTestAgent()
+	assert.Equal(t, "a/b.txt", agent.resolvePath("a/b.txt"))
+
+	agent.workDir = "/work"
+	assert.Equal(t, filepath.Join("/work", "a/b.txt"), agent.resolvePath("a/b.txt"))
+	assert.Equal(t, "/abs/c.txt", agent.resolvePath("/abs/c.txt"))
+}